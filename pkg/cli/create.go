@@ -2,7 +2,12 @@ package cli
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
 	"syscall"
+	"time"
 
 	docker "github.com/docker/docker/client"
 	"github.com/jlevesy/sind/pkg/cli/internal"
@@ -16,10 +21,27 @@ var (
 	managers      uint16
 	workers       uint16
 	networkName   string
+	subnet        string
+	subnetPool    string
+	ipv6          bool
+	ipv6Subnet    string
 	portsMapping  []string
 	nodeImageName string
 	daemonArgs    []string
 	pull          bool
+	clusterFile   string
+	keepOnFailure bool
+	loadBalancer  bool
+
+	defaultAddrPool     []string
+	subnetSize          uint32
+	dataPathPort        uint32
+	autoLock            bool
+	taskHistoryLimit    int64
+	dispatcherHeartbeat time.Duration
+	certExpiry          time.Duration
+	snapshotInterval    uint64
+	externalCAs         []string
 
 	createCmd = &cobra.Command{
 		Use:   "create",
@@ -34,10 +56,26 @@ func init() {
 	createCmd.Flags().Uint16VarP(&managers, "managers", "m", 1, "Amount of managers in the created cluster.")
 	createCmd.Flags().Uint16VarP(&workers, "workers", "w", 0, "Amount of workers in the created cluster.")
 	createCmd.Flags().StringVarP(&networkName, "network-name", "n", "sind-default", "Name of the network to create.")
+	createCmd.Flags().StringVarP(&subnet, "subnet", "", "", "Subnet of the cluster network, picked from the subnet pool if not set.")
+	createCmd.Flags().StringVarP(&subnetPool, "subnet-pool", "", "", "Pool to pick a free subnet from for the cluster network (defaults to 10.0.0.0/16).")
+	createCmd.Flags().BoolVarP(&ipv6, "ipv6", "", false, "Create a dual stack cluster network, and form the swarm over IPv6.")
+	createCmd.Flags().StringVarP(&ipv6Subnet, "ipv6-subnet", "", "", "IPv6 subnet of the cluster network, implies --ipv6 (defaults to a random unique local /64 subnet).")
 	createCmd.Flags().StringSliceVarP(&portsMapping, "ports", "p", []string{}, "Ingress network port binding.")
 	createCmd.Flags().StringSliceVarP(&daemonArgs, "daemon-arg", "", []string{}, "Args to pass to nodes docker daemon")
 	createCmd.Flags().StringVarP(&nodeImageName, "image", "i", sind.DefaultNodeImageName, "Name of the image to use for the nodes.")
 	createCmd.Flags().BoolVarP(&pull, "pull", "", false, "Pull node image before creating the cluster.")
+	createCmd.Flags().BoolVarP(&loadBalancer, "load-balancer", "", false, "Publish ports on a load balancer spreading the traffic across managers, instead of the primary node.")
+	createCmd.Flags().BoolVarP(&keepOnFailure, "keep-on-failure", "", false, "Keep the created resources if the cluster creation fails, for debugging purposes.")
+	createCmd.Flags().StringSliceVarP(&defaultAddrPool, "default-addr-pool", "", []string{}, "Default address pool of the swarm overlay networks, in CIDR format.")
+	createCmd.Flags().Uint32VarP(&subnetSize, "default-addr-pool-mask-length", "", 0, "Prefix length of the subnets allocated from the default address pool.")
+	createCmd.Flags().Uint32VarP(&dataPathPort, "data-path-port", "", 0, "Port used for the swarm data path traffic.")
+	createCmd.Flags().BoolVarP(&autoLock, "autolock", "", false, "Enable manager autolocking, requiring an unlock key to restart a manager.")
+	createCmd.Flags().Int64VarP(&taskHistoryLimit, "task-history-limit", "", 5, "Task history retention limit of the swarm.")
+	createCmd.Flags().DurationVarP(&dispatcherHeartbeat, "dispatcher-heartbeat", "", 0, "Dispatcher heartbeat period of the swarm.")
+	createCmd.Flags().DurationVarP(&certExpiry, "cert-expiry", "", 0, "Validity period of the swarm node certificates.")
+	createCmd.Flags().Uint64VarP(&snapshotInterval, "snapshot-interval", "", 0, "Number of raft log entries between snapshots.")
+	createCmd.Flags().StringSliceVarP(&externalCAs, "external-ca", "", []string{}, "Specifications of an external CA issuing the node certificates, as protocol=cfssl,url=<url>[,cacert=<path>].")
+	createCmd.Flags().StringVarP(&clusterFile, "file", "f", "", "Path to a cluster definition file, flags explicitly set take precedence over it.")
 }
 
 func runCreate(cmd *cobra.Command, args []string) {
@@ -47,6 +85,13 @@ func runCreate(cmd *cobra.Command, args []string) {
 	ctx, cancel = internal.WithSignal(ctx, syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
+	clusterConfig, err := clusterConfiguration(cmd)
+	if err != nil {
+		fail(err)
+	}
+
+	clusterConfig.KeepOnFailure = keepOnFailure
+
 	disgo.StartStep("Connecting to the docker daemon")
 
 	client, err := docker.NewClientWithOpts(internal.DefaultDockerOpts...)
@@ -54,35 +99,221 @@ func runCreate(cmd *cobra.Command, args []string) {
 		fail(disgo.FailStepf("Unable to connect to the docker daemon: %v", err))
 	}
 
-	disgo.StartStepf("Checking if a cluster named %q already exists", clusterName)
+	disgo.StartStepf("Checking if a cluster named %q already exists", clusterConfig.ClusterName)
 
-	clusterInfo, err := sind.InspectCluster(ctx, client, clusterName)
+	clusterInfo, err := sind.InspectCluster(ctx, client, clusterConfig.ClusterName)
 	if err != nil {
 		fail(disgo.FailStepf("Unable to check if the cluster already exists: %v", err))
 	}
 
 	// If cluster info is not nil, then the cluster exist.
 	if clusterInfo != nil {
-		fail(disgo.FailStepf("Cluster %q already exists, run sind delete first to remove it.", clusterName))
+		fail(disgo.FailStepf("Cluster %q already exists, run sind delete first to remove it.", clusterConfig.ClusterName))
 	}
 
-	disgo.StartStepf("Creating a new cluster %q with %d managers and %d workers", clusterName, managers, workers)
+	disgo.StartStepf(
+		"Creating a new cluster %q with %d managers and %d workers",
+		clusterConfig.ClusterName,
+		clusterConfig.Managers,
+		clusterConfig.Workers,
+	)
 
-	clusterConfig := sind.ClusterConfiguration{
-		Managers:     managers,
-		Workers:      workers,
-		NetworkName:  networkName,
-		ClusterName:  clusterName,
-		PortBindings: portsMapping,
-		ImageName:    nodeImageName,
-		PullImage:    pull,
-		DaemonArgs:   daemonArgs,
-	}
+	progress := internal.NewProgress(os.Stdout, !nonInteractive && internal.IsTerminal(os.Stdout))
+	clusterConfig.Progress = progress.Handle
 
-	if err := sind.CreateCluster(ctx, client, clusterConfig); err != nil {
-		fail(disgo.FailStepf("Unable to create cluster %q: %v", clusterName, err))
+	err = sind.CreateCluster(ctx, client, *clusterConfig)
+	progress.Done()
+
+	if err != nil {
+		fail(disgo.FailStepf("Unable to create cluster %q: %v", clusterConfig.ClusterName, err))
 	}
 
 	disgo.EndStep()
-	disgo.Infof("%s Cluster %q successfully created\n", style.Success(style.SymbolCheck), clusterName)
+	disgo.Infof("%s Cluster %q successfully created\n", style.Success(style.SymbolCheck), clusterConfig.ClusterName)
+}
+
+// clusterConfiguration builds the cluster configuration from the cluster file if any, then from the flags.
+func clusterConfiguration(cmd *cobra.Command) (*sind.ClusterConfiguration, error) {
+	if clusterFile == "" {
+		cfg := sind.ClusterConfiguration{
+			Managers:     managers,
+			Workers:      workers,
+			NetworkName:  networkName,
+			Subnet:       subnet,
+			SubnetPool:   subnetPool,
+			IPv6:         ipv6,
+			IPv6Subnet:   ipv6Subnet,
+			ClusterName:  clusterName,
+			PortBindings: portsMapping,
+			ImageName:    nodeImageName,
+			PullImage:    pull,
+			DaemonArgs:   daemonArgs,
+			LoadBalancer: loadBalancer,
+		}
+
+		if err := applySwarmFlags(cmd, &cfg.Swarm); err != nil {
+			return nil, err
+		}
+
+		return &cfg, nil
+	}
+
+	file, err := os.Open(clusterFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open cluster file %q: %v", clusterFile, err)
+	}
+	defer file.Close()
+
+	cfg, err := sind.LoadClusterConfiguration(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load cluster file %q: %v", clusterFile, err)
+	}
+
+	flags := cmd.Flags()
+
+	if flags.Changed("cluster") {
+		cfg.ClusterName = clusterName
+	}
+
+	if flags.Changed("managers") {
+		cfg.Managers = managers
+	}
+
+	if flags.Changed("workers") {
+		cfg.Workers = workers
+	}
+
+	if flags.Changed("network-name") {
+		cfg.NetworkName = networkName
+	}
+
+	if flags.Changed("subnet") {
+		cfg.Subnet = subnet
+	}
+
+	if flags.Changed("subnet-pool") {
+		cfg.SubnetPool = subnetPool
+	}
+
+	if flags.Changed("ipv6") {
+		cfg.IPv6 = ipv6
+	}
+
+	if flags.Changed("ipv6-subnet") {
+		cfg.IPv6Subnet = ipv6Subnet
+	}
+
+	if flags.Changed("ports") {
+		cfg.PortBindings = portsMapping
+	}
+
+	if flags.Changed("daemon-arg") {
+		cfg.DaemonArgs = daemonArgs
+	}
+
+	if flags.Changed("image") {
+		cfg.ImageName = nodeImageName
+	}
+
+	if flags.Changed("pull") {
+		cfg.PullImage = pull
+	}
+
+	if flags.Changed("load-balancer") {
+		cfg.LoadBalancer = loadBalancer
+	}
+
+	if err := applySwarmFlags(cmd, &cfg.Swarm); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// applySwarmFlags overrides the swarm options with the swarm flags explicitly set.
+func applySwarmFlags(cmd *cobra.Command, opts *sind.SwarmOptions) error {
+	flags := cmd.Flags()
+
+	if flags.Changed("default-addr-pool") {
+		opts.DefaultAddrPool = defaultAddrPool
+	}
+
+	if flags.Changed("default-addr-pool-mask-length") {
+		opts.SubnetSize = subnetSize
+	}
+
+	if flags.Changed("data-path-port") {
+		opts.DataPathPort = dataPathPort
+	}
+
+	if flags.Changed("autolock") {
+		opts.AutoLock = autoLock
+	}
+
+	if flags.Changed("task-history-limit") {
+		opts.TaskHistoryLimit = &taskHistoryLimit
+	}
+
+	if flags.Changed("dispatcher-heartbeat") {
+		opts.DispatcherHeartbeat = dispatcherHeartbeat
+	}
+
+	if flags.Changed("cert-expiry") {
+		opts.NodeCertExpiry = certExpiry
+	}
+
+	if flags.Changed("snapshot-interval") {
+		opts.SnapshotInterval = snapshotInterval
+	}
+
+	if flags.Changed("external-ca") {
+		opts.ExternalCAs = nil
+
+		for _, spec := range externalCAs {
+			ca, err := parseExternalCA(spec)
+			if err != nil {
+				return err
+			}
+
+			opts.ExternalCAs = append(opts.ExternalCAs, ca)
+		}
+	}
+
+	return nil
+}
+
+// parseExternalCA parses an external CA specification, using the docker swarm init format.
+func parseExternalCA(spec string) (sind.ExternalCA, error) {
+	var ca sind.ExternalCA
+
+	for _, field := range strings.Split(spec, ",") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return ca, fmt.Errorf("invalid external CA field %q, expected key=value", field)
+		}
+
+		key, value := strings.ToLower(parts[0]), parts[1]
+
+		switch key {
+		case "protocol":
+			ca.Protocol = strings.ToLower(value)
+		case "url":
+			ca.URL = value
+		case "cacert":
+			cert, err := ioutil.ReadFile(value)
+			if err != nil {
+				return ca, fmt.Errorf("unable to read external CA certificate %q: %v", value, err)
+			}
+
+			ca.CACert = string(cert)
+		default:
+			if ca.Options == nil {
+				ca.Options = make(map[string]string)
+			}
+
+			ca.Options[key] = value
+		}
+	}
+
+	return ca, nil
 }
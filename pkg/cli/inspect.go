@@ -13,6 +13,8 @@ import (
 )
 
 var (
+	inspectSwarm bool
+
 	inspectCmd = &cobra.Command{
 		Use:   "inspect",
 		Short: "Inspect a specific cluster.",
@@ -22,6 +24,9 @@ var (
 
 func init() {
 	rootCmd.AddCommand(inspectCmd)
+	addOutputFlags(inspectCmd)
+
+	inspectCmd.Flags().BoolVarP(&inspectSwarm, "swarm", "", false, "Query the swarm for the state of each node.")
 }
 
 func runInspect(cmd *cobra.Command, args []string) {
@@ -31,6 +36,8 @@ func runInspect(cmd *cobra.Command, args []string) {
 	ctx, cancel = internal.WithSignal(ctx, syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
+	setupOutput()
+
 	disgo.StartStep("Connecting to the docker daemon")
 
 	client, err := docker.NewClientWithOpts(internal.DefaultDockerOpts...)
@@ -40,9 +47,16 @@ func runInspect(cmd *cobra.Command, args []string) {
 
 	disgo.StartStepf("Checking if a cluster named %q already exists", clusterName)
 
-	clusterInfo, err := sind.InspectCluster(ctx, client, clusterName)
+	var clusterInfo *sind.ClusterStatus
+
+	if inspectSwarm {
+		clusterInfo, err = sind.InspectClusterWithSwarm(ctx, client, clusterName)
+	} else {
+		clusterInfo, err = sind.InspectCluster(ctx, client, clusterName)
+	}
+
 	if err != nil {
-		fail(disgo.FailStepf("Unable to check if the cluster already exists: %v", err))
+		fail(disgo.FailStepf("Unable to inspect cluster %q: %v", clusterName, err))
 	}
 
 	if clusterInfo == nil {
@@ -51,5 +65,15 @@ func runInspect(cmd *cobra.Command, args []string) {
 
 	disgo.EndStep()
 
+	if outputFormat != internal.OutputTable {
+		report := clusterInfo.Report()
+
+		if err = internal.RenderStructured(os.Stdout, outputFormat, outputTemplate, report, report); err != nil {
+			fail(err)
+		}
+
+		return
+	}
+
 	internal.RenderCluster(os.Stdout, *clusterInfo)
 }
@@ -20,11 +20,16 @@ func RenderCluster(out io.Writer, cluster sind.ClusterStatus) {
 		wr,
 		"Name: %s\tStatus: %s\tManagers: %s\t Workers: %s\t\n",
 		style.Important(cluster.Name),
-		style.Important(status(cluster)),
+		style.Important(strings.Title(cluster.State())),
 		style.Important(fmt.Sprintf("%d/%d", cluster.ManagersRunning, cluster.Managers)),
 		style.Important(fmt.Sprintf("%d/%d", cluster.WorkersRunning, cluster.Workers)),
 	)
 
+	if cluster.Swarm != nil {
+		renderSwarmNodes(wr, cluster)
+		return
+	}
+
 	fmt.Fprintf(wr, "ID\tImage\tRole\tStatus\tIPs\t\n")
 	fmt.Fprintf(wr, "--\t-----\t----\t------\t---\t\n")
 
@@ -41,6 +46,41 @@ func RenderCluster(out io.Writer, cluster sind.ClusterStatus) {
 	}
 }
 
+func renderSwarmNodes(wr io.Writer, cluster sind.ClusterStatus) {
+	fmt.Fprintf(wr, "ID\tImage\tRole\tStatus\tIPs\tSwarm ID\tAvailability\tSwarm Status\tManager Status\tEngine\t\n")
+	fmt.Fprintf(wr, "--\t-----\t----\t------\t---\t--------\t------------\t------------\t--------------\t------\t\n")
+
+	for _, node := range cluster.Nodes {
+		swarmNode, ok := cluster.Swarm[node.ID]
+		if !ok {
+			swarmNode = sind.SwarmNodeStatus{ID: "-", Availability: "-", State: "not a member"}
+		}
+
+		fmt.Fprintf(
+			wr,
+			"%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n",
+			node.ID[0:11],
+			node.Image,
+			clusterRole(node),
+			node.Status,
+			nodeIP(node),
+			swarmNode.ID,
+			swarmNode.Availability,
+			swarmNode.State,
+			managerStatus(swarmNode),
+			swarmNode.EngineVersion,
+		)
+	}
+}
+
+func managerStatus(node sind.SwarmNodeStatus) string {
+	if node.Leader {
+		return "leader"
+	}
+
+	return node.Reachability
+}
+
 func clusterRole(node types.Container) string {
 	return node.Labels["com.sind.cluster.role"]
 }
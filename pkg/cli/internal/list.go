@@ -3,6 +3,7 @@ package internal
 import (
 	"fmt"
 	"io"
+	"strings"
 	"text/tabwriter"
 
 	"github.com/jlevesy/sind/pkg/sind"
@@ -21,7 +22,7 @@ func RenderClusterList(out io.Writer, clusters []sind.ClusterStatus) {
 			wr,
 			"%s\t%s\t%d/%d\t%d/%d\t\n",
 			cluster.Name,
-			status(cluster),
+			strings.Title(cluster.State()),
 			cluster.ManagersRunning,
 			cluster.Managers,
 			cluster.WorkersRunning,
@@ -29,15 +30,3 @@ func RenderClusterList(out io.Writer, clusters []sind.ClusterStatus) {
 		)
 	}
 }
-
-func status(cluster sind.ClusterStatus) string {
-	if cluster.ManagersRunning == 0 && cluster.WorkersRunning == 0 {
-		return "Stopped"
-	}
-
-	if cluster.ManagersRunning == cluster.Managers && cluster.WorkersRunning == cluster.Workers {
-		return "Running"
-	}
-
-	return "Unstable"
-}
@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Supported output formats.
+const (
+	OutputTable    = "table"
+	OutputJSON     = "json"
+	OutputYAML     = "yaml"
+	OutputTemplate = "template"
+)
+
+// ValidateOutput checks that given output format is supported, and that a template is given when required.
+func ValidateOutput(format, tmpl string) error {
+	switch format {
+	case OutputTable, OutputJSON, OutputYAML:
+		return nil
+	case OutputTemplate:
+		if tmpl == "" {
+			return errors.New("a template is required for the template output")
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unsupported output %q, must be one of %s, %s, %s or %s", format, OutputTable, OutputJSON, OutputYAML, OutputTemplate)
+	}
+}
+
+// RenderStructured renders value to given output using a structured format.
+// In template format, the template is executed once per given item.
+func RenderStructured(out io.Writer, format, tmpl string, value interface{}, items ...interface{}) error {
+	switch format {
+	case OutputJSON:
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(value)
+	case OutputYAML:
+		enc := yaml.NewEncoder(out)
+		defer enc.Close()
+
+		return enc.Encode(value)
+	case OutputTemplate:
+		t, err := template.New("output").Parse(tmpl)
+		if err != nil {
+			return fmt.Errorf("invalid template: %v", err)
+		}
+
+		for _, item := range items {
+			if err = t.Execute(out, item); err != nil {
+				return fmt.Errorf("unable to render template: %v", err)
+			}
+
+			fmt.Fprintln(out)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unsupported output %q", format)
+	}
+}
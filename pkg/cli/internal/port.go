@@ -0,0 +1,22 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/jlevesy/sind/pkg/sind"
+)
+
+// RenderPortList renders a list of published ports in the given output.
+func RenderPortList(out io.Writer, ports []sind.PortProxy) {
+	wr := tabwriter.NewWriter(out, 4, 8, 2, '\t', 0)
+	defer wr.Flush()
+
+	fmt.Fprintf(wr, "\nBinding\tStatus\tID\t\n")
+	fmt.Fprintf(wr, "-------\t------\t--\t\n")
+
+	for _, port := range ports {
+		fmt.Fprintf(wr, "%s\t%s\t%s\t\n", port.Binding, port.State, port.ContainerID[0:11])
+	}
+}
@@ -0,0 +1,191 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	units "github.com/docker/go-units"
+	"github.com/jlevesy/sind/pkg/sind"
+)
+
+const (
+	progressBarWidth = 30
+	// progressRedrawPeriod throttles the redraws caused by byte counts, state changes are always drawn.
+	progressRedrawPeriod = 100 * time.Millisecond
+	// progressHost is the name of the line reporting the progress of the host.
+	progressHost = "host"
+)
+
+// Progress renders the progress events of a cluster operation, one line per node.
+// When interactive, the lines are progress bars redrawn in place, otherwise a line is printed on each node state change.
+type Progress struct {
+	out         io.Writer
+	interactive bool
+
+	mu      sync.Mutex
+	names   []string
+	lines   map[string]*progressLine
+	started bool
+	drawn   int
+	drawnAt time.Time
+}
+
+type progressLine struct {
+	kind  sind.ProgressKind
+	bytes int64
+	total int64
+}
+
+// NewProgress returns a progress renderer writing to given output.
+func NewProgress(out io.Writer, interactive bool) *Progress {
+	return &Progress{
+		out:         out,
+		interactive: interactive,
+		lines:       make(map[string]*progressLine),
+	}
+}
+
+// IsTerminal returns true if given file is a terminal.
+func IsTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Handle renders a progress event, it can be used as a sind.ProgressFunc.
+func (p *Progress) Handle(event sind.ProgressEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	name := event.Node
+	if name == "" {
+		name = progressHost
+	}
+
+	line, ok := p.lines[name]
+	if !ok {
+		line = &progressLine{}
+		p.lines[name] = line
+		p.names = append(p.names, name)
+	}
+
+	changed := line.kind != event.Kind
+
+	line.kind = event.Kind
+	line.total = event.Total
+
+	if event.Bytes > 0 {
+		line.bytes = event.Bytes
+	}
+
+	if !p.interactive {
+		if changed && !isByteCount(event.Kind) {
+			p.start()
+			fmt.Fprintf(p.out, "  %s: %s\n", name, line.status())
+		}
+
+		return
+	}
+
+	if changed || time.Since(p.drawnAt) >= progressRedrawPeriod {
+		p.draw()
+	}
+}
+
+// Done draws the final state of the lines.
+func (p *Progress) Done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.interactive && len(p.names) > 0 {
+		p.draw()
+	}
+}
+
+// start moves the output below the label of the current step, before the first line is written.
+func (p *Progress) start() {
+	if !p.started {
+		fmt.Fprintln(p.out)
+		p.started = true
+	}
+}
+
+// draw redraws all the lines in place.
+func (p *Progress) draw() {
+	p.start()
+
+	if p.drawn > 0 {
+		fmt.Fprintf(p.out, "\033[%dA", p.drawn)
+	}
+
+	width := 0
+
+	for _, name := range p.names {
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+
+	for _, name := range p.names {
+		line := p.lines[name]
+		fmt.Fprintf(p.out, "\033[2K  %-*s %s %s\n", width, name, line.bar(), line.status())
+	}
+
+	p.drawn = len(p.names)
+	p.drawnAt = time.Now()
+}
+
+// bar returns the progress bar of the line, full once the node is done.
+func (l *progressLine) bar() string {
+	filled := 0
+
+	switch {
+	case !isByteCount(l.kind):
+		filled = progressBarWidth
+	case l.total > 0:
+		filled = int(int64(progressBarWidth) * l.bytes / l.total)
+		if filled > progressBarWidth {
+			filled = progressBarWidth
+		}
+	}
+
+	return "[" + strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled) + "]"
+}
+
+// status returns the state of the line, with the bytes processed so far.
+func (l *progressLine) status() string {
+	switch l.kind {
+	case sind.ProgressImageSaved:
+		return "saving " + l.size()
+	case sind.ProgressImageCopied:
+		return "copying " + l.size()
+	case sind.ProgressImageLoaded:
+		return "loaded " + units.HumanSize(float64(l.bytes))
+	case sind.ProgressNodeCreated:
+		return "created"
+	case sind.ProgressNodeJoined:
+		return "joined"
+	default:
+		return string(l.kind)
+	}
+}
+
+func (l *progressLine) size() string {
+	if l.total == 0 {
+		return units.HumanSize(float64(l.bytes))
+	}
+
+	return units.HumanSize(float64(l.bytes)) + "/" + units.HumanSize(float64(l.total))
+}
+
+// isByteCount returns true if given event kind reports an amount of bytes in progress.
+func isByteCount(kind sind.ProgressKind) bool {
+	return kind == sind.ProgressImageSaved || kind == sind.ProgressImageCopied
+}
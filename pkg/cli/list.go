@@ -24,6 +24,7 @@ var (
 
 func init() {
 	rootCmd.AddCommand(listCmd)
+	addOutputFlags(listCmd)
 }
 
 func runList(cmd *cobra.Command, args []string) {
@@ -33,6 +34,8 @@ func runList(cmd *cobra.Command, args []string) {
 	ctx, cancel = internal.WithSignal(ctx, syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
+	setupOutput()
+
 	disgo.StartStep("Connecting to the docker daemon")
 
 	client, err := docker.NewClientWithOpts(internal.DefaultDockerOpts...)
@@ -50,6 +53,23 @@ func runList(cmd *cobra.Command, args []string) {
 	disgo.EndStep()
 	disgo.Infof("%s Found %d cluster(s)\n", style.Success(style.SymbolCheck), len(clusters))
 
+	if outputFormat != internal.OutputTable {
+		reports := make([]sind.ClusterReport, 0, len(clusters))
+		items := make([]interface{}, 0, len(clusters))
+
+		for _, cluster := range clusters {
+			report := cluster.Report()
+			reports = append(reports, report)
+			items = append(items, report)
+		}
+
+		if err = internal.RenderStructured(os.Stdout, outputFormat, outputTemplate, reports, items...); err != nil {
+			fail(err)
+		}
+
+		return
+	}
+
 	if len(clusters) == 0 {
 		return
 	}
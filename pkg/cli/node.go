@@ -0,0 +1,232 @@
+package cli
+
+import (
+	"context"
+	"syscall"
+
+	"github.com/docker/docker/api/types/swarm"
+	docker "github.com/docker/docker/client"
+	"github.com/jlevesy/sind/pkg/cli/internal"
+	"github.com/jlevesy/sind/pkg/sind"
+	"github.com/spf13/cobra"
+	"github.com/ullaakut/disgo"
+	"github.com/ullaakut/disgo/style"
+)
+
+var (
+	nodeRole         string
+	nodeImage        string
+	nodeDaemonArgs   []string
+	nodeLabels       map[string]string
+	nodeEngineLabels map[string]string
+
+	nodeCmd = &cobra.Command{
+		Use:   "node",
+		Short: "Manage the nodes of a cluster.",
+	}
+
+	nodeAddCmd = &cobra.Command{
+		Use:   "add",
+		Short: "Add a node to a running cluster.",
+		Args:  cobra.NoArgs,
+		Run:   runNodeAdd,
+	}
+
+	nodeRemoveCmd = &cobra.Command{
+		Use:     "rm <node>",
+		Aliases: []string{"remove"},
+		Short:   "Remove a node from a running cluster.",
+		Args:    cobra.ExactArgs(1),
+		Run:     runNodeRemove,
+	}
+
+	nodePromoteCmd = &cobra.Command{
+		Use:   "promote <node>",
+		Short: "Promote a worker node to manager.",
+		Args:  cobra.ExactArgs(1),
+		Run: runNodeUpdate("Promoting", "promoted", func(ctx context.Context, client *docker.Client, nodeName string) error {
+			return sind.PromoteNode(ctx, client, clusterName, nodeName)
+		}),
+	}
+
+	nodeDemoteCmd = &cobra.Command{
+		Use:   "demote <node>",
+		Short: "Demote a manager node to worker.",
+		Args:  cobra.ExactArgs(1),
+		Run: runNodeUpdate("Demoting", "demoted", func(ctx context.Context, client *docker.Client, nodeName string) error {
+			return sind.DemoteNode(ctx, client, clusterName, nodeName)
+		}),
+	}
+
+	nodeDrainCmd = &cobra.Command{
+		Use:   "drain <node>",
+		Short: "Drain a node, its tasks are rescheduled on other nodes.",
+		Args:  cobra.ExactArgs(1),
+		Run:   runNodeUpdate("Draining", "drained", nodeAvailabilityUpdate(swarm.NodeAvailabilityDrain)),
+	}
+
+	nodeActivateCmd = &cobra.Command{
+		Use:   "activate <node>",
+		Short: "Activate a node, new tasks can be scheduled on it.",
+		Args:  cobra.ExactArgs(1),
+		Run:   runNodeUpdate("Activating", "activated", nodeAvailabilityUpdate(swarm.NodeAvailabilityActive)),
+	}
+
+	nodeElectCmd = &cobra.Command{
+		Use:   "elect [node]",
+		Short: "Elect a running manager as primary node, when the primary node is not running.",
+		Args:  cobra.MaximumNArgs(1),
+		Run:   runNodeElect,
+	}
+
+	nodePauseCmd = &cobra.Command{
+		Use:   "pause <node>",
+		Short: "Pause a node, no new task is scheduled on it.",
+		Args:  cobra.ExactArgs(1),
+		Run:   runNodeUpdate("Pausing", "paused", nodeAvailabilityUpdate(swarm.NodeAvailabilityPause)),
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(nodeCmd)
+	nodeCmd.AddCommand(nodeAddCmd)
+	nodeCmd.AddCommand(nodeRemoveCmd)
+	nodeCmd.AddCommand(nodePromoteCmd)
+	nodeCmd.AddCommand(nodeDemoteCmd)
+	nodeCmd.AddCommand(nodeDrainCmd)
+	nodeCmd.AddCommand(nodeActivateCmd)
+	nodeCmd.AddCommand(nodePauseCmd)
+	nodeCmd.AddCommand(nodeElectCmd)
+
+	nodeAddCmd.Flags().StringVarP(&nodeRole, "role", "r", sind.NodeRoleWorker, "Role of the node, manager or worker.")
+	nodeAddCmd.Flags().StringVarP(&nodeImage, "image", "i", "", "Name of the image to use for the node (defaults to the primary node image).")
+	nodeAddCmd.Flags().StringSliceVarP(&nodeDaemonArgs, "daemon-arg", "", nil, "Args to pass to the node docker daemon (defaults to the daemon args recorded for the cluster).")
+	nodeAddCmd.Flags().StringToStringVarP(&nodeLabels, "label", "l", nil, "Swarm node label to apply to the node.")
+	nodeAddCmd.Flags().StringToStringVarP(&nodeEngineLabels, "engine-label", "", nil, "Docker engine label to apply to the node.")
+}
+
+func runNodeAdd(cmd *cobra.Command, args []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ctx, cancel = internal.WithSignal(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	client := connectCluster(ctx)
+
+	disgo.StartStepf("Adding a %s node to cluster %q", nodeRole, clusterName)
+
+	nodeName, err := sind.AddNode(
+		ctx,
+		client,
+		clusterName,
+		nodeRole,
+		sind.NodeConfiguration{
+			ImageName:    nodeImage,
+			DaemonArgs:   nodeDaemonArgs,
+			Labels:       nodeLabels,
+			EngineLabels: nodeEngineLabels,
+		},
+	)
+	if err != nil {
+		fail(disgo.FailStepf("Unable to add a node to cluster %q: %v", clusterName, err))
+	}
+
+	disgo.EndStep()
+	disgo.Infof("%s Node %q successfully added to cluster %q\n", style.Success(style.SymbolCheck), nodeName, clusterName)
+}
+
+func runNodeRemove(cmd *cobra.Command, args []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ctx, cancel = internal.WithSignal(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	client := connectCluster(ctx)
+
+	disgo.StartStepf("Removing node %q from cluster %q", args[0], clusterName)
+
+	if err := sind.RemoveNode(ctx, client, clusterName, args[0]); err != nil {
+		fail(disgo.FailStepf("Unable to remove node %q from cluster %q: %v", args[0], clusterName, err))
+	}
+
+	disgo.EndStep()
+	disgo.Infof("%s Node %q successfully removed from cluster %q\n", style.Success(style.SymbolCheck), args[0], clusterName)
+}
+
+func runNodeElect(cmd *cobra.Command, args []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ctx, cancel = internal.WithSignal(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	client := connectCluster(ctx)
+
+	var nodeName string
+	if len(args) > 0 {
+		nodeName = args[0]
+	}
+
+	disgo.StartStepf("Electing a new primary node for cluster %q", clusterName)
+
+	nodeName, err := sind.ElectPrimary(ctx, client, clusterName, nodeName)
+	if err != nil {
+		fail(disgo.FailStepf("Unable to elect a new primary node for cluster %q: %v", clusterName, err))
+	}
+
+	disgo.EndStep()
+	disgo.Infof("%s Node %q is the new primary node of cluster %q\n", style.Success(style.SymbolCheck), nodeName, clusterName)
+}
+
+// runNodeUpdate returns a command applying given update to the node passed as argument.
+func runNodeUpdate(action, done string, update func(context.Context, *docker.Client, string) error) func(*cobra.Command, []string) {
+	return func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		ctx, cancel = internal.WithSignal(ctx, syscall.SIGINT, syscall.SIGTERM)
+		defer cancel()
+
+		client := connectCluster(ctx)
+
+		disgo.StartStepf("%s node %q of cluster %q", action, args[0], clusterName)
+
+		if err := update(ctx, client, args[0]); err != nil {
+			fail(disgo.FailStepf("Unable to update node %q of cluster %q: %v", args[0], clusterName, err))
+		}
+
+		disgo.EndStep()
+		disgo.Infof("%s Node %q of cluster %q successfully %s\n", style.Success(style.SymbolCheck), args[0], clusterName, done)
+	}
+}
+
+func nodeAvailabilityUpdate(availability swarm.NodeAvailability) func(context.Context, *docker.Client, string) error {
+	return func(ctx context.Context, client *docker.Client, nodeName string) error {
+		return sind.SetNodeAvailability(ctx, client, clusterName, nodeName, availability)
+	}
+}
+
+// connectCluster connects to the docker daemon and checks that the cluster exists.
+func connectCluster(ctx context.Context) *docker.Client {
+	disgo.StartStep("Connecting to the docker daemon")
+
+	client, err := docker.NewClientWithOpts(internal.DefaultDockerOpts...)
+	if err != nil {
+		fail(disgo.FailStepf("Unable to connect to the docker daemon: %v", err))
+	}
+
+	disgo.StartStepf("Checking if a cluster named %q exists", clusterName)
+
+	clusterInfo, err := sind.InspectCluster(ctx, client, clusterName)
+	if err != nil {
+		fail(disgo.FailStepf("Unable to check if the cluster exists: %v", err))
+	}
+
+	if clusterInfo == nil {
+		fail(disgo.FailStepf("Cluster %q does not exists", clusterName))
+	}
+
+	return client
+}
@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"syscall"
+
+	"github.com/jlevesy/sind/pkg/cli/internal"
+	"github.com/jlevesy/sind/pkg/sind"
+	"github.com/spf13/cobra"
+	"github.com/ullaakut/disgo"
+	"github.com/ullaakut/disgo/style"
+)
+
+var (
+	portCmd = &cobra.Command{
+		Use:   "port",
+		Short: "Manage the ports published by a running cluster.",
+	}
+
+	portAddCmd = &cobra.Command{
+		Use:   "add <hostPort:port>",
+		Short: "Publish a port of the ingress network of a running cluster.",
+		Args:  cobra.ExactArgs(1),
+		Run:   runPortAdd,
+	}
+
+	portListCmd = &cobra.Command{
+		Use:     "ls",
+		Aliases: []string{"list"},
+		Short:   "List the ports published on a running cluster.",
+		Args:    cobra.NoArgs,
+		Run:     runPortList,
+	}
+
+	portRemoveCmd = &cobra.Command{
+		Use:     "rm <hostPort:port>",
+		Aliases: []string{"remove"},
+		Short:   "Remove a port published on a running cluster.",
+		Args:    cobra.ExactArgs(1),
+		Run:     runPortRemove,
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(portCmd)
+	portCmd.AddCommand(portAddCmd)
+	portCmd.AddCommand(portListCmd)
+	portCmd.AddCommand(portRemoveCmd)
+}
+
+func runPortAdd(cmd *cobra.Command, args []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ctx, cancel = internal.WithSignal(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	client := connectCluster(ctx)
+
+	disgo.StartStepf("Publishing port %q on cluster %q", args[0], clusterName)
+
+	if err := sind.AddPort(ctx, client, clusterName, args[0]); err != nil {
+		fail(disgo.FailStepf("Unable to publish port %q on cluster %q: %v", args[0], clusterName, err))
+	}
+
+	disgo.EndStep()
+	disgo.Infof("%s Port %q successfully published on cluster %q\n", style.Success(style.SymbolCheck), args[0], clusterName)
+}
+
+func runPortList(cmd *cobra.Command, args []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ctx, cancel = internal.WithSignal(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	client := connectCluster(ctx)
+
+	disgo.StartStepf("Listing ports published on cluster %q", clusterName)
+
+	ports, err := sind.ListPorts(ctx, client, clusterName)
+	if err != nil {
+		fail(disgo.FailStepf("Unable to list ports of cluster %q: %v", clusterName, err))
+	}
+
+	disgo.EndStep()
+	disgo.Infof("%s Found %d port(s)\n", style.Success(style.SymbolCheck), len(ports))
+
+	if len(ports) == 0 {
+		return
+	}
+
+	internal.RenderPortList(os.Stdout, ports)
+}
+
+func runPortRemove(cmd *cobra.Command, args []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ctx, cancel = internal.WithSignal(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	client := connectCluster(ctx)
+
+	disgo.StartStepf("Removing port %q from cluster %q", args[0], clusterName)
+
+	if err := sind.RemovePort(ctx, client, clusterName, args[0]); err != nil {
+		fail(disgo.FailStepf("Unable to remove port %q from cluster %q: %v", args[0], clusterName, err))
+	}
+
+	disgo.EndStep()
+	disgo.Infof("%s Port %q successfully removed from cluster %q\n", style.Success(style.SymbolCheck), args[0], clusterName)
+}
@@ -20,15 +20,19 @@ var (
 		Run:   runPush,
 	}
 
-	filePath string
-	jobs     int
+	filePath  string
+	jobs      int
+	pushRoles []string
+	pushNodes []string
 )
 
 func init() {
 	rootCmd.AddCommand(pushCmd)
 
-	pushCmd.Flags().StringVarP(&filePath, "file", "f", "", "Path to an image archive.")
+	pushCmd.Flags().StringVarP(&filePath, "file", "f", "", "Path to an image archive, as produced by docker save and optionally compressed with gzip or zstd, or to an OCI image layout directory.")
 	pushCmd.Flags().IntVarP(&jobs, "jobs", "j", 1, "How many pushes in parallel (0 means auto).")
+	pushCmd.Flags().StringSliceVarP(&pushRoles, "role", "", nil, "Only push to the nodes of given roles, manager or worker (can be repeated).")
+	pushCmd.Flags().StringSliceVarP(&pushNodes, "node", "", nil, "Only push to the nodes of given names (can be repeated).")
 }
 
 func runPush(cmd *cobra.Command, args []string) {
@@ -56,22 +60,33 @@ func runPush(cmd *cobra.Command, args []string) {
 		fail(disgo.FailStepf("Cluster %q does not exists", clusterName))
 	}
 
+	progress := internal.NewProgress(os.Stdout, !nonInteractive && internal.IsTerminal(os.Stdout))
+	opts := sind.PushOptions{Jobs: jobs, Roles: pushRoles, Nodes: pushNodes, Progress: progress.Handle}
+
 	if filePath != "" {
-		pushFile(ctx, client, clusterName, filePath)
+		pushFile(ctx, client, clusterName, filePath, opts, progress)
 		return
 	}
 
 	disgo.StartStepf("Pushing images %q to cluster %q", args, clusterName)
 
-	if err = sind.PushImageRefs(ctx, client, clusterInfo.Name, jobs, args); err != nil {
+	result, err := sind.PushImageRefsWithOptions(ctx, client, clusterInfo.Name, opts, args)
+	progress.Done()
+
+	if err != nil {
 		fail(disgo.FailStepf("Unable to push images %q to %q: %v", args, clusterName, err))
 	}
 
 	disgo.EndStep()
+
+	if len(result.Skipped) > 0 {
+		disgo.Infof("Skipped nodes %q, which already have the images\n", result.Skipped)
+	}
+
 	disgo.Infof("%s Successfully pushed images %q to cluster %q\n", style.Success(style.SymbolCheck), args, clusterName)
 }
 
-func pushFile(ctx context.Context, client *docker.Client, clusterName string, filePath string) {
+func pushFile(ctx context.Context, client *docker.Client, clusterName string, filePath string, opts sind.PushOptions, progress *internal.Progress) {
 	disgo.StartStepf("Pushing image archive at %q to cluster %q", filePath, clusterName)
 
 	file, err := os.Open(filePath)
@@ -80,7 +95,10 @@ func pushFile(ctx context.Context, client *docker.Client, clusterName string, fi
 	}
 	defer file.Close()
 
-	if err = sind.PushImageFile(ctx, client, clusterName, jobs, file); err != nil {
+	err = sind.PushImageFileWithOptions(ctx, client, clusterName, opts, file)
+	progress.Done()
+
+	if err != nil {
 		fail(disgo.FailStepf("Unable to push image archive %q to %q: %v", filePath, clusterName, err))
 	}
 
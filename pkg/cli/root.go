@@ -5,6 +5,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/jlevesy/sind/pkg/cli/internal"
 	"github.com/spf13/cobra"
 	"github.com/ullaakut/disgo"
 	"github.com/ullaakut/disgo/style"
@@ -14,6 +15,8 @@ var (
 	clusterName    string
 	timeout        time.Duration
 	nonInteractive bool
+	outputFormat   string
+	outputTemplate string
 )
 
 var rootCmd = &cobra.Command{
@@ -40,6 +43,23 @@ func Execute() {
 	}
 }
 
+// addOutputFlags adds the flags selecting the output format of a command.
+func addOutputFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&outputFormat, "output", "o", internal.OutputTable, "Output format, one of table, json, yaml or template.")
+	cmd.Flags().StringVarP(&outputTemplate, "template", "", "", "Go template to render each cluster with, when using the template output.")
+}
+
+// setupOutput validates the output flags, and moves progress messages to stderr when a structured output is requested.
+func setupOutput() {
+	if err := internal.ValidateOutput(outputFormat, outputTemplate); err != nil {
+		fail(err)
+	}
+
+	if outputFormat != internal.OutputTable {
+		disgo.SetTerminalOptions(disgo.WithDefaultOutput(os.Stderr))
+	}
+}
+
 func fail(err error) {
 	disgo.Errorln(style.Failure(err))
 	os.Exit(1)
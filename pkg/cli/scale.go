@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"context"
+	"syscall"
+
+	docker "github.com/docker/docker/client"
+	"github.com/jlevesy/sind/pkg/cli/internal"
+	"github.com/jlevesy/sind/pkg/sind"
+	"github.com/spf13/cobra"
+	"github.com/ullaakut/disgo"
+	"github.com/ullaakut/disgo/style"
+)
+
+var (
+	scaleManagers uint16
+	scaleWorkers  uint16
+
+	scaleCmd = &cobra.Command{
+		Use:   "scale",
+		Short: "Add or remove nodes of a running cluster.",
+		Run:   runScale,
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(scaleCmd)
+
+	scaleCmd.Flags().Uint16VarP(&scaleManagers, "managers", "m", 0, "Amount of managers in the scaled cluster (defaults to the current amount).")
+	scaleCmd.Flags().Uint16VarP(&scaleWorkers, "workers", "w", 0, "Amount of workers in the scaled cluster (defaults to the current amount).")
+}
+
+func runScale(cmd *cobra.Command, args []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ctx, cancel = internal.WithSignal(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	disgo.StartStep("Connecting to the docker daemon")
+
+	client, err := docker.NewClientWithOpts(internal.DefaultDockerOpts...)
+	if err != nil {
+		fail(disgo.FailStepf("Unable to connect to the docker daemon: %v", err))
+	}
+
+	disgo.StartStepf("Checking if a cluster named %q exists", clusterName)
+
+	clusterInfo, err := sind.InspectCluster(ctx, client, clusterName)
+	if err != nil {
+		fail(disgo.FailStepf("Unable to check if the cluster exists: %v", err))
+	}
+
+	if clusterInfo == nil {
+		fail(disgo.FailStepf("Cluster %q does not exists", clusterName))
+	}
+
+	if !cmd.Flags().Changed("managers") {
+		scaleManagers = clusterInfo.Managers
+	}
+
+	if !cmd.Flags().Changed("workers") {
+		scaleWorkers = clusterInfo.Workers
+	}
+
+	disgo.StartStepf("Scaling cluster %q to %d managers and %d workers", clusterName, scaleManagers, scaleWorkers)
+
+	if err = sind.ScaleCluster(ctx, client, clusterInfo.Name, scaleManagers, scaleWorkers); err != nil {
+		fail(disgo.FailStepf("Unable to scale cluster %q: %v", clusterName, err))
+	}
+
+	disgo.EndStep()
+	disgo.Infof("%s Cluster %q successfully scaled\n", style.Success(style.SymbolCheck), clusterName)
+}
@@ -18,10 +18,16 @@ var (
 		Short: "Start a sind cluster.",
 		Run:   runStart,
 	}
+
+	startWait     bool
+	startActivate bool
 )
 
 func init() {
 	rootCmd.AddCommand(startCmd)
+
+	startCmd.Flags().BoolVarP(&startWait, "wait", "w", false, "Wait until all the nodes are ready and a swarm leader is elected.")
+	startCmd.Flags().BoolVarP(&startActivate, "activate", "", false, "Restore the availability of the nodes drained by stop --drain once the cluster is healthy, implies --wait.")
 }
 
 func runStart(cmd *cobra.Command, args []string) {
@@ -51,7 +57,7 @@ func runStart(cmd *cobra.Command, args []string) {
 
 	disgo.StartStepf("Starting cluster %q", clusterName)
 
-	if err = sind.StartCluster(ctx, client, clusterInfo.Name); err != nil {
+	if err = sind.StartClusterWithOptions(ctx, client, clusterInfo.Name, sind.StartOptions{Wait: startWait, Activate: startActivate}); err != nil {
 		fail(disgo.FailStepf("Unable to start cluster %q: %v", clusterInfo.Name, err))
 	}
 
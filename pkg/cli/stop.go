@@ -3,6 +3,7 @@ package cli
 import (
 	"context"
 	"syscall"
+	"time"
 
 	docker "github.com/docker/docker/client"
 	"github.com/jlevesy/sind/pkg/cli/internal"
@@ -18,10 +19,18 @@ var (
 		Short: "Stop a sind cluster.",
 		Run:   runStop,
 	}
+
+	stopTimeout time.Duration
+	stopOrdered bool
+	stopDrain   bool
 )
 
 func init() {
 	rootCmd.AddCommand(stopCmd)
+
+	stopCmd.Flags().DurationVarP(&stopTimeout, "stop-timeout", "", 0, "Time given to each node to stop before being killed (0 means the docker default).")
+	stopCmd.Flags().BoolVarP(&stopOrdered, "ordered", "", false, "Stop the workers first, then the managers, and the primary node last.")
+	stopCmd.Flags().BoolVarP(&stopDrain, "drain", "", false, "Drain the active nodes and wait for their tasks to stop before stopping the cluster.")
 }
 
 func runStop(cmd *cobra.Command, args []string) {
@@ -51,7 +60,12 @@ func runStop(cmd *cobra.Command, args []string) {
 
 	disgo.StartStepf("Stopping cluster %q", clusterName)
 
-	if err = sind.StopCluster(ctx, client, clusterInfo.Name); err != nil {
+	opts := sind.StopOptions{Ordered: stopOrdered, Drain: stopDrain}
+	if stopTimeout > 0 {
+		opts.Timeout = &stopTimeout
+	}
+
+	if err = sind.StopClusterWithOptions(ctx, client, clusterInfo.Name, opts); err != nil {
 		fail(disgo.FailStepf("Unable to stop cluster %q: %v", clusterInfo.Name, err))
 	}
 
@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+
+	docker "github.com/docker/docker/client"
+	"github.com/jlevesy/sind/pkg/cli/internal"
+	"github.com/jlevesy/sind/pkg/sind"
+	"github.com/spf13/cobra"
+)
+
+var (
+	unlockKeyCmd = &cobra.Command{
+		Use:   "unlock-key",
+		Short: "Print the key unlocking the managers of an autolocked cluster.",
+		Args:  cobra.NoArgs,
+		Run:   runUnlockKey,
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(unlockKeyCmd)
+}
+
+func runUnlockKey(cmd *cobra.Command, args []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ctx, cancel = internal.WithSignal(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	client, err := docker.NewClientWithOpts(internal.DefaultDockerOpts...)
+	if err != nil {
+		fmt.Printf("unable to connect to the docker daemon: %v\n", err)
+		os.Exit(1)
+	}
+
+	key, err := sind.ClusterUnlockKey(ctx, client, clusterName)
+	if err != nil {
+		fmt.Printf("unable to get the unlock key: %v\n", err)
+		os.Exit(1)
+	}
+
+	if key == "" {
+		fmt.Printf("cluster %q is not autolocked\n", clusterName)
+		os.Exit(1)
+	}
+
+	fmt.Println(key)
+}
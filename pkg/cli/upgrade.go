@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"context"
+	"syscall"
+
+	"github.com/jlevesy/sind/pkg/cli/internal"
+	"github.com/jlevesy/sind/pkg/sind"
+	"github.com/spf13/cobra"
+	"github.com/ullaakut/disgo"
+	"github.com/ullaakut/disgo/style"
+)
+
+var (
+	upgradeImage string
+
+	upgradeCmd = &cobra.Command{
+		Use:   "upgrade",
+		Short: "Replace the nodes of a running cluster one by one with a new image.",
+		Args:  cobra.NoArgs,
+		Run:   runUpgrade,
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(upgradeCmd)
+
+	upgradeCmd.Flags().StringVarP(&upgradeImage, "image", "i", "", "Name of the image to upgrade the nodes to.")
+	_ = upgradeCmd.MarkFlagRequired("image")
+}
+
+func runUpgrade(cmd *cobra.Command, args []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ctx, cancel = internal.WithSignal(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	client := connectCluster(ctx)
+
+	disgo.StartStepf("Upgrading the nodes of cluster %q to image %q", clusterName, upgradeImage)
+
+	if err := sind.UpgradeCluster(ctx, client, clusterName, upgradeImage); err != nil {
+		fail(disgo.FailStepf("Unable to upgrade cluster %q: %v", clusterName, err))
+	}
+
+	disgo.EndStep()
+	disgo.Infof("%s Cluster %q successfully upgraded to image %q\n", style.Success(style.SymbolCheck), clusterName, upgradeImage)
+}
@@ -4,27 +4,149 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"sort"
+	"time"
 
+	"github.com/docker/docker/api/types"
 	docker "github.com/docker/docker/client"
 	"github.com/jlevesy/sind/pkg/sind/internal"
 )
 
+// daemonProbeTimeout is the time given to the daemon of a manager to answer, when looking for a reachable manager.
+const daemonProbeTimeout = 2 * time.Second
+
 // ClusterHost returns the host to use in order to commnicate with the swarm cluster.
+// It is the daemon of the primary node, or the daemon of the first reachable manager if the primary node is not running.
 func ClusterHost(ctx context.Context, hostClient *docker.Client, clusterName string) (string, error) {
-	primaryNode, err := internal.PrimaryContainer(ctx, hostClient, clusterName)
+	_, host, err := clusterEntrypoint(ctx, hostClient, clusterName)
+
+	return host, err
+}
+
+// clusterEntrypoint returns the manager node to use in order to communicate with the swarm cluster, and the host of its daemon.
+func clusterEntrypoint(ctx context.Context, hostClient *docker.Client, clusterName string) (types.Container, string, error) {
+	node, host, client, err := connectEntrypoint(ctx, hostClient, clusterName)
 	if err != nil {
-		return "", fmt.Errorf("unable to get the primary node informations: %v", err)
+		return types.Container{}, "", err
 	}
 
-	swarmPort, err := internal.SwarmPort(*primaryNode)
+	client.Close()
+
+	return node, host, nil
+}
+
+// connectEntrypoint returns the manager node to use in order to communicate with the swarm cluster, the host of its daemon,
+// and a client connected to it, which must be closed by the caller.
+func connectEntrypoint(ctx context.Context, hostClient *docker.Client, clusterName string) (types.Container, string, *docker.Client, error) {
+	nodes, err := internal.ListContainers(ctx, hostClient, clusterName)
 	if err != nil {
-		return "", fmt.Errorf("unable to get the remote docker daemon port: %v", err)
+		return types.Container{}, "", nil, fmt.Errorf("unable to list nodes: %v", err)
+	}
+
+	if len(nodes) == 0 {
+		return types.Container{}, "", nil, fmt.Errorf("cluster %q not found", clusterName)
 	}
 
 	swarmHost, err := internal.SwarmHost(hostClient)
 	if err != nil {
-		return "", fmt.Errorf("unable to get the remote docker daemon host: %v", err)
+		return types.Container{}, "", nil, fmt.Errorf("unable to get the remote docker daemon host: %v", err)
+	}
+
+	candidates := managerCandidates(clusterName, nodes)
+	if len(candidates) == 0 {
+		return types.Container{}, "", nil, fmt.Errorf("no manager of cluster %q is running", clusterName)
+	}
+
+	// The running primary node is used without probing its daemon, which might still be starting.
+	if candidates[0].Labels[internal.NodeRoleLabel] == internal.NodeRolePrimary {
+		host, err := daemonHost(swarmHost, candidates[0])
+		if err != nil {
+			return types.Container{}, "", nil, fmt.Errorf("unable to get the remote docker daemon port: %v", err)
+		}
+
+		client, err := docker.NewClientWithOpts(docker.WithHost(host), docker.WithAPIVersionNegotiation())
+		if err != nil {
+			return types.Container{}, "", nil, fmt.Errorf("unable to create swarm client: %v", err)
+		}
+
+		return candidates[0], host, client, nil
+	}
+
+	for _, node := range candidates {
+		// Managers created by older versions do not publish their daemon.
+		host, err := daemonHost(swarmHost, node)
+		if err != nil {
+			continue
+		}
+
+		client, err := docker.NewClientWithOpts(docker.WithHost(host), docker.WithAPIVersionNegotiation())
+		if err != nil {
+			continue
+		}
+
+		if managerReachable(ctx, client) {
+			return node, host, client, nil
+		}
+
+		client.Close()
+	}
+
+	return types.Container{}, "", nil, fmt.Errorf("the primary node of cluster %q is not running, and no other manager is reachable", clusterName)
+}
+
+// managerCandidates returns the running managers of a cluster, the primary node first, then the others sorted by name.
+func managerCandidates(clusterName string, nodes []types.Container) []types.Container {
+	var candidates []types.Container
+
+	for _, node := range nodes {
+		if node.Labels[internal.NodeRoleLabel] == internal.NodeRoleWorker || node.State != "running" {
+			continue
+		}
+
+		candidates = append(candidates, node)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		iPrimary := candidates[i].Labels[internal.NodeRoleLabel] == internal.NodeRolePrimary
+		jPrimary := candidates[j].Labels[internal.NodeRoleLabel] == internal.NodeRolePrimary
+
+		if iPrimary != jPrimary {
+			return iPrimary
+		}
+
+		return internal.ContainerNodeName(clusterName, candidates[i]) < internal.ContainerNodeName(clusterName, candidates[j])
+	})
+
+	return candidates
+}
+
+// daemonHost returns the host of the daemon of given manager node.
+func daemonHost(swarmHost string, node types.Container) (string, error) {
+	swarmPort, err := internal.SwarmPort(node)
+	if err != nil {
+		return "", err
 	}
 
 	return "tcp://" + net.JoinHostPort(swarmHost, fmt.Sprintf("%d", swarmPort)), nil
 }
+
+// managerReachable returns true if the daemon of given client answers and manages the swarm.
+func managerReachable(ctx context.Context, client *docker.Client) bool {
+	ctx, cancel := context.WithTimeout(ctx, daemonProbeTimeout)
+	defer cancel()
+
+	info, err := client.Info(ctx)
+	if err != nil {
+		return false
+	}
+
+	return info.Swarm.ControlAvailable
+}
+
+// newSwarmClient returns a client connected to the docker daemon of the primary node of a cluster,
+// or of a reachable manager if the primary node is not running.
+func newSwarmClient(ctx context.Context, hostClient *docker.Client, clusterName string) (*docker.Client, error) {
+	_, _, client, err := connectEntrypoint(ctx, hostClient, clusterName)
+
+	return client, err
+}
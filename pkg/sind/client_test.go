@@ -0,0 +1,66 @@
+package sind
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/jlevesy/sind/pkg/sind/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManagerCandidates(t *testing.T) {
+	node := func(name, role, state string) types.Container {
+		return types.Container{
+			ID:     name,
+			Names:  []string{"/" + internal.ContainerName("test", name)},
+			Labels: map[string]string{internal.NodeRoleLabel: role},
+			State:  state,
+		}
+	}
+
+	testCases := []struct {
+		desc     string
+		nodes    []types.Container
+		expected []string
+	}{
+		{
+			desc: "running primary",
+			nodes: []types.Container{
+				node("manager-2", internal.NodeRoleManager, "running"),
+				node("worker-0", internal.NodeRoleWorker, "running"),
+				node("manager-0", internal.NodeRolePrimary, "running"),
+				node("manager-1", internal.NodeRoleManager, "running"),
+			},
+			expected: []string{"manager-0", "manager-1", "manager-2"},
+		},
+		{
+			desc: "stopped primary",
+			nodes: []types.Container{
+				node("manager-2", internal.NodeRoleManager, "running"),
+				node("manager-0", internal.NodeRolePrimary, "exited"),
+				node("manager-1", internal.NodeRoleManager, "exited"),
+				node("worker-0", internal.NodeRoleWorker, "running"),
+			},
+			expected: []string{"manager-2"},
+		},
+		{
+			desc: "no running manager",
+			nodes: []types.Container{
+				node("manager-0", internal.NodeRolePrimary, "exited"),
+				node("worker-0", internal.NodeRoleWorker, "running"),
+			},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			var names []string
+
+			for _, candidate := range managerCandidates("test", test.nodes) {
+				names = append(names, candidate.ID)
+			}
+
+			assert.Equal(t, test.expected, names)
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package sind
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ClusterFileVersion is the version of the cluster definition file format supported by sind.
+const ClusterFileVersion = "v1"
+
+// clusterFile is the representation of a cluster definition file.
+type clusterFile struct {
+	Version string `yaml:"version"`
+
+	Name    string `yaml:"name"`
+	Network string `yaml:"network"`
+
+	Subnet     string `yaml:"subnet"`
+	SubnetPool string `yaml:"subnetPool"`
+
+	IPv6       bool   `yaml:"ipv6"`
+	IPv6Subnet string `yaml:"ipv6Subnet"`
+
+	Managers uint16 `yaml:"managers"`
+	Workers  uint16 `yaml:"workers"`
+
+	Image      string   `yaml:"image"`
+	Pull       bool     `yaml:"pull"`
+	Ports      []string `yaml:"ports"`
+	DaemonArgs []string `yaml:"daemonArgs"`
+
+	LoadBalancer bool `yaml:"loadBalancer"`
+
+	Swarm swarmFile `yaml:"swarm"`
+
+	Nodes map[string]nodeFile `yaml:"nodes"`
+}
+
+type nodeFile struct {
+	Image        string            `yaml:"image"`
+	DaemonArgs   []string          `yaml:"daemonArgs"`
+	Labels       map[string]string `yaml:"labels"`
+	EngineLabels map[string]string `yaml:"engineLabels"`
+}
+
+type swarmFile struct {
+	DefaultAddrPool     []string         `yaml:"defaultAddrPool"`
+	SubnetSize          uint32           `yaml:"subnetSize"`
+	DataPathPort        uint32           `yaml:"dataPathPort"`
+	AutoLock            bool             `yaml:"autoLock"`
+	TaskHistoryLimit    *int64           `yaml:"taskHistoryLimit"`
+	DispatcherHeartbeat time.Duration    `yaml:"dispatcherHeartbeat"`
+	CertExpiry          time.Duration    `yaml:"certExpiry"`
+	SnapshotInterval    uint64           `yaml:"snapshotInterval"`
+	ExternalCAs         []externalCAFile `yaml:"externalCAs"`
+}
+
+type externalCAFile struct {
+	Protocol string            `yaml:"protocol"`
+	URL      string            `yaml:"url"`
+	Options  map[string]string `yaml:"options"`
+	CACert   string            `yaml:"caCert"`
+}
+
+func (s *swarmFile) options() SwarmOptions {
+	opts := SwarmOptions{
+		DefaultAddrPool:     s.DefaultAddrPool,
+		SubnetSize:          s.SubnetSize,
+		DataPathPort:        s.DataPathPort,
+		AutoLock:            s.AutoLock,
+		TaskHistoryLimit:    s.TaskHistoryLimit,
+		DispatcherHeartbeat: s.DispatcherHeartbeat,
+		NodeCertExpiry:      s.CertExpiry,
+		SnapshotInterval:    s.SnapshotInterval,
+	}
+
+	for _, ca := range s.ExternalCAs {
+		opts.ExternalCAs = append(opts.ExternalCAs, ExternalCA{
+			Protocol: ca.Protocol,
+			URL:      ca.URL,
+			Options:  ca.Options,
+			CACert:   ca.CACert,
+		})
+	}
+
+	return opts
+}
+
+func (c *clusterFile) configuration() ClusterConfiguration {
+	cfg := ClusterConfiguration{
+		ClusterName:  c.Name,
+		NetworkName:  c.Network,
+		Subnet:       c.Subnet,
+		SubnetPool:   c.SubnetPool,
+		IPv6:         c.IPv6,
+		IPv6Subnet:   c.IPv6Subnet,
+		Managers:     c.Managers,
+		Workers:      c.Workers,
+		ImageName:    c.Image,
+		PullImage:    c.Pull,
+		PortBindings: c.Ports,
+		DaemonArgs:   c.DaemonArgs,
+		LoadBalancer: c.LoadBalancer,
+		Swarm:        c.Swarm.options(),
+	}
+
+	if len(c.Nodes) > 0 {
+		cfg.Nodes = make(map[string]NodeConfiguration, len(c.Nodes))
+	}
+
+	for name, node := range c.Nodes {
+		cfg.Nodes[name] = NodeConfiguration{
+			ImageName:    node.Image,
+			DaemonArgs:   node.DaemonArgs,
+			Labels:       node.Labels,
+			EngineLabels: node.EngineLabels,
+		}
+	}
+
+	return cfg
+}
+
+// LoadClusterConfiguration reads a cluster definition file and returns the cluster configuration it describes.
+// The configuration is not validated, as it can still be overridden before being given to CreateCluster, which validates it.
+func LoadClusterConfiguration(r io.Reader) (*ClusterConfiguration, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read cluster file: %v", err)
+	}
+
+	var root yaml.Node
+
+	if err = yaml.Unmarshal(content, &root); err != nil {
+		return nil, fmt.Errorf("invalid cluster file: %v", err)
+	}
+
+	if len(root.Content) == 0 {
+		return nil, errors.New("invalid cluster file: file is empty")
+	}
+
+	var file clusterFile
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+
+	if err = decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("invalid cluster file: %v", err)
+	}
+
+	if file.Version != ClusterFileVersion {
+		return nil, fmt.Errorf(
+			"invalid cluster file: line %d: unsupported version %q, expected %q",
+			fieldLine(&root, "version"),
+			file.Version,
+			ClusterFileVersion,
+		)
+	}
+
+	cfg := file.configuration()
+
+	return &cfg, nil
+}
+
+// fieldLine returns the line of the field at given dot separated path in a yaml document.
+// If the field is not present, it returns the line of its closest parent.
+func fieldLine(root *yaml.Node, path string) int {
+	node := root
+	if node.Kind == yaml.DocumentNode {
+		node = node.Content[0]
+	}
+
+	line := node.Line
+
+	for _, key := range strings.Split(path, ".") {
+		if node.Kind != yaml.MappingNode {
+			break
+		}
+
+		var found bool
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value != key {
+				continue
+			}
+
+			line = node.Content[i].Line
+			node = node.Content[i+1]
+			found = true
+
+			break
+		}
+
+		if !found {
+			break
+		}
+	}
+
+	return line
+}
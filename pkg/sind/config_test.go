@@ -0,0 +1,273 @@
+package sind
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadClusterConfiguration(t *testing.T) {
+	var taskHistoryLimit int64
+
+	testCases := []struct {
+		desc           string
+		content        string
+		expectedConfig *ClusterConfiguration
+		expectedError  string
+		// expectedValidationError is the error of the validation of the loaded configuration.
+		expectedValidationError string
+	}{
+		{
+			desc:          "with an empty file",
+			content:       "",
+			expectedError: "invalid cluster file: file is empty",
+		},
+		{
+			desc: "with an unsupported version",
+			content: `
+version: v42
+name: foo
+`,
+			expectedError: `invalid cluster file: line 2: unsupported version "v42", expected "v1"`,
+		},
+		{
+			desc: "with an unknown field",
+			content: `
+version: v1
+name: foo
+masters: 3
+`,
+			expectedError: "line 4: field masters not found",
+		},
+		{
+			desc: "with an invalid manager count",
+			content: `
+version: v1
+name: foo
+network: bar
+managers: 0
+`,
+			expectedValidationError: "invalid manager count, must be >= 1",
+		},
+		{
+			desc: "with a missing network",
+			content: `
+version: v1
+name: foo
+managers: 1
+`,
+			expectedValidationError: "network name is required",
+		},
+		{
+			desc: "with a load balancer and no ports",
+			content: `
+version: v1
+name: foo
+network: bar
+managers: 3
+loadBalancer: true
+`,
+			expectedValidationError: "a load balancer requires port bindings",
+		},
+		{
+			desc: "with an invalid subnet pool",
+			content: `
+version: v1
+name: foo
+network: bar
+managers: 1
+subnetPool: 10.0.0.0
+`,
+			expectedValidationError: "invalid subnet pool",
+		},
+		{
+			desc: "with a subnet too small for the nodes",
+			content: `
+version: v1
+name: foo
+network: bar
+managers: 3
+workers: 300
+subnet: 10.0.1.0/24
+`,
+			expectedValidationError: "subnet 10.0.1.0/24 can hold 253 nodes, 303 requested",
+		},
+		{
+			desc: "with too many nodes for the default subnet pool",
+			content: `
+version: v1
+name: foo
+network: bar
+managers: 3
+workers: 65531
+`,
+			expectedValidationError: "subnet pool 10.0.0.0/16 can hold 65533 nodes, 65534 requested",
+		},
+		{
+			desc: "with an IPv4 IPv6 subnet",
+			content: `
+version: v1
+name: foo
+network: bar
+managers: 1
+ipv6Subnet: 10.0.0.0/24
+`,
+			expectedValidationError: "subnet 10.0.0.0/24 is not an IPv6 subnet",
+		},
+		{
+			desc: "with an invalid data path port",
+			content: `
+version: v1
+name: foo
+network: bar
+managers: 1
+swarm:
+  dataPathPort: 80
+`,
+			expectedValidationError: "invalid data path port 80, must be within 1024-49151",
+		},
+		{
+			desc: "with an unsupported external CA",
+			content: `
+version: v1
+name: foo
+network: bar
+managers: 1
+swarm:
+  externalCAs:
+    - protocol: acme
+      url: https://ca.example.com
+`,
+			expectedValidationError: `unsupported external CA protocol "acme"`,
+		},
+		{
+			desc: "with both a subnet and a subnet pool",
+			content: `
+version: v1
+name: foo
+network: bar
+managers: 1
+subnet: 10.0.1.0/24
+subnetPool: 10.0.0.0/16
+`,
+			expectedValidationError: "subnet and subnet pool are mutually exclusive",
+		},
+		{
+			desc: "with an override of an unknown node",
+			content: `
+version: v1
+name: foo
+network: bar
+managers: 1
+workers: 1
+nodes:
+  worker-0:
+    image: docker:19.03-dind
+  worker-1:
+    image: docker:19.03-dind
+`,
+			expectedValidationError: `unknown node "worker-1"`,
+		},
+		{
+			desc: "with a valid file",
+			content: `
+version: v1
+name: foo
+network: bar
+subnet: 172.30.0.0/24
+ipv6: true
+ipv6Subnet: fd00:42::/64
+managers: 3
+workers: 2
+image: docker:20.10-dind
+pull: true
+ports:
+  - 8080:8080
+daemonArgs:
+  - --debug
+loadBalancer: true
+swarm:
+  defaultAddrPool:
+    - 10.20.0.0/16
+  subnetSize: 26
+  dataPathPort: 7789
+  autoLock: true
+  taskHistoryLimit: 0
+  dispatcherHeartbeat: 10s
+  certExpiry: 720h
+  snapshotInterval: 5000
+  externalCAs:
+    - protocol: cfssl
+      url: https://ca.example.com
+nodes:
+  manager-2:
+    daemonArgs:
+      - --experimental
+  worker-1:
+    image: docker:19.03-dind
+    labels:
+      zone: a
+    engineLabels:
+      disk: ssd
+`,
+			expectedConfig: &ClusterConfiguration{
+				ClusterName:  "foo",
+				NetworkName:  "bar",
+				Subnet:       "172.30.0.0/24",
+				IPv6:         true,
+				IPv6Subnet:   "fd00:42::/64",
+				Managers:     3,
+				Workers:      2,
+				ImageName:    "docker:20.10-dind",
+				PullImage:    true,
+				PortBindings: []string{"8080:8080"},
+				DaemonArgs:   []string{"--debug"},
+				LoadBalancer: true,
+				Swarm: SwarmOptions{
+					DefaultAddrPool:     []string{"10.20.0.0/16"},
+					SubnetSize:          26,
+					DataPathPort:        7789,
+					AutoLock:            true,
+					TaskHistoryLimit:    &taskHistoryLimit,
+					DispatcherHeartbeat: 10 * time.Second,
+					NodeCertExpiry:      720 * time.Hour,
+					SnapshotInterval:    5000,
+					ExternalCAs:         []ExternalCA{{Protocol: "cfssl", URL: "https://ca.example.com"}},
+				},
+				Nodes: map[string]NodeConfiguration{
+					"manager-2": {DaemonArgs: []string{"--experimental"}},
+					"worker-1": {
+						ImageName:    "docker:19.03-dind",
+						Labels:       map[string]string{"zone": "a"},
+						EngineLabels: map[string]string{"disk": "ssd"},
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			cfg, err := LoadClusterConfiguration(strings.NewReader(test.content))
+			if test.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+
+			if test.expectedValidationError != "" {
+				err = cfg.validate()
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.expectedValidationError)
+				return
+			}
+
+			assert.Equal(t, test.expectedConfig, cfg)
+		})
+	}
+}
@@ -2,11 +2,13 @@ package sind
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"math/rand"
 	"net"
+	"sort"
+	"time"
 
-	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/api/types"
 	docker "github.com/docker/docker/client"
 	"github.com/jlevesy/sind/pkg/sind/internal"
 )
@@ -14,6 +16,13 @@ import (
 const (
 	// DefaultNodeImageName is the default image name to use for creating swarm nodes.
 	DefaultNodeImageName = "docker:20.10-dind"
+
+	// DefaultProxyImageName is the image used for the containers forwarding host ports to the cluster.
+	DefaultProxyImageName = "nginx:1.21-alpine"
+
+	rollbackTimeout = 30 * time.Second
+
+	subnetAllocationAttempts = 5
 )
 
 // ClusterConfiguration represents the configuration for a new cluster.
@@ -21,6 +30,18 @@ type ClusterConfiguration struct {
 	ClusterName string
 	NetworkName string
 
+	// Subnet is the subnet of the cluster network, in CIDR notation.
+	// If empty, a subnet not used by any network of the host is picked from SubnetPool.
+	Subnet string
+	// SubnetPool is the pool the cluster subnet is picked from, defaults to 10.0.0.0/16.
+	SubnetPool string
+
+	// IPv6 creates a dual stack cluster network, and forms the swarm using the IPv6 addresses of the nodes.
+	IPv6 bool
+	// IPv6Subnet is the IPv6 subnet of the cluster network, in CIDR notation. It implies IPv6.
+	// If empty, a random /64 subnet of the unique local addresses range is used.
+	IPv6Subnet string
+
 	Managers uint16
 	Workers  uint16
 
@@ -28,24 +49,209 @@ type ClusterConfiguration struct {
 	PullImage    bool
 	PortBindings []string
 	DaemonArgs   []string
+
+	// LoadBalancer publishes the port bindings on a load balancer container spreading the traffic across all managers,
+	// instead of publishing them on the primary node, so that ingress traffic survives a manager failure.
+	// It follows the managers of the cluster as nodes are added, removed, promoted or demoted.
+	LoadBalancer bool
+
+	// Swarm are the settings used to initialize the swarm.
+	Swarm SwarmOptions
+
+	// KeepOnFailure keeps the resources created for the cluster if the creation fails, instead of removing them.
+	KeepOnFailure bool
+
+	// Nodes overrides the configuration of specific nodes, keyed by node name (eg. manager-1, worker-0).
+	Nodes map[string]NodeConfiguration
+
+	// Progress receives an event when each node is created, then when it joins the swarm, if set.
+	Progress ProgressFunc
+}
+
+// Node roles.
+const (
+	NodeRoleManager = internal.NodeRoleManager
+	NodeRoleWorker  = internal.NodeRoleWorker
+)
+
+// NodeConfiguration represents the configuration of a specific node of a cluster.
+type NodeConfiguration struct {
+	ImageName  string
+	DaemonArgs []string
+
+	// Labels are the swarm node labels, usable in placement constraints as node.labels.
+	Labels map[string]string
+	// EngineLabels are the docker daemon labels, usable in placement constraints as engine.labels.
+	EngineLabels map[string]string
+}
+
+// daemonArgs returns the daemon args of the node, including its engine labels.
+func (n *NodeConfiguration) daemonArgs(defaultArgs []string) []string {
+	args := n.DaemonArgs
+	if args == nil && len(n.EngineLabels) == 0 {
+		return nil
+	}
+
+	if args == nil {
+		args = defaultArgs
+	}
+
+	args = append([]string{}, args...)
+
+	keys := make([]string, 0, len(n.EngineLabels))
+	for key := range n.EngineLabels {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		args = append(args, fmt.Sprintf("--label=%s=%s", key, n.EngineLabels[key]))
+	}
+
+	return args
+}
+
+// configError is a validation error related to a given field of a configuration.
+type configError struct {
+	field string
+	msg   string
+}
+
+func (c *configError) Error() string {
+	return c.msg
 }
 
 func (n *ClusterConfiguration) validate() error {
 	if n.ClusterName == "" {
-		return errors.New("cluster name is required")
+		return &configError{field: "name", msg: "cluster name is required"}
 	}
 
 	if n.NetworkName == "" {
-		return errors.New("network name is required")
+		return &configError{field: "network", msg: "network name is required"}
 	}
 
 	if n.Managers < 1 {
-		return errors.New("invalid manager count, must be >= 1")
+		return &configError{field: "managers", msg: "invalid manager count, must be >= 1"}
+	}
+
+	if n.Subnet != "" && n.SubnetPool != "" {
+		return &configError{field: "subnet", msg: "subnet and subnet pool are mutually exclusive"}
+	}
+
+	if n.Subnet != "" {
+		_, subnet, err := net.ParseCIDR(n.Subnet)
+		if err != nil {
+			return &configError{field: "subnet", msg: fmt.Sprintf("invalid subnet: %v", err)}
+		}
+
+		if capacity := internal.SubnetCapacity(*subnet); capacity < n.nodeCount() {
+			return &configError{
+				field: "subnet",
+				msg:   fmt.Sprintf("subnet %s can hold %d nodes, %d requested", subnet, capacity, n.nodeCount()),
+			}
+		}
+	}
+
+	if n.Subnet == "" {
+		poolCIDR := n.SubnetPool
+		if poolCIDR == "" {
+			poolCIDR = internal.DefaultSubnetPool
+		}
+
+		_, pool, err := net.ParseCIDR(poolCIDR)
+		if err != nil {
+			return &configError{field: "subnetPool", msg: fmt.Sprintf("invalid subnet pool: %v", err)}
+		}
+
+		if capacity := internal.SubnetCapacity(*pool); capacity < n.nodeCount() {
+			return &configError{
+				field: "subnetPool",
+				msg:   fmt.Sprintf("subnet pool %s can hold %d nodes, %d requested", pool, capacity, n.nodeCount()),
+			}
+		}
+	}
+
+	if n.IPv6Subnet != "" {
+		_, subnet, err := net.ParseCIDR(n.IPv6Subnet)
+		if err != nil {
+			return &configError{field: "ipv6Subnet", msg: fmt.Sprintf("invalid IPv6 subnet: %v", err)}
+		}
+
+		if subnet.IP.To4() != nil {
+			return &configError{field: "ipv6Subnet", msg: fmt.Sprintf("subnet %s is not an IPv6 subnet", subnet)}
+		}
+	}
+
+	if err := n.Swarm.validate(); err != nil {
+		return err
+	}
+
+	if n.LoadBalancer && len(n.PortBindings) == 0 {
+		return &configError{field: "loadBalancer", msg: "a load balancer requires port bindings"}
+	}
+
+	for name := range n.Nodes {
+		if !n.hasNode(name) {
+			return &configError{field: "nodes." + name, msg: fmt.Sprintf("unknown node %q", name)}
+		}
 	}
 
 	return nil
 }
 
+// ipv6Subnet returns the IPv6 subnet of the cluster network, or nil if the cluster is not dual stack.
+func (n *ClusterConfiguration) ipv6Subnet() (*net.IPNet, error) {
+	if n.IPv6Subnet != "" {
+		_, subnet, err := net.ParseCIDR(n.IPv6Subnet)
+		return subnet, err
+	}
+
+	if !n.IPv6 {
+		return nil, nil
+	}
+
+	subnet := internal.RandomIPv6Subnet(rand.New(rand.NewSource(time.Now().UnixNano())))
+
+	return &subnet, nil
+}
+
+// nodeCount returns the total amount of nodes of the cluster.
+func (n *ClusterConfiguration) nodeCount() int {
+	return int(n.Managers) + int(n.Workers)
+}
+
+func (n *ClusterConfiguration) hasNode(name string) bool {
+	for i := uint16(0); i < n.Managers; i++ {
+		if name == internal.NodeName(internal.NodeRoleManager, i) {
+			return true
+		}
+	}
+
+	for i := uint16(0); i < n.Workers; i++ {
+		if name == internal.NodeName(internal.NodeRoleWorker, i) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// expectedNodes returns the hostname and role of all the nodes of the cluster.
+func (n *ClusterConfiguration) expectedNodes() map[string]string {
+	nodes := make(map[string]string, int(n.Managers)+int(n.Workers))
+
+	for i := uint16(0); i < n.Managers; i++ {
+		nodes[internal.ContainerName(n.ClusterName, internal.NodeName(internal.NodeRoleManager, i))] = internal.NodeRoleManager
+	}
+
+	for i := uint16(0); i < n.Workers; i++ {
+		nodes[internal.ContainerName(n.ClusterName, internal.NodeName(internal.NodeRoleWorker, i))] = internal.NodeRoleWorker
+	}
+
+	return nodes
+}
+
 func (n *ClusterConfiguration) imageName() string {
 	if n.ImageName != "" {
 		return n.ImageName
@@ -54,39 +260,95 @@ func (n *ClusterConfiguration) imageName() string {
 	return DefaultNodeImageName
 }
 
-// CreateCluster creates a new swarm cluster.
-func CreateCluster(ctx context.Context, hostClient *docker.Client, params ClusterConfiguration) error {
-	if err := params.validate(); err != nil {
-		return fmt.Errorf("invalid configuration: %v", err)
+// imageNames returns all the distinct images used by the nodes of the cluster.
+func (n *ClusterConfiguration) imageNames() []string {
+	images := []string{n.imageName()}
+
+	for _, node := range n.Nodes {
+		if node.ImageName == "" || contains(images, node.ImageName) {
+			continue
+		}
+
+		images = append(images, node.ImageName)
 	}
 
-	imageExists, err := internal.ImageExists(ctx, hostClient, params.imageName())
-	if err != nil {
-		return fmt.Errorf("unable to check node image existence: %v", err)
+	if n.LoadBalancer {
+		images = append(images, DefaultProxyImageName)
 	}
 
-	if params.PullImage || !imageExists {
-		if err = internal.PullImage(ctx, hostClient, params.imageName()); err != nil {
-			return fmt.Errorf("unable to pull the %s image: %v", params.imageName(), err)
+	return images
+}
+
+func (n *ClusterConfiguration) nodeOverrides() map[string]internal.NodeOverride {
+	overrides := make(map[string]internal.NodeOverride, len(n.Nodes))
+
+	for name, node := range n.Nodes {
+		overrides[name] = internal.NodeOverride{
+			ImageRef:   node.ImageName,
+			DaemonArgs: node.daemonArgs(n.DaemonArgs),
 		}
 	}
 
-	subnet, err := internal.PickSubnet()
-	if err != nil {
-		return fmt.Errorf("unable to pick an internal subnet: %v", err)
+	return overrides
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
 	}
 
-	networkCfg := internal.NetworkConfig{
-		Name:        params.NetworkName,
-		ClusterName: params.ClusterName,
-		Subnet:      subnet.String(),
+	return false
+}
+
+// CreateCluster creates a new swarm cluster.
+// If the creation fails or the context is canceled, the resources already created are removed unless KeepOnFailure is set.
+func CreateCluster(ctx context.Context, hostClient *docker.Client, params ClusterConfiguration) (err error) {
+	if err = params.validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %v", err)
 	}
 
-	clusterNet, err := internal.CreateNetwork(ctx, hostClient, networkCfg)
+	for _, imageName := range params.imageNames() {
+		imageExists, err := internal.ImageExists(ctx, hostClient, imageName)
+		if err != nil {
+			return fmt.Errorf("unable to check node image existence: %v", err)
+		}
+
+		if params.PullImage || !imageExists {
+			if err = internal.PullImage(ctx, hostClient, imageName); err != nil {
+				return fmt.Errorf("unable to pull the %s image: %v", imageName, err)
+			}
+		}
+	}
+
+	ipv6Subnet, err := params.ipv6Subnet()
+	if err != nil {
+		return fmt.Errorf("invalid IPv6 subnet: %v", err)
+	}
+
+	clusterNet, subnet, err := createClusterNetwork(ctx, hostClient, params, ipv6Subnet)
 	if err != nil {
 		return fmt.Errorf("unable to create cluster network: %v", err)
 	}
 
+	defer func() {
+		if err == nil || params.KeepOnFailure {
+			return
+		}
+
+		if rollbackErr := rollback(hostClient, params.ClusterName, clusterNet.ID); rollbackErr != nil {
+			err = fmt.Errorf("%v, and unable to remove the cluster resources: %v", err, rollbackErr)
+		}
+	}()
+
+	nodesPortBindings := params.PortBindings
+	if params.LoadBalancer {
+		nodesPortBindings = nil
+	}
+
+	var names nodeNames
+
 	nodesCfg := internal.NodesConfig{
 		ClusterName: params.ClusterName,
 		ImageRef:    params.imageName(),
@@ -94,12 +356,19 @@ func CreateCluster(ctx context.Context, hostClient *docker.Client, params Cluste
 		NetworkID:    clusterNet.ID,
 		NetworkName:  params.NetworkName,
 		Subnet:       *subnet,
-		PortBindings: params.PortBindings,
+		IPv6Subnet:   ipv6Subnet,
+		PortBindings: nodesPortBindings,
 
 		Managers: params.Managers,
 		Workers:  params.Workers,
 
 		DaemonArgs: params.DaemonArgs,
+		Nodes:      params.nodeOverrides(),
+
+		Created: func(cID, nodeName string) {
+			names.set(cID, nodeName)
+			params.Progress.emit(ProgressEvent{Kind: ProgressNodeCreated, Node: nodeName})
+		},
 	}
 
 	nodecIDs, err := internal.CreateNodes(ctx, hostClient, nodesCfg)
@@ -112,36 +381,52 @@ func CreateCluster(ctx context.Context, hostClient *docker.Client, params Cluste
 		return fmt.Errorf("unable to get the primary node informations: %v", err)
 	}
 
-	swarmPort, err := internal.SwarmPort(*primaryNode)
+	swarmClient, err := newSwarmClient(ctx, hostClient, params.ClusterName)
 	if err != nil {
-		return fmt.Errorf("unable to get the remote docker daemon port: %v", err)
+		return err
 	}
 
-	swarmHost, err := internal.SwarmHost(hostClient)
-	if err != nil {
-		return fmt.Errorf("unable to get the remote docker daemon host: %v", err)
+	if err = internal.WaitDaemonReady(ctx, swarmClient); err != nil {
+		return fmt.Errorf("unable to contact the primary node daemon: %v", err)
 	}
 
-	swarmClient, err := docker.NewClientWithOpts(
-		docker.WithHost("tcp://"+net.JoinHostPort(swarmHost, fmt.Sprintf("%d", swarmPort))),
-		docker.WithAPIVersionNegotiation(),
-	)
-	if err != nil {
-		return fmt.Errorf("unable to create swarm client: %v", err)
+	primaryNodeEndpoint, present := primaryNode.NetworkSettings.Networks[params.NetworkName]
+	if !present {
+		return fmt.Errorf("primary node is not a member of the cluster network")
 	}
 
-	if err = internal.WaitDaemonReady(ctx, swarmClient); err != nil {
-		return fmt.Errorf("unable to contact the primary node daemon: %v", err)
+	initRequest := params.Swarm.initRequest(internal.SwarmDefaultListenAddress())
+	clusterConfig := internal.ClusterParams{
+		IDs:           *nodecIDs,
+		PrimaryNodeIP: primaryNodeEndpoint.IPAddress,
+
+		Joined: func(cID string) {
+			params.Progress.emit(ProgressEvent{Kind: ProgressNodeJoined, Node: names.get(cID)})
+		},
 	}
 
-	if _, err = swarmClient.SwarmInit(
-		ctx, swarm.InitRequest{ListenAddr: internal.SwarmDefaultListenAddress()}); err != nil {
+	if ipv6Subnet != nil {
+		initRequest.ListenAddr = internal.SwarmIPv6ListenAddress()
+		initRequest.AdvertiseAddr = primaryNodeEndpoint.GlobalIPv6Address
+
+		clusterConfig.PrimaryNodeIP = primaryNodeEndpoint.GlobalIPv6Address
+		clusterConfig.ListenAddr = internal.SwarmIPv6ListenAddress()
+
+		if clusterConfig.AdvertiseAddrs, err = ipv6Addresses(ctx, hostClient, params.ClusterName, params.NetworkName); err != nil {
+			return err
+		}
+	}
+
+	if _, err = swarmClient.SwarmInit(ctx, initRequest); err != nil {
 		return fmt.Errorf("unable to init the swarm: %v", err)
 	}
 
-	primaryNodeEndpoint, present := primaryNode.NetworkSettings.Networks[params.NetworkName]
-	if !present {
-		return fmt.Errorf("primary node is not a member of the cluster network")
+	params.Progress.emit(ProgressEvent{Kind: ProgressNodeJoined, Node: names.get(nodecIDs.Primary)})
+
+	if params.Swarm.AutoLock {
+		if err = storeUnlockKey(ctx, hostClient, swarmClient, primaryNode.ID); err != nil {
+			return err
+		}
 	}
 
 	swarmInfo, err := swarmClient.SwarmInspect(ctx)
@@ -149,17 +434,136 @@ func CreateCluster(ctx context.Context, hostClient *docker.Client, params Cluste
 		return fmt.Errorf("unable to collect swarm cluster informations: %v", err)
 	}
 
-	clusterConfig := internal.ClusterParams{
-		IDs: *nodecIDs,
-
-		PrimaryNodeIP:    primaryNodeEndpoint.IPAddress,
-		ManagerJoinToken: swarmInfo.JoinTokens.Manager,
-		WorkerJoinToken:  swarmInfo.JoinTokens.Worker,
-	}
+	clusterConfig.ManagerJoinToken = swarmInfo.JoinTokens.Manager
+	clusterConfig.WorkerJoinToken = swarmInfo.JoinTokens.Worker
 
 	if err = internal.FormCluster(ctx, hostClient, clusterConfig); err != nil {
 		return fmt.Errorf("unable to form the swarm cluster: %v", err)
 	}
 
+	if err = internal.WaitSwarmConverged(ctx, swarmClient, params.expectedNodes()); err != nil {
+		return err
+	}
+
+	for name, node := range params.Nodes {
+		if err = labelSwarmNode(ctx, swarmClient, internal.ContainerName(params.ClusterName, name), node.Labels); err != nil {
+			return err
+		}
+	}
+
+	if !params.LoadBalancer {
+		return nil
+	}
+
+	if err = createLoadBalancer(ctx, hostClient, params.ClusterName, params.PortBindings, clusterNet.ID, params.NetworkName); err != nil {
+		return fmt.Errorf("unable to create the load balancer: %v", err)
+	}
+
+	return nil
+}
+
+// createClusterNetwork creates the cluster network, using the configured subnet or a free subnet of the configured pool.
+// As other networks might be created concurrently, the creation is retried with another subnet on conflict.
+func createClusterNetwork(ctx context.Context, hostClient *docker.Client, params ClusterConfiguration, ipv6Subnet *net.IPNet) (types.NetworkCreateResponse, *net.IPNet, error) {
+	networkCfg := internal.NetworkConfig{
+		Name:        params.NetworkName,
+		ClusterName: params.ClusterName,
+	}
+
+	if ipv6Subnet != nil {
+		networkCfg.IPv6Subnet = ipv6Subnet.String()
+	}
+
+	if params.Subnet != "" {
+		_, subnet, err := net.ParseCIDR(params.Subnet)
+		if err != nil {
+			return types.NetworkCreateResponse{}, nil, fmt.Errorf("invalid subnet: %v", err)
+		}
+
+		networkCfg.Subnet = subnet.String()
+
+		resp, err := internal.CreateNetwork(ctx, hostClient, networkCfg)
+
+		return resp, subnet, err
+	}
+
+	poolCIDR := params.SubnetPool
+	if poolCIDR == "" {
+		poolCIDR = internal.DefaultSubnetPool
+	}
+
+	_, pool, err := net.ParseCIDR(poolCIDR)
+	if err != nil {
+		return types.NetworkCreateResponse{}, nil, fmt.Errorf("invalid subnet pool: %v", err)
+	}
+
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for attempt := 1; ; attempt++ {
+		used, err := internal.UsedSubnets(ctx, hostClient)
+		if err != nil {
+			return types.NetworkCreateResponse{}, nil, err
+		}
+
+		subnet, err := internal.PickSubnet(*pool, params.nodeCount(), used, rng)
+		if err != nil {
+			return types.NetworkCreateResponse{}, nil, err
+		}
+
+		networkCfg.Subnet = subnet.String()
+
+		resp, err := internal.CreateNetwork(ctx, hostClient, networkCfg)
+		if internal.IsSubnetConflict(err) && attempt < subnetAllocationAttempts {
+			continue
+		}
+
+		return resp, subnet, err
+	}
+}
+
+// ipv6Addresses returns the IPv6 addresses of the nodes of a cluster on given network, indexed by container ID.
+func ipv6Addresses(ctx context.Context, hostClient internal.ContainerLister, clusterName, networkName string) (map[string]string, error) {
+	nodes, err := internal.ListContainers(ctx, hostClient, clusterName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list nodes: %v", err)
+	}
+
+	addresses := make(map[string]string, len(nodes))
+
+	for _, node := range nodes {
+		if node.NetworkSettings == nil {
+			continue
+		}
+
+		endpoint, ok := node.NetworkSettings.Networks[networkName]
+		if !ok || endpoint.GlobalIPv6Address == "" {
+			continue
+		}
+
+		addresses[node.ID] = endpoint.GlobalIPv6Address
+	}
+
+	return addresses, nil
+}
+
+// rollback removes the network created for a cluster, and all the cluster containers attached to it.
+// It does not rely on the creation context, which might be canceled already.
+func rollback(hostClient *docker.Client, clusterName, networkID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+
+	containers, err := internal.ListNetworkContainers(ctx, hostClient, clusterName, networkID)
+	if err != nil {
+		return fmt.Errorf("unable to list nodes: %v", err)
+	}
+
+	if err = internal.RemoveContainers(ctx, hostClient, containers); err != nil {
+		return fmt.Errorf("unable to delete nodes: %v", err)
+	}
+
+	if err = internal.DeleteNetworks(ctx, hostClient, []types.NetworkResource{{ID: networkID}}); err != nil {
+		return fmt.Errorf("unable to delete network: %v", err)
+	}
+
 	return nil
 }
@@ -10,7 +10,7 @@ import (
 
 // DeleteCluster removes all ressources related to a sind cluster from the host.
 func DeleteCluster(ctx context.Context, client *docker.Client, clusterName string) error {
-	nodes, err := internal.ListContainers(ctx, client, clusterName)
+	nodes, err := internal.ListClusterContainers(ctx, client, clusterName)
 	if err != nil {
 		return fmt.Errorf("unable to list nodes: %v", err)
 	}
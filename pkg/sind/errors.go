@@ -0,0 +1,7 @@
+package sind
+
+import "github.com/jlevesy/sind/pkg/sind/internal"
+
+// ExecError is returned when a command executed on a node, such as docker load or docker swarm join, exits with a non zero code.
+// It carries the name of the node, the exit code and the output of the command.
+type ExecError = internal.ExecError
@@ -5,6 +5,8 @@ import (
 	"fmt"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	docker "github.com/docker/docker/client"
 	"github.com/jlevesy/sind/pkg/sind/internal"
 )
 
@@ -18,6 +20,21 @@ type ClusterStatus struct {
 	WorkersRunning  uint16
 
 	Nodes []types.Container
+
+	// Swarm is the state of the nodes as seen by the swarm, indexed by container ID.
+	// It is only set by InspectClusterWithSwarm, nodes which are not members of the swarm are absent.
+	Swarm map[string]SwarmNodeStatus
+}
+
+// SwarmNodeStatus is the state of a cluster node as seen by the swarm.
+type SwarmNodeStatus struct {
+	ID            string `json:"id" yaml:"id"`
+	Role          string `json:"role" yaml:"role"`
+	Availability  string `json:"availability" yaml:"availability"`
+	State         string `json:"state" yaml:"state"`
+	Reachability  string `json:"reachability,omitempty" yaml:"reachability,omitempty"`
+	Leader        bool   `json:"leader" yaml:"leader"`
+	EngineVersion string `json:"engineVersion" yaml:"engineVersion"`
 }
 
 // InspectCluster returns current status for a given cluster.
@@ -60,3 +77,53 @@ func InspectCluster(ctx context.Context, hostClient internal.ContainerLister, cl
 
 	return result, nil
 }
+
+// InspectClusterWithSwarm returns current status for a given cluster, including the state of its nodes as seen by the swarm.
+// It returns nil,nil if the cluster is not found on the configured docker host.
+func InspectClusterWithSwarm(ctx context.Context, hostClient *docker.Client, clusterName string) (*ClusterStatus, error) {
+	status, err := InspectCluster(ctx, hostClient, clusterName)
+	if err != nil || status == nil {
+		return status, err
+	}
+
+	swarmClient, err := newSwarmClient(ctx, hostClient, clusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	swarmNodes, err := internal.SwarmNodes(ctx, swarmClient)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the swarm state: %v", err)
+	}
+
+	status.setSwarmNodes(swarmNodes)
+
+	return status, nil
+}
+
+// setSwarmNodes matches the cluster containers to the given swarm nodes, indexed by hostname.
+func (c *ClusterStatus) setSwarmNodes(swarmNodes map[string]swarm.Node) {
+	c.Swarm = make(map[string]SwarmNodeStatus, len(c.Nodes))
+
+	for _, node := range c.Nodes {
+		swarmNode, ok := swarmNodes[internal.ContainerName(c.Name, internal.ContainerNodeName(c.Name, node))]
+		if !ok {
+			continue
+		}
+
+		status := SwarmNodeStatus{
+			ID:            swarmNode.ID,
+			Role:          string(swarmNode.Spec.Role),
+			Availability:  string(swarmNode.Spec.Availability),
+			State:         string(swarmNode.Status.State),
+			EngineVersion: swarmNode.Description.Engine.EngineVersion,
+		}
+
+		if swarmNode.ManagerStatus != nil {
+			status.Reachability = string(swarmNode.ManagerStatus.Reachability)
+			status.Leader = swarmNode.ManagerStatus.Leader
+		}
+
+		c.Swarm[node.ID] = status
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
 	"github.com/jlevesy/sind/pkg/sind/internal"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -97,3 +98,42 @@ func TestInspectCluster(t *testing.T) {
 		})
 	}
 }
+
+func TestClusterStatusSetSwarmNodes(t *testing.T) {
+	status := ClusterStatus{
+		Name: "foo",
+		Nodes: []types.Container{
+			{ID: "aaa", Names: []string{"/sind-foo-manager-0"}},
+			{ID: "bbb", Names: []string{"/sind-foo-worker-0"}},
+		},
+	}
+
+	status.setSwarmNodes(map[string]swarm.Node{
+		"sind-foo-manager-0": {
+			ID:   "m0",
+			Spec: swarm.NodeSpec{Role: swarm.NodeRoleManager, Availability: swarm.NodeAvailabilityActive},
+			Description: swarm.NodeDescription{
+				Hostname: "sind-foo-manager-0",
+				Engine:   swarm.EngineDescription{EngineVersion: "20.10.0"},
+			},
+			Status:        swarm.NodeStatus{State: swarm.NodeStateReady},
+			ManagerStatus: &swarm.ManagerStatus{Leader: true, Reachability: swarm.ReachabilityReachable},
+		},
+	})
+
+	assert.Equal(
+		t,
+		map[string]SwarmNodeStatus{
+			"aaa": {
+				ID:            "m0",
+				Role:          "manager",
+				Availability:  "active",
+				State:         "ready",
+				Reachability:  "reachable",
+				Leader:        true,
+				EngineVersion: "20.10.0",
+			},
+		},
+		status.Swarm,
+	)
+}
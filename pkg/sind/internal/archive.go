@@ -2,40 +2,90 @@ package internal
 
 import (
 	"archive/tar"
+	"bytes"
 	"fmt"
 	"io"
-	"os"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression formats of image archives.
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
 )
 
-// TarFile writes the given file file to a tar archive.
-func TarFile(file, dest *os.File) error {
-	contentInfo, err := file.Stat()
+// ArchiveCompression returns the compression format of an archive starting with given bytes.
+func ArchiveCompression(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return CompressionGzip
+	case bytes.HasPrefix(header, zstdMagic):
+		return CompressionZstd
+	default:
+		return CompressionNone
+	}
+}
+
+// ZstdReader returns a reader decompressing a zstd compressed archive.
+// The daemons of the nodes can't load zstd archives, unlike gzip ones.
+func ZstdReader(archive io.Reader) (io.ReadCloser, error) {
+	decoder, err := zstd.NewReader(archive)
 	if err != nil {
-		return fmt.Errorf("unable to collect images file info: %v", err)
+		return nil, fmt.Errorf("unable to read zstd archive: %v", err)
 	}
 
-	tarWriter := tar.NewWriter(dest)
+	return decoder.IOReadCloser(), nil
+}
+
+// tarContent returns a tar archive holding a single file of given name and content.
+func tarContent(name string, content []byte) (io.Reader, error) {
+	var archive bytes.Buffer
 
-	err = tarWriter.WriteHeader(
+	tarWriter := tar.NewWriter(&archive)
+
+	err := tarWriter.WriteHeader(
 		&tar.Header{
 			Typeflag: tar.TypeReg,
-			Name:     contentInfo.Name(),
-			Size:     contentInfo.Size(),
-			Mode:     int64(contentInfo.Mode()),
+			Name:     name,
+			Size:     int64(len(content)),
+			Mode:     0600,
 		},
 	)
 	if err != nil {
-		return fmt.Errorf("unable to write tar file header: %v", err)
+		return nil, fmt.Errorf("unable to write tar file header: %v", err)
 	}
 
-	bytes, err := io.Copy(tarWriter, file)
-	if err != nil {
-		return fmt.Errorf("unable to tar image files (wrote %d): %v", bytes, err)
+	if _, err = tarWriter.Write(content); err != nil {
+		return nil, fmt.Errorf("unable to tar content: %v", err)
 	}
 
 	if err = tarWriter.Close(); err != nil {
-		return fmt.Errorf("unable to close the tar writer properly (wrote %d): %v", bytes, err)
+		return nil, fmt.Errorf("unable to close the tar writer properly: %v", err)
+	}
+
+	return &archive, nil
+}
+
+// untarContent returns the content of the first file of a tar archive.
+func untarContent(archive io.Reader) ([]byte, error) {
+	tarReader := tar.NewReader(archive)
+
+	if _, err := tarReader.Next(); err != nil {
+		return nil, fmt.Errorf("unable to read tar file header: %v", err)
+	}
+
+	content, err := ioutil.ReadAll(tarReader)
+	if err != nil {
+		return nil, fmt.Errorf("unable to untar content: %v", err)
 	}
 
-	return nil
+	return content, nil
 }
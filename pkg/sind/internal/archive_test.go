@@ -2,58 +2,37 @@ package internal
 
 import (
 	"archive/tar"
-	"io"
-	"io/ioutil"
-	"log"
-	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func TestTarFile(t *testing.T) {
-	file, err := ioutil.TempFile(os.TempDir(), "test_sind_tar_file")
+func TestTarContent(t *testing.T) {
+	archive, err := tarContent("foo", []byte("test"))
 	require.NoError(t, err)
 
-	defer os.Remove(file.Name())
-	defer file.Close()
-
-	destFile, err := ioutil.TempFile(os.TempDir(), "test_sind_tar_file")
-	require.NoError(t, err)
-
-	defer os.Remove(destFile.Name())
-	defer destFile.Close()
-
-	content := []byte("test")
-
-	_, err = file.Write(content)
-	require.NoError(t, err)
-
-	_, err = file.Seek(0, 0)
+	content, err := untarContent(archive)
 	require.NoError(t, err)
 
-	require.NoError(t, TarFile(file, destFile))
-
-	_, err = destFile.Seek(0, 0)
-	require.NoError(t, err)
+	assert.Equal(t, []byte("test"), content)
+}
 
-	fileInfo, err := file.Stat()
+func TestTarContentHeader(t *testing.T) {
+	archive, err := tarContent("foo", []byte("test"))
 	require.NoError(t, err)
 
-	tr := tar.NewReader(destFile)
-
-	hdr, err := tr.Next()
+	hdr, err := tar.NewReader(archive).Next()
 	require.NoError(t, err)
 
-	assert.Equal(t, fileInfo.Name(), hdr.Name)
-	assert.Equal(t, fileInfo.Size(), hdr.Size)
-	assert.Equal(t, int64(fileInfo.Mode()), hdr.Mode)
-
-	if _, err := io.Copy(os.Stdout, tr); err != nil {
-		log.Fatal(err)
-	}
+	assert.Equal(t, "foo", hdr.Name)
+	assert.EqualValues(t, 4, hdr.Size)
+	assert.EqualValues(t, 0600, hdr.Mode)
+}
 
-	_, err = tr.Next()
-	assert.Equal(t, io.EOF, err)
+func TestArchiveCompression(t *testing.T) {
+	assert.Equal(t, CompressionGzip, ArchiveCompression([]byte{0x1f, 0x8b, 0x08, 0x00}))
+	assert.Equal(t, CompressionZstd, ArchiveCompression([]byte{0x28, 0xb5, 0x2f, 0xfd}))
+	assert.Equal(t, CompressionNone, ArchiveCompression([]byte("manifest.json")))
+	assert.Equal(t, CompressionNone, ArchiveCompression(nil))
 }
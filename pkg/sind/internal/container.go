@@ -1,14 +1,20 @@
 package internal
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
-	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/golang/sync/errgroup"
 )
 
@@ -27,8 +33,24 @@ func ListPrimaryContainers(ctx context.Context, client ContainerLister) ([]types
 	})
 }
 
-// ListContainers returns the lists of containers for given cluster.
+// ListContainers returns the lists of node containers for given cluster.
 func ListContainers(ctx context.Context, docker ContainerLister, clusterName string) ([]types.Container, error) {
+	containers, err := docker.ContainerList(ctx, types.ContainerListOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("label", ClusterLabel(clusterName)),
+			filters.Arg("label", NodeRoleLabel),
+		),
+		All: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get container list: %v", err)
+	}
+
+	return containers, nil
+}
+
+// ListClusterContainers returns the lists of all containers for given cluster, nodes and other components.
+func ListClusterContainers(ctx context.Context, docker ContainerLister, clusterName string) ([]types.Container, error) {
 	containers, err := docker.ContainerList(ctx, types.ContainerListOptions{
 		Filters: filters.NewArgs(filters.Arg("label", ClusterLabel(clusterName))),
 		All:     true,
@@ -40,6 +62,38 @@ func ListContainers(ctx context.Context, docker ContainerLister, clusterName str
 	return containers, nil
 }
 
+// ListComponentContainers returns the lists of containers of given component for given cluster.
+func ListComponentContainers(ctx context.Context, docker ContainerLister, clusterName, component string) ([]types.Container, error) {
+	containers, err := docker.ContainerList(ctx, types.ContainerListOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("label", ClusterLabel(clusterName)),
+			filters.Arg("label", ComponentLabel(component)),
+		),
+		All: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get container list: %v", err)
+	}
+
+	return containers, nil
+}
+
+// ListNetworkContainers returns the lists of containers of given cluster attached to given network.
+func ListNetworkContainers(ctx context.Context, docker ContainerLister, clusterName, networkID string) ([]types.Container, error) {
+	containers, err := docker.ContainerList(ctx, types.ContainerListOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("label", ClusterLabel(clusterName)),
+			filters.Arg("network", networkID),
+		),
+		All: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get container list: %v", err)
+	}
+
+	return containers, nil
+}
+
 // PrimaryContainer returns the primary container of given cluster.
 func PrimaryContainer(ctx context.Context, docker ContainerLister, clusterName string) (*types.Container, error) {
 	containers, err := docker.ContainerList(ctx, types.ContainerListOptions{
@@ -57,11 +111,23 @@ func PrimaryContainer(ctx context.Context, docker ContainerLister, clusterName s
 		return nil, fmt.Errorf("primary container for cluster %q not found", clusterName)
 	}
 
-	if len(containers) > 1 {
+	if len(containers) == 1 {
+		return &containers[0], nil
+	}
+
+	// A new primary node can be elected before the former one is relabeled, the running one prevails.
+	var running []types.Container
+	for _, container := range containers {
+		if container.State == "running" {
+			running = append(running, container)
+		}
+	}
+
+	if len(running) != 1 {
 		return nil, fmt.Errorf("primary container for cluster %q is not unique", clusterName)
 	}
 
-	return &containers[0], nil
+	return &running[0], nil
 }
 
 type containerRemover interface {
@@ -121,14 +187,15 @@ type containerStopper interface {
 }
 
 // StopContainers stops all given containers concurrently.
-func StopContainers(ctx context.Context, hostClient containerStopper, containers []types.Container) error {
+// Each container is given timeout to stop before being killed, a nil timeout keeps the container default.
+func StopContainers(ctx context.Context, hostClient containerStopper, containers []types.Container, timeout *time.Duration) error {
 	errg, groupCtx := errgroup.WithContext(ctx)
 
 	for _, container := range containers {
 		cID := container.ID
 
 		errg.Go(func() error {
-			return hostClient.ContainerStop(groupCtx, cID, nil)
+			return hostClient.ContainerStop(groupCtx, cID, timeout)
 		})
 	}
 
@@ -139,76 +206,173 @@ func StopContainers(ctx context.Context, hostClient containerStopper, containers
 	return nil
 }
 
-type containerContentCopier interface {
-	CopyToContainer(context.Context, string, string, io.Reader, types.CopyToContainerOptions) error
+type containerRecreator interface {
+	nodeCreator
+	containerStopper
+	containerRemover
+	ContainerInspect(context.Context, string) (types.ContainerJSON, error)
+	ContainerRename(context.Context, string, string) error
 }
 
-// CopyToContainers copy content at path to given containers.
-func CopyToContainers(ctx context.Context, hostClient containerContentCopier, containers []types.Container, jobs int, contentPath, destPath string) error {
-	if jobs == 0 {
-		jobs = len(containers)
+// RecreateContainer replaces a container by a new one, and returns the ID of the new container.
+// The new container keeps the name, image, labels, command, host configuration, network addresses and volumes of the replaced one,
+// given update is applied to its configuration and host configuration before creation.
+// The new container is started only if the replaced one was running.
+// If the new container can't be created, the replaced container is restored.
+func RecreateContainer(ctx context.Context, client containerRecreator, cID string, update func(*container.Config, *container.HostConfig)) (string, error) {
+	info, err := client.ContainerInspect(ctx, cID)
+	if err != nil {
+		return "", fmt.Errorf("unable to inspect container %q: %v", cID, err)
 	}
 
-	in := make(chan string, len(containers))
+	name := strings.TrimPrefix(info.Name, "/")
 
-	errg, groupCtx := errgroup.WithContext(ctx)
+	cConfig := &container.Config{
+		Hostname:     name,
+		Image:        info.Config.Image,
+		Entrypoint:   info.Config.Entrypoint,
+		Cmd:          info.Config.Cmd,
+		Labels:       info.Config.Labels,
+		ExposedPorts: info.Config.ExposedPorts,
+	}
 
-	for i := 0; i < jobs; i++ {
-		errg.Go(func() error {
-			for {
-				select {
-				case <-groupCtx.Done():
-					return groupCtx.Err()
-				case cID, ok := <-in:
-					if !ok {
-						return nil
-					}
+	hConfig := *info.HostConfig
+	hConfig.VolumesFrom = []string{info.ID}
 
-					if err := copyToContainer(groupCtx, hostClient, cID, contentPath, destPath); err != nil {
-						return err
-					}
-				}
-			}
-		})
+	update(cConfig, &hConfig)
+
+	nConfig := &network.NetworkingConfig{EndpointsConfig: make(map[string]*network.EndpointSettings)}
+
+	for networkName, endpoint := range info.NetworkSettings.Networks {
+		ipamConfig := endpoint.IPAMConfig
+		if ipamConfig == nil {
+			ipamConfig = &network.EndpointIPAMConfig{IPv4Address: endpoint.IPAddress, IPv6Address: endpoint.GlobalIPv6Address}
+		}
+
+		nConfig.EndpointsConfig[networkName] = &network.EndpointSettings{
+			NetworkID:  endpoint.NetworkID,
+			IPAMConfig: ipamConfig,
+		}
 	}
 
-	for _, container := range containers {
-		in <- container.ID
+	running := info.State != nil && info.State.Running
+
+	// The replaced container is stopped to release its addresses, and renamed to release its name.
+	if err = client.ContainerStop(ctx, info.ID, nil); err != nil {
+		return "", fmt.Errorf("unable to stop container %q: %v", name, err)
 	}
 
-	close(in)
+	if err = client.ContainerRename(ctx, info.ID, name+"-replaced"); err != nil {
+		return "", fmt.Errorf("unable to rename container %q: %v", name, err)
+	}
 
-	if err := errg.Wait(); err != nil {
-		return fmt.Errorf("unable to deploy the image to host: %v", err)
+	newID, err := createContainer(ctx, client, cConfig, &hConfig, nConfig, running)
+	if err != nil {
+		if restoreErr := restoreContainer(ctx, client, info.ID, name, running); restoreErr != nil {
+			return "", fmt.Errorf("unable to create the new container %q: %v, and unable to restore it: %v", name, err, restoreErr)
+		}
+
+		return "", fmt.Errorf("unable to create the new container %q: %v", name, err)
 	}
 
-	return nil
+	if err = client.ContainerRemove(ctx, info.ID, types.ContainerRemoveOptions{Force: true}); err != nil {
+		return "", fmt.Errorf("unable to remove the replaced container %q: %v", name, err)
+	}
+
+	return newID, nil
 }
 
-func copyToContainer(ctx context.Context, hostClient containerContentCopier, cID, contentPath, destPath string) error {
-	file, err := os.Open(contentPath)
+// createContainer creates a container, starts it if required, and returns its ID.
+func createContainer(ctx context.Context, client nodeCreator, cConfig *container.Config, hConfig *container.HostConfig, nConfig *network.NetworkingConfig, start bool) (string, error) {
+	if start {
+		return runContainer(ctx, client, cConfig, hConfig, nConfig)
+	}
+
+	resp, err := client.ContainerCreate(ctx, cConfig, hConfig, nConfig, cConfig.Hostname)
 	if err != nil {
-		return fmt.Errorf("unable to open content: %v", err)
+		return "", err
 	}
-	defer file.Close()
 
-	err = hostClient.CopyToContainer(
-		ctx,
-		cID,
-		destPath,
-		file,
-		types.CopyToContainerOptions{},
-	)
+	return resp.ID, nil
+}
+
+// restoreContainer gives back its name to a container replaced by RecreateContainer, and starts it if it was running.
+func restoreContainer(ctx context.Context, client containerRecreator, cID, name string, running bool) error {
+	// A partially created container might hold the name.
+	_ = client.ContainerRemove(ctx, name, types.ContainerRemoveOptions{Force: true, RemoveVolumes: true})
+
+	if err := client.ContainerRename(ctx, cID, name); err != nil {
+		return err
+	}
+
+	if !running {
+		return nil
+	}
+
+	return client.ContainerStart(ctx, cID, types.ContainerStartOptions{})
+}
+
+type containerContentCopier interface {
+	CopyToContainer(context.Context, string, string, io.Reader, types.CopyToContainerOptions) error
+}
+
+// WriteContainerFile writes given content to a file of a container. The parent directory of the file must exist.
+func WriteContainerFile(ctx context.Context, hostClient containerContentCopier, cID, path string, content []byte) error {
+	archive, err := tarContent(filepath.Base(path), content)
 	if err != nil {
-		return fmt.Errorf("unable to copy the content to container %q: %v", cID, err)
+		return err
+	}
+
+	if err = hostClient.CopyToContainer(ctx, cID, filepath.Dir(path), archive, types.CopyToContainerOptions{}); err != nil {
+		return fmt.Errorf("unable to write file %q to container %q: %v", path, cID, err)
 	}
 
 	return nil
 }
 
+type containerContentReader interface {
+	CopyFromContainer(context.Context, string, string) (io.ReadCloser, types.ContainerPathStat, error)
+}
+
+// ReadContainerFile returns the content of a file of a container, which does not need to be running.
+// It returns nil, nil if the file does not exist.
+func ReadContainerFile(ctx context.Context, hostClient containerContentReader, cID, path string) ([]byte, error) {
+	archive, _, err := hostClient.CopyFromContainer(ctx, cID, path)
+	if errdefs.IsNotFound(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read file %q from container %q: %v", path, cID, err)
+	}
+	defer archive.Close()
+
+	return untarContent(archive)
+}
+
 type executor interface {
 	ContainerExecCreate(context.Context, string, types.ExecConfig) (types.IDResponse, error)
-	ContainerExecStart(context.Context, string, types.ExecStartCheck) error
+	ContainerExecAttach(context.Context, string, types.ExecStartCheck) (types.HijackedResponse, error)
+	ContainerExecInspect(context.Context, string) (types.ContainerExecInspect, error)
+	ContainerInspect(context.Context, string) (types.ContainerJSON, error)
+}
+
+// ExecError is returned when a command executed on a node exits with a non zero code.
+type ExecError struct {
+	Node     string
+	Cmd      []string
+	ExitCode int
+	Stdout   string
+	Stderr   string
+}
+
+func (e *ExecError) Error() string {
+	output := strings.TrimSpace(e.Stderr)
+	if output == "" {
+		output = strings.TrimSpace(e.Stdout)
+	}
+
+	return fmt.Sprintf("command %q on node %q exited with code %d: %s", strings.Join(e.Cmd, " "), e.Node, e.ExitCode, output)
 }
 
 // ExecContainers execute given command to given containers
@@ -246,29 +410,139 @@ func ExecContainers(ctx context.Context, hostClient executor, containers []types
 	close(in)
 
 	if err := errg.Wait(); err != nil {
-		return fmt.Errorf("unable to exec command %v: %v", cmd, err)
+		return fmt.Errorf("unable to exec command %v: %w", cmd, err)
 	}
 
 	return nil
 }
 
+// execContainer runs given command in a container and waits for its completion.
+// If the command exits with a non zero code, it returns an *ExecError carrying the command output.
 func execContainer(ctx context.Context, client executor, cID string, cmd []string) error {
-	exec, err := client.ContainerExecCreate(
-		ctx,
-		cID,
-		types.ExecConfig{
-			Cmd:          cmd,
-			AttachStdout: true,
-			AttachStderr: true,
-		},
-	)
+	_, err := execContainerOutput(ctx, client, cID, types.ExecConfig{Cmd: cmd})
+
+	return err
+}
+
+// execContainerOutput runs given exec in a container, waits for its completion and returns its standard output.
+// If the command exits with a non zero code, it returns an *ExecError carrying the command output.
+func execContainerOutput(ctx context.Context, client executor, cID string, cfg types.ExecConfig) (string, error) {
+	return execContainerInput(ctx, client, cID, cfg, nil)
+}
+
+// execContainerInput runs given exec in a container, streaming given input to its standard input unless it is nil,
+// waits for its completion and returns its standard output.
+// If the command exits with a non zero code, it returns an *ExecError carrying the command output.
+func execContainerInput(ctx context.Context, client executor, cID string, cfg types.ExecConfig, stdin io.Reader) (string, error) {
+	cmd := cfg.Cmd
+
+	cfg.AttachStdin = stdin != nil
+	cfg.AttachStdout = true
+	cfg.AttachStderr = true
+
+	exec, err := client.ContainerExecCreate(ctx, cID, cfg)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	if err := client.ContainerExecStart(ctx, exec.ID, types.ExecStartCheck{}); err != nil {
+	resp, err := client.ContainerExecAttach(ctx, exec.ID, types.ExecStartCheck{})
+	if err != nil {
+		return "", err
+	}
+
+	stdinDone := make(chan error, 1)
+
+	if stdin == nil {
+		stdinDone <- nil
+	} else {
+		go func() {
+			_, err := io.Copy(resp.Conn, stdin)
+			if closeErr := resp.CloseWrite(); err == nil {
+				err = closeErr
+			}
+
+			stdinDone <- err
+		}()
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	if err = readExecOutput(ctx, resp, &stdout, &stderr); err != nil {
+		return "", fmt.Errorf("unable to read output of command %v on container %q: %v", cmd, cID, err)
+	}
+
+	exitCode, err := waitExec(ctx, client, exec.ID)
+	if err != nil {
+		return "", fmt.Errorf("unable to inspect command %v on container %q: %v", cmd, cID, err)
+	}
+
+	if exitCode == 0 {
+		if err = <-stdinDone; err != nil {
+			return "", fmt.Errorf("unable to stream the input of command %v on container %q: %v", cmd, cID, err)
+		}
+
+		return stdout.String(), nil
+	}
+
+	execErr := ExecError{
+		Node:     cID,
+		Cmd:      cmd,
+		ExitCode: exitCode,
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+	}
+
+	if info, err := client.ContainerInspect(ctx, cID); err == nil {
+		execErr.Node = strings.TrimPrefix(info.Name, "/")
+	}
+
+	return "", &execErr
+}
+
+// readExecOutput streams the output of an attached exec until completion or context cancellation.
+func readExecOutput(ctx context.Context, resp types.HijackedResponse, stdout, stderr io.Writer) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			resp.Close()
+		case <-done:
+			resp.Close()
+		}
+	}()
+
+	if _, err := stdcopy.StdCopy(stdout, stderr, resp.Reader); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
 		return err
 	}
 
 	return nil
 }
+
+// waitExec waits for an exec to complete, and returns its exit code.
+func waitExec(ctx context.Context, client executor, execID string) (int, error) {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		inspect, err := client.ContainerExecInspect(ctx, execID)
+		if err != nil {
+			return 0, err
+		}
+
+		if !inspect.Running {
+			return inspect.ExitCode, nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
@@ -1,16 +1,21 @@
 package internal
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"errors"
 	"io"
 	"io/ioutil"
-	"os"
+	"net"
 	"sort"
 	"testing"
 	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -85,7 +90,31 @@ func TestListContainers(t *testing.T) {
 
 	require.NoError(t, err)
 	assert.Equal(t, containers, result)
-	assert.True(t, sentOpts.Filters.MatchKVList("label", map[string]string{ClusterNameLabel: clusterName}))
+	assert.True(t, sentOpts.Filters.MatchKVList("label", map[string]string{ClusterNameLabel: clusterName, NodeRoleLabel: NodeRoleWorker}))
+	assert.False(t, sentOpts.Filters.MatchKVList("label", map[string]string{ClusterNameLabel: clusterName, ClusterComponentLabel: ComponentLoadBalancer}))
+}
+
+func TestListClusterContainers(t *testing.T) {
+	ctx := context.Background()
+
+	var sentOpts types.ContainerListOptions
+
+	containers := []types.Container{
+		{ID: "foo"},
+		{ID: "bar"},
+	}
+
+	mock := ContainerListerMock(func(ctx context.Context, opts types.ContainerListOptions) ([]types.Container, error) {
+		sentOpts = opts
+
+		return containers, nil
+	})
+
+	result, err := ListClusterContainers(ctx, mock, "supercluster")
+
+	require.NoError(t, err)
+	assert.Equal(t, containers, result)
+	assert.True(t, sentOpts.Filters.MatchKVList("label", map[string]string{ClusterNameLabel: "supercluster", ClusterComponentLabel: ComponentLoadBalancer}))
 }
 
 func TestListContainersFailsOnListError(t *testing.T) {
@@ -98,6 +127,53 @@ func TestListContainersFailsOnListError(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestListComponentContainers(t *testing.T) {
+	ctx := context.Background()
+
+	var sentOpts types.ContainerListOptions
+
+	containers := []types.Container{{ID: "foo"}}
+
+	mock := ContainerListerMock(func(ctx context.Context, opts types.ContainerListOptions) ([]types.Container, error) {
+		sentOpts = opts
+
+		return containers, nil
+	})
+
+	result, err := ListComponentContainers(ctx, mock, "supercluster", ComponentPort)
+
+	require.NoError(t, err)
+	assert.Equal(t, containers, result)
+	assert.True(t, sentOpts.All)
+	assert.True(t, sentOpts.Filters.MatchKVList("label", map[string]string{ClusterNameLabel: "supercluster", ClusterComponentLabel: ComponentPort}))
+	assert.False(t, sentOpts.Filters.MatchKVList("label", map[string]string{ClusterNameLabel: "supercluster", ClusterComponentLabel: ComponentLoadBalancer}))
+}
+
+func TestListNetworkContainers(t *testing.T) {
+	ctx := context.Background()
+
+	var sentOpts types.ContainerListOptions
+
+	containers := []types.Container{
+		{ID: "foo"},
+		{ID: "bar"},
+	}
+
+	mock := ContainerListerMock(func(ctx context.Context, opts types.ContainerListOptions) ([]types.Container, error) {
+		sentOpts = opts
+
+		return containers, nil
+	})
+
+	result, err := ListNetworkContainers(ctx, mock, "supercluster", "netID")
+
+	require.NoError(t, err)
+	assert.Equal(t, containers, result)
+	assert.True(t, sentOpts.All)
+	assert.True(t, sentOpts.Filters.MatchKVList("label", map[string]string{ClusterNameLabel: "supercluster"}))
+	assert.True(t, sentOpts.Filters.ExactMatch("network", "netID"))
+}
+
 func TestPrimaryContainer(t *testing.T) {
 	testCases := []struct {
 		desc           string
@@ -121,6 +197,11 @@ func TestPrimaryContainer(t *testing.T) {
 			containers:    []types.Container{{}, {}},
 			expectedError: errors.New("primary container for cluster \"blah\" is not unique"),
 		},
+		{
+			desc:           "running primary container found among multiple ones",
+			containers:     []types.Container{{ID: "123", State: "exited"}, {ID: "456", State: "running"}},
+			expectedResult: &types.Container{ID: "456", State: "running"},
+		},
 		{
 			desc:           "primary container found",
 			containers:     []types.Container{{ID: "123456789"}},
@@ -203,12 +284,14 @@ func TestStopContainers(t *testing.T) {
 		t.Run(test.desc, func(t *testing.T) {
 			ctx := context.Background()
 			containerStopped := make(chan string, len(test.containers))
+			stopTimeout := 5 * time.Second
 			mock := containerStopperMock(func(ctx context.Context, cID string, timeout *time.Duration) error {
+				assert.Equal(t, &stopTimeout, timeout)
 				containerStopped <- cID
 				return test.stopError
 			})
 
-			err := StopContainers(ctx, mock, test.containers)
+			err := StopContainers(ctx, mock, test.containers, &stopTimeout)
 
 			if test.expectedError != nil {
 				assert.Equal(t, test.expectedError, err)
@@ -383,74 +466,52 @@ type sentContent struct {
 	content []byte
 }
 
-func TestCopyToContainers(t *testing.T) {
-	ctx := context.Background()
-
-	initialContent := []byte("content")
-	fileContent, err := ioutil.TempFile(os.TempDir(), "test_sind_copy_container")
-	require.NoError(t, err)
+type executorMock struct {
+	containerExecCreate  func(context.Context, string, types.ExecConfig) (types.IDResponse, error)
+	containerExecAttach  func(context.Context, string, types.ExecStartCheck) (types.HijackedResponse, error)
+	containerExecInspect func(context.Context, string) (types.ContainerExecInspect, error)
+}
 
-	defer os.Remove(fileContent.Name())
-	defer fileContent.Close()
+func (e *executorMock) ContainerInspect(ctx context.Context, cID string) (types.ContainerJSON, error) {
+	return types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{ID: cID, Name: "/" + cID}}, nil
+}
 
-	_, err = fileContent.Write(initialContent)
-	require.NoError(t, err)
+func (e *executorMock) ContainerExecCreate(ctx context.Context, cID string, opts types.ExecConfig) (types.IDResponse, error) {
+	return e.containerExecCreate(ctx, cID, opts)
+}
 
-	_, err = fileContent.Seek(0, 0)
-	require.NoError(t, err)
+func (e *executorMock) ContainerExecAttach(ctx context.Context, eID string, opts types.ExecStartCheck) (types.HijackedResponse, error) {
+	return e.containerExecAttach(ctx, eID, opts)
+}
 
-	destPath := "/toto"
-	containers := []types.Container{
-		{ID: "AAA"},
-		{ID: "BBB"},
-		{ID: "CCC"},
+func (e *executorMock) ContainerExecInspect(ctx context.Context, eID string) (types.ContainerExecInspect, error) {
+	if e.containerExecInspect == nil {
+		return types.ContainerExecInspect{ExecID: eID}, nil
 	}
 
-	contentSent := make(chan sentContent, len(containers))
-
-	client := containerContentCopierMock(func(ctx context.Context, cID, path string, file io.Reader, opts types.CopyToContainerOptions) error {
-		contentBytes, err := ioutil.ReadAll(file)
-		require.NoError(t, err)
-
-		contentSent <- sentContent{
-			cID:     cID,
-			path:    path,
-			content: contentBytes,
-		}
-		return nil
-	})
+	return e.containerExecInspect(ctx, eID)
+}
 
-	require.NoError(t, CopyToContainers(ctx, client, containers, 2, fileContent.Name(), destPath))
+// hijackedResponse returns a response streaming given output as the stdout of an exec.
+func hijackedResponse(stdout string) types.HijackedResponse {
+	return hijackedStreams(stdout, "")
+}
 
-	close(contentSent)
+// hijackedStreams returns a response streaming given stdout and stderr of an exec.
+func hijackedStreams(stdout, stderr string) types.HijackedResponse {
+	var stream bytes.Buffer
 
-	var sentContent []sentContent
-	for content := range contentSent {
-		sentContent = append(sentContent, content)
+	if stdout != "" {
+		_, _ = stdcopy.NewStdWriter(&stream, stdcopy.Stdout).Write([]byte(stdout))
 	}
 
-	sort.Slice(sentContent, func(i, j int) bool { return sentContent[i].cID < sentContent[j].cID })
-
-	assert.Len(t, sentContent, len(containers))
-
-	for index, content := range sentContent {
-		assert.Equal(t, containers[index].ID, content.cID)
-		assert.Equal(t, destPath, content.path)
-		assert.Equal(t, initialContent, content.content)
+	if stderr != "" {
+		_, _ = stdcopy.NewStdWriter(&stream, stdcopy.Stderr).Write([]byte(stderr))
 	}
-}
 
-type executorMock struct {
-	containerExecCreate func(context.Context, string, types.ExecConfig) (types.IDResponse, error)
-	containerExecStart  func(context.Context, string, types.ExecStartCheck) error
-}
-
-func (e *executorMock) ContainerExecCreate(ctx context.Context, cID string, opts types.ExecConfig) (types.IDResponse, error) {
-	return e.containerExecCreate(ctx, cID, opts)
-}
+	conn, _ := net.Pipe()
 
-func (e *executorMock) ContainerExecStart(ctx context.Context, eID string, opts types.ExecStartCheck) error {
-	return e.containerExecStart(ctx, eID, opts)
+	return types.HijackedResponse{Conn: conn, Reader: bufio.NewReader(&stream)}
 }
 
 func TestExecContainers(t *testing.T) {
@@ -481,9 +542,9 @@ func TestExecContainers(t *testing.T) {
 				ID: cID,
 			}, nil
 		},
-		containerExecStart: func(ctx context.Context, eID string, opts types.ExecStartCheck) error {
+		containerExecAttach: func(ctx context.Context, eID string, opts types.ExecStartCheck) (types.HijackedResponse, error) {
 			execStarted <- eID
-			return nil
+			return hijackedResponse("foo"), nil
 		},
 	}
 
@@ -514,3 +575,256 @@ func TestExecContainers(t *testing.T) {
 		assert.Equal(t, cmd, createdExecs[index].Cmd)
 	}
 }
+
+func TestExecContainersFailsOnNonZeroExitCode(t *testing.T) {
+	ctx := context.Background()
+
+	client := executorMock{
+		containerExecCreate: func(ctx context.Context, cID string, opts types.ExecConfig) (types.IDResponse, error) {
+			return types.IDResponse{ID: cID}, nil
+		},
+		containerExecAttach: func(ctx context.Context, eID string, opts types.ExecStartCheck) (types.HijackedResponse, error) {
+			return hijackedStreams("loading\n", "something went wrong\n"), nil
+		},
+		containerExecInspect: func(ctx context.Context, eID string) (types.ContainerExecInspect, error) {
+			return types.ContainerExecInspect{ExecID: eID, ExitCode: 1}, nil
+		},
+	}
+
+	err := ExecContainers(ctx, &client, []types.Container{{ID: "AAA"}}, 1, []string{"docker", "load"})
+	require.Error(t, err)
+
+	var execErr *ExecError
+
+	require.True(t, errors.As(err, &execErr))
+	assert.Equal(
+		t,
+		&ExecError{
+			Node:     "AAA",
+			Cmd:      []string{"docker", "load"},
+			ExitCode: 1,
+			Stdout:   "loading\n",
+			Stderr:   "something went wrong\n",
+		},
+		execErr,
+	)
+	assert.Equal(t, `command "docker load" on node "AAA" exited with code 1: something went wrong`, execErr.Error())
+}
+
+type containerContentReaderMock func(context.Context, string, string) (io.ReadCloser, types.ContainerPathStat, error)
+
+func (c containerContentReaderMock) CopyFromContainer(ctx context.Context, cID, path string) (io.ReadCloser, types.ContainerPathStat, error) {
+	return c(ctx, cID, path)
+}
+
+func TestWriteAndReadContainerFile(t *testing.T) {
+	ctx := context.Background()
+
+	var written sentContent
+
+	writer := containerContentCopierMock(func(ctx context.Context, cID, path string, content io.Reader, opts types.CopyToContainerOptions) error {
+		contentBytes, err := ioutil.ReadAll(content)
+		require.NoError(t, err)
+
+		written = sentContent{cID: cID, path: path, content: contentBytes}
+
+		return nil
+	})
+
+	require.NoError(t, WriteContainerFile(ctx, writer, "AAA", "/etc/foo", []byte("bar")))
+
+	assert.Equal(t, "AAA", written.cID)
+	assert.Equal(t, "/etc", written.path)
+
+	reader := containerContentReaderMock(func(ctx context.Context, cID, path string) (io.ReadCloser, types.ContainerPathStat, error) {
+		assert.Equal(t, "AAA", cID)
+		assert.Equal(t, "/etc/foo", path)
+
+		return ioutil.NopCloser(bytes.NewReader(written.content)), types.ContainerPathStat{Name: "foo"}, nil
+	})
+
+	content, err := ReadContainerFile(ctx, reader, "AAA", "/etc/foo")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("bar"), content)
+}
+
+type notFoundError struct{}
+
+func (notFoundError) Error() string { return "not found" }
+func (notFoundError) NotFound()     {}
+
+func TestReadContainerFileNotFound(t *testing.T) {
+	reader := containerContentReaderMock(func(ctx context.Context, cID, path string) (io.ReadCloser, types.ContainerPathStat, error) {
+		return nil, types.ContainerPathStat{}, notFoundError{}
+	})
+
+	content, err := ReadContainerFile(context.Background(), reader, "AAA", "/etc/foo")
+	require.NoError(t, err)
+	assert.Nil(t, content)
+}
+
+type containerRecreatorMock struct {
+	nodeStarterMock
+
+	info    types.ContainerJSON
+	calls   []string
+	removed []string
+}
+
+func (c *containerRecreatorMock) ContainerInspect(ctx context.Context, cID string) (types.ContainerJSON, error) {
+	return c.info, nil
+}
+
+func (c *containerRecreatorMock) ContainerStop(ctx context.Context, cID string, timeout *time.Duration) error {
+	c.calls = append(c.calls, "stop "+cID)
+	return nil
+}
+
+func (c *containerRecreatorMock) ContainerRename(ctx context.Context, cID, name string) error {
+	c.calls = append(c.calls, "rename "+cID+" "+name)
+	return nil
+}
+
+func (c *containerRecreatorMock) ContainerRemove(ctx context.Context, cID string, opts types.ContainerRemoveOptions) error {
+	c.calls = append(c.calls, "remove "+cID)
+	c.removed = append(c.removed, cID)
+	return nil
+}
+
+func TestRecreateContainer(t *testing.T) {
+	var created fakeContainer
+
+	client := containerRecreatorMock{
+		info: types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				ID:         "old",
+				Name:       "/sind-test-worker-0",
+				State:      &types.ContainerState{Running: true},
+				HostConfig: &container.HostConfig{Privileged: true, VolumesFrom: []string{"older"}},
+			},
+			Config: &container.Config{
+				Image:      "docker:19.03-dind",
+				Entrypoint: []string{"dockerd"},
+				Cmd:        []string{"--debug"},
+				Labels:     map[string]string{NodeRoleLabel: NodeRoleWorker},
+				Env:        []string{"DOCKER_VERSION=19.03"},
+			},
+			NetworkSettings: &types.NetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"sind-test": {NetworkID: "net", IPAddress: "10.0.0.3"},
+				},
+			},
+		},
+	}
+
+	client.nodeStarterMock = nodeStarterMock{
+		containerCreate: func(ctx context.Context, ccfg *container.Config, hcfg *container.HostConfig, ncfg *network.NetworkingConfig, cName string) (container.ContainerCreateCreatedBody, error) {
+			created = fakeContainer{name: cName, cConfig: ccfg, hConfig: hcfg, nConfig: ncfg}
+			client.calls = append(client.calls, "create "+cName)
+			return container.ContainerCreateCreatedBody{ID: "new"}, nil
+		},
+		containerStart: func(ctx context.Context, cID string, opts types.ContainerStartOptions) error {
+			client.calls = append(client.calls, "start "+cID)
+			return nil
+		},
+	}
+
+	cID, err := RecreateContainer(context.Background(), &client, "old", func(cfg *container.Config, _ *container.HostConfig) { cfg.Image = "docker:24-dind" })
+	require.NoError(t, err)
+
+	assert.Equal(t, "new", cID)
+	assert.Equal(
+		t,
+		[]string{"stop old", "rename old sind-test-worker-0-replaced", "create sind-test-worker-0", "start new", "remove old"},
+		client.calls,
+	)
+
+	assert.Equal(t, "docker:24-dind", created.cConfig.Image)
+	assert.EqualValues(t, []string{"dockerd"}, created.cConfig.Entrypoint)
+	assert.EqualValues(t, []string{"--debug"}, created.cConfig.Cmd)
+	assert.Equal(t, map[string]string{NodeRoleLabel: NodeRoleWorker}, created.cConfig.Labels)
+	assert.Empty(t, created.cConfig.Env)
+	assert.True(t, created.hConfig.Privileged)
+	assert.Equal(t, []string{"old"}, created.hConfig.VolumesFrom)
+	assert.Equal(
+		t,
+		&network.EndpointSettings{NetworkID: "net", IPAMConfig: &network.EndpointIPAMConfig{IPv4Address: "10.0.0.3"}},
+		created.nConfig.EndpointsConfig["sind-test"],
+	)
+}
+
+func TestRecreateContainerRestoresTheContainerOnFailure(t *testing.T) {
+	client := containerRecreatorMock{
+		info: types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				ID:         "old",
+				Name:       "/sind-test-worker-0",
+				State:      &types.ContainerState{Running: true},
+				HostConfig: &container.HostConfig{},
+			},
+			Config:          &container.Config{},
+			NetworkSettings: &types.NetworkSettings{},
+		},
+	}
+
+	client.nodeStarterMock = nodeStarterMock{
+		containerCreate: func(ctx context.Context, ccfg *container.Config, hcfg *container.HostConfig, ncfg *network.NetworkingConfig, cName string) (container.ContainerCreateCreatedBody, error) {
+			return container.ContainerCreateCreatedBody{}, errors.New("no such image")
+		},
+		containerStart: func(ctx context.Context, cID string, opts types.ContainerStartOptions) error {
+			client.calls = append(client.calls, "start "+cID)
+			return nil
+		},
+	}
+
+	_, err := RecreateContainer(context.Background(), &client, "old", func(cfg *container.Config, _ *container.HostConfig) { cfg.Image = "docker:24-dind" })
+	require.Error(t, err)
+
+	assert.Equal(
+		t,
+		[]string{
+			"stop old",
+			"rename old sind-test-worker-0-replaced",
+			"remove sind-test-worker-0",
+			"rename old sind-test-worker-0",
+			"start old",
+		},
+		client.calls,
+	)
+}
+
+func TestRecreateContainerDoesNotStartAStoppedContainer(t *testing.T) {
+	client := containerRecreatorMock{
+		info: types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				ID:         "old",
+				Name:       "/sind-test-primary",
+				State:      &types.ContainerState{Status: "exited"},
+				HostConfig: &container.HostConfig{},
+			},
+			Config:          &container.Config{},
+			NetworkSettings: &types.NetworkSettings{},
+		},
+	}
+
+	client.nodeStarterMock = nodeStarterMock{
+		containerCreate: func(ctx context.Context, ccfg *container.Config, hcfg *container.HostConfig, ncfg *network.NetworkingConfig, cName string) (container.ContainerCreateCreatedBody, error) {
+			client.calls = append(client.calls, "create "+cName)
+			return container.ContainerCreateCreatedBody{ID: "new"}, nil
+		},
+		containerStart: func(ctx context.Context, cID string, opts types.ContainerStartOptions) error {
+			client.calls = append(client.calls, "start "+cID)
+			return nil
+		},
+	}
+
+	cID, err := RecreateContainer(context.Background(), &client, "old", func(*container.Config, *container.HostConfig) {})
+	require.NoError(t, err)
+
+	assert.Equal(t, "new", cID)
+	assert.Equal(
+		t,
+		[]string{"stop old", "rename old sind-test-primary-replaced", "create sind-test-primary", "remove old"},
+		client.calls,
+	)
+}
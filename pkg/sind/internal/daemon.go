@@ -30,3 +30,18 @@ func WaitDaemonReady(ctx context.Context, client pinger) error {
 		}
 	}
 }
+
+// nodePinger pings the docker daemon of a node which does not expose its API, by running the docker CLI in it.
+type nodePinger struct {
+	client executor
+	cID    string
+}
+
+func (n nodePinger) Ping(ctx context.Context) (types.Ping, error) {
+	return types.Ping{}, execContainer(ctx, n.client, n.cID, []string{"docker", "version"})
+}
+
+// WaitNodeDaemonReady waits until the docker daemon of the node running in given container is ready.
+func WaitNodeDaemonReady(ctx context.Context, client executor, cID string) error {
+	return WaitDaemonReady(ctx, nodePinger{client: client, cID: cID})
+}
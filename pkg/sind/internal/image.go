@@ -5,9 +5,13 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"strings"
+	"sync"
 
+	"github.com/docker/distribution/reference"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/golang/sync/errgroup"
 )
 
 const (
@@ -54,27 +58,144 @@ func PullImage(ctx context.Context, docker imagePuller, imageRef string) error {
 	return nil
 }
 
-type imageSaver interface {
-	ImageSave(ctx context.Context, refs []string) (io.ReadCloser, error)
+// LoadImages streams an image archive, as produced by docker save, to docker load on the daemon of a node.
+func LoadImages(ctx context.Context, client executor, cID string, archive io.Reader) error {
+	_, err := execContainerInput(ctx, client, cID, types.ExecConfig{Cmd: []string{"docker", "load"}}, archive)
+
+	return err
 }
 
-// SaveImages saves all given images refs to given target.
-func SaveImages(ctx context.Context, hostClient imageSaver, dest io.WriteSeeker, refs []string) error {
-	imgReader, err := hostClient.ImageSave(ctx, refs)
-	if err != nil {
-		return fmt.Errorf("unable to save the images: %v", err)
+// LoadProgress reports the progress of an image archive load on nodes. Its funcs are called concurrently, if set.
+type LoadProgress struct {
+	// Copied is called with the amount of bytes of the archive streamed so far to a node.
+	Copied func(cID string, bytes int64)
+	// Loaded is called once a node loaded the archive.
+	Loaded func(cID string)
+}
+
+// progressReader reports the bytes read by a node from an image archive.
+type progressReader struct {
+	io.Reader
+
+	cID    string
+	copied func(cID string, bytes int64)
+	read   int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.Reader.Read(b)
+	if n > 0 {
+		p.read += int64(n)
+		p.copied(p.cID, p.read)
 	}
-	defer imgReader.Close()
 
-	var bytes int64
+	return n, err
+}
 
-	if bytes, err = io.Copy(dest, imgReader); err != nil {
-		return fmt.Errorf("unable to save the images (copied %d): %v", bytes, err)
+// LoadImagesStream reads an image archive once, and loads it on the daemons of all given nodes concurrently.
+// If a node fails to load the archive, the load is aborted on all the nodes.
+func LoadImagesStream(ctx context.Context, client executor, containers []types.Container, archive io.Reader, progress LoadProgress) error {
+	var (
+		writers = make([]io.Writer, 0, len(containers))
+		pipes   = make([]*io.PipeWriter, 0, len(containers))
+
+		mu sync.Mutex
+		// loadErr is the first load failure, which aborts the load on the other nodes.
+		loadErr error
+	)
+
+	errg, groupCtx := errgroup.WithContext(ctx)
+
+	for _, container := range containers {
+		reader, writer := io.Pipe()
+		cID := container.ID
+
+		writers = append(writers, writer)
+		pipes = append(pipes, writer)
+
+		var nodeArchive io.Reader = reader
+		if progress.Copied != nil {
+			nodeArchive = &progressReader{Reader: reader, cID: cID, copied: progress.Copied}
+		}
+
+		errg.Go(func() error {
+			err := LoadImages(groupCtx, client, cID, nodeArchive)
+			if err != nil {
+				mu.Lock()
+				if loadErr == nil {
+					loadErr = err
+				}
+				mu.Unlock()
+			} else if progress.Loaded != nil {
+				progress.Loaded(cID)
+			}
+
+			// Unblocks the archive fan out if the node stopped reading it.
+			reader.CloseWithError(fmt.Errorf("load on node %q stopped", cID))
+
+			return err
+		})
 	}
 
-	if _, err = dest.Seek(0, 0); err != nil {
-		return fmt.Errorf("unable to seek the image: %v", err)
+	_, copyErr := io.Copy(io.MultiWriter(writers...), archive)
+
+	for _, pipe := range pipes {
+		pipe.CloseWithError(copyErr)
+	}
+
+	if err := errg.Wait(); err != nil {
+		if loadErr != nil {
+			return loadErr
+		}
+
+		return err
+	}
+
+	if copyErr != nil {
+		return fmt.Errorf("unable to read the image archive: %v", copyErr)
 	}
 
 	return nil
 }
+
+// NormalizeImageRef returns the short form of an image reference, tagged with latest if it has no tag, as listed by docker images.
+func NormalizeImageRef(imageRef string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(imageRef)
+	if err != nil {
+		return "", fmt.Errorf("invalid image reference %q: %v", imageRef, err)
+	}
+
+	return reference.FamiliarString(reference.TagNameOnly(named)), nil
+}
+
+// NodeImages returns the IDs of the tagged images of the daemon of a node, indexed by normalized reference.
+func NodeImages(ctx context.Context, client executor, cID string) (map[string]string, error) {
+	out, err := execContainerOutput(
+		ctx,
+		client,
+		cID,
+		types.ExecConfig{Cmd: []string{"docker", "images", "--no-trunc", "--format", "{{.Repository}}:{{.Tag}} {{.ID}}"}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	images := make(map[string]string)
+
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+
+		ref, err := NormalizeImageRef(fields[0])
+		if err != nil {
+			// Dangling images are listed as <none>:<none>.
+			continue
+		}
+
+		images[ref] = fields[1]
+	}
+
+	return images, nil
+}
@@ -1,11 +1,14 @@
 package internal
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"errors"
 	"io"
-	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/docker/docker/api/types"
@@ -141,54 +144,178 @@ func TestPullImage(t *testing.T) {
 	}
 }
 
-type imageSaverMock func(ctx context.Context, refs []string) (io.ReadCloser, error)
+func TestNormalizeImageRef(t *testing.T) {
+	for ref, expected := range map[string]string{
+		"alpine":                        "alpine:latest",
+		"docker.io/library/alpine:3.12": "alpine:3.12",
+		"localhost:5000/foo/bar:v1":     "localhost:5000/foo/bar:v1",
+	} {
+		normalized, err := NormalizeImageRef(ref)
+		require.NoError(t, err)
+		assert.Equal(t, expected, normalized)
+	}
+
+	_, err := NormalizeImageRef("<none>:<none>")
+	assert.Error(t, err)
+}
+
+func TestNodeImages(t *testing.T) {
+	client := executorMock{
+		containerExecCreate: func(ctx context.Context, cID string, opts types.ExecConfig) (types.IDResponse, error) {
+			assert.Equal(t, "a", cID)
+			assert.Equal(t, []string{"docker", "images", "--no-trunc", "--format", "{{.Repository}}:{{.Tag}} {{.ID}}"}, opts.Cmd)
+			return types.IDResponse{ID: cID}, nil
+		},
+		containerExecAttach: func(ctx context.Context, eID string, opts types.ExecStartCheck) (types.HijackedResponse, error) {
+			return hijackedResponse("alpine:3.12 sha256:aaa\n<none>:<none> sha256:bbb\nlocalhost:5000/foo:latest sha256:ccc\n"), nil
+		},
+	}
 
-func (s imageSaverMock) ImageSave(ctx context.Context, refs []string) (io.ReadCloser, error) {
-	return s(ctx, refs)
+	images, err := NodeImages(context.Background(), &client, "a")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"alpine:3.12": "sha256:aaa", "localhost:5000/foo:latest": "sha256:ccc"}, images)
 }
 
-type mockWriteSeeker struct {
-	io.Writer
+// stdinConn records the standard input streamed to an exec.
+type stdinConn struct {
+	net.Conn
 
-	seek func(int64, int) (int64, error)
+	mu     sync.Mutex
+	stdin  bytes.Buffer
+	closed chan struct{}
 }
 
-func (m mockWriteSeeker) Seek(offset int64, whence int) (int64, error) {
-	return m.seek(offset, whence)
+func newStdinConn() *stdinConn {
+	return &stdinConn{closed: make(chan struct{})}
 }
 
-func TestSaveImages(t *testing.T) {
-	ctx := context.Background()
+func (c *stdinConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stdin.Write(p)
+}
+
+func (c *stdinConn) CloseWrite() error {
+	close(c.closed)
+	return nil
+}
+
+func (c *stdinConn) Close() error {
+	return nil
+}
+
+// waitReader blocks reads until its channel is closed.
+type waitReader struct {
+	wait   <-chan struct{}
+	reader io.Reader
+}
+
+func (w waitReader) Read(p []byte) (int, error) {
+	<-w.wait
+	return w.reader.Read(p)
+}
+
+// hijackedStdin returns a response streaming given stdout once the standard input of the exec is closed.
+func hijackedStdin(conn *stdinConn, stdout string) types.HijackedResponse {
+	resp := hijackedResponse(stdout)
+	resp.Conn = conn
+	resp.Reader = bufio.NewReader(waitReader{wait: conn.closed, reader: resp.Reader})
+
+	return resp
+}
+
+func TestLoadImagesStream(t *testing.T) {
+	containers := []types.Container{{ID: "AAA"}, {ID: "BBB"}, {ID: "CCC"}}
+
+	var mu sync.Mutex
+
+	conns := make(map[string]*stdinConn)
+
+	client := executorMock{
+		containerExecCreate: func(ctx context.Context, cID string, opts types.ExecConfig) (types.IDResponse, error) {
+			assert.Equal(t, []string{"docker", "load"}, opts.Cmd)
+			assert.True(t, opts.AttachStdin)
+			return types.IDResponse{ID: cID}, nil
+		},
+		containerExecAttach: func(ctx context.Context, eID string, opts types.ExecStartCheck) (types.HijackedResponse, error) {
+			mu.Lock()
+			defer mu.Unlock()
 
-	content := []byte("test")
-	refs := []string{"a", "b", "c"}
+			conns[eID] = newStdinConn()
+
+			return hijackedStdin(conns[eID], "Loaded image: alpine:3.12\n"), nil
+		},
+	}
+
+	archive := strings.Repeat("image", 100000)
 
 	var (
-		seekCalled bool
-		seekOffset int64
-		seekWhence int
+		copied = make(map[string]int64)
+		loaded []string
 	)
 
-	destBuf := &bytes.Buffer{}
-	dest := mockWriteSeeker{
-		Writer: destBuf,
+	progress := LoadProgress{
+		Copied: func(cID string, bytes int64) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			assert.True(t, bytes > copied[cID])
+			copied[cID] = bytes
+		},
+		Loaded: func(cID string) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			loaded = append(loaded, cID)
+		},
+	}
+
+	require.NoError(t, LoadImagesStream(context.Background(), &client, containers, strings.NewReader(archive), progress))
+
+	require.Len(t, conns, 3)
+
+	for cID, conn := range conns {
+		assert.Equal(t, archive, conn.stdin.String(), cID)
+		assert.Equal(t, int64(len(archive)), copied[cID], cID)
+	}
+
+	assert.ElementsMatch(t, []string{"AAA", "BBB", "CCC"}, loaded)
+}
+
+func TestLoadImagesStreamFailure(t *testing.T) {
+	containers := []types.Container{{ID: "AAA"}, {ID: "BBB"}}
+
+	client := executorMock{
+		containerExecCreate: func(ctx context.Context, cID string, opts types.ExecConfig) (types.IDResponse, error) {
+			return types.IDResponse{ID: cID}, nil
+		},
+		containerExecAttach: func(ctx context.Context, eID string, opts types.ExecStartCheck) (types.HijackedResponse, error) {
+			if eID == "BBB" {
+				return hijackedStreams("", "no space left on device"), nil
+			}
+
+			return hijackedStdin(newStdinConn(), ""), nil
+		},
+		containerExecInspect: func(ctx context.Context, eID string) (types.ContainerExecInspect, error) {
+			if eID == "BBB" {
+				return types.ContainerExecInspect{ExecID: eID, ExitCode: 1}, nil
+			}
 
-		seek: func(offset int64, whence int) (int64, error) {
-			seekCalled = true
-			seekOffset = offset
-			seekWhence = whence
-			return 0, nil
+			return types.ContainerExecInspect{ExecID: eID}, nil
 		},
 	}
 
-	client := imageSaverMock(func(ctx context.Context, refs []string) (io.ReadCloser, error) {
-		return ioutil.NopCloser(bytes.NewBuffer(content)), nil
-	})
+	progress := LoadProgress{
+		Loaded: func(cID string) {
+			assert.Fail(t, "unexpected load", cID)
+		},
+	}
 
-	require.NoError(t, SaveImages(ctx, client, dest, refs))
+	err := LoadImagesStream(context.Background(), &client, containers, strings.NewReader(strings.Repeat("image", 100000)), progress)
+	require.Error(t, err)
 
-	assert.Equal(t, content, destBuf.Bytes())
-	assert.True(t, seekCalled)
-	assert.EqualValues(t, 0, seekOffset)
-	assert.EqualValues(t, 0, seekWhence)
+	var execErr *ExecError
+	require.True(t, errors.As(err, &execErr))
+	assert.Equal(t, "BBB", execErr.Node)
 }
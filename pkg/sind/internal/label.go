@@ -8,6 +8,24 @@ const (
 
 	// NodeRoleLabel is the label containing the cluster role applied to nodes (containers) of a cluster.
 	NodeRoleLabel = "com.sind.cluster.role"
+
+	// ClusterComponentLabel is the label containing the kind of component applied to containers of a cluster which are not nodes.
+	ClusterComponentLabel = "com.sind.cluster.component"
+
+	// PortLabel is the label containing the port binding published by a port proxy of a cluster.
+	PortLabel = "com.sind.cluster.port"
+
+	// AvailabilityLabel is the swarm node label recording the availability of a node drained when stopping its cluster.
+	AvailabilityLabel = "com.sind.availability"
+
+	// DaemonArgsLabel is the label containing the JSON encoded daemon args of a cluster, without node overrides, applied to nodes.
+	DaemonArgsLabel = "com.sind.cluster.daemon-args"
+)
+
+// Cluster components.
+const (
+	ComponentLoadBalancer = "lb"
+	ComponentPort         = "port"
 )
 
 // Node roles.
@@ -26,3 +44,8 @@ func PrimaryNodeLabel() string {
 func ClusterLabel(name string) string {
 	return fmt.Sprintf("%s=%s", ClusterNameLabel, name)
 }
+
+// ComponentLabel returns the label applied to the containers of a component of a cluster.
+func ComponentLabel(component string) string {
+	return fmt.Sprintf("%s=%s", ClusterComponentLabel, component)
+}
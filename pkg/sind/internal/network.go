@@ -2,10 +2,11 @@ package internal
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
 	"math/rand"
 	"net"
-	"time"
+	"strings"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/filters"
@@ -18,19 +19,121 @@ type NetworkConfig struct {
 	Name        string
 	ClusterName string
 	Subnet      string
-	Labels      map[string]string
+	// IPv6Subnet enables IPv6 on the network when set.
+	IPv6Subnet string
+	Labels     map[string]string
 }
 
 type networkCreator interface {
 	NetworkCreate(context.Context, string, types.NetworkCreate) (types.NetworkCreateResponse, error)
 }
 
-// PickSubnet returns a subnet to use for the container network.
-func PickSubnet() (*net.IPNet, error) {
-	rand.Seed(time.Now().UnixNano())
-	_, res, err := net.ParseCIDR(fmt.Sprintf("10.0.%d.0/24", rand.Intn(256)))
+// DefaultSubnetPool is the pool cluster subnets are picked from, unless specified otherwise.
+const DefaultSubnetPool = "10.0.0.0/16"
 
-	return res, err
+// DefaultSubnetPrefix is the longest prefix length of the subnets picked from a pool.
+const DefaultSubnetPrefix = 24
+
+// reservedAddresses is the amount of addresses of a subnet which cannot be assigned to nodes:
+// the network address, the gateway address and the broadcast address.
+const reservedAddresses = 3
+
+// SubnetCapacity returns the amount of nodes addresses available in given IPv4 subnet.
+func SubnetCapacity(subnet net.IPNet) int {
+	ones, bits := subnet.Mask.Size()
+	if bits != 8*net.IPv4len || bits-ones < 2 {
+		return 0
+	}
+
+	return (1 << uint(bits-ones)) - reservedAddresses
+}
+
+// SubnetPrefix returns the longest IPv4 prefix length, up to DefaultSubnetPrefix, of a subnet able to hold given amount of nodes.
+func SubnetPrefix(nodes int) int {
+	prefix := DefaultSubnetPrefix
+
+	for prefix > 0 && (1<<uint(8*net.IPv4len-prefix))-reservedAddresses < nodes {
+		prefix--
+	}
+
+	return prefix
+}
+
+// UsedSubnets returns the IPv4 subnets of all the networks known to the docker host.
+func UsedSubnets(ctx context.Context, client networkLister) ([]net.IPNet, error) {
+	networks, err := client.NetworkList(ctx, types.NetworkListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list networks: %v", err)
+	}
+
+	var subnets []net.IPNet
+
+	for _, resource := range networks {
+		for _, cfg := range resource.IPAM.Config {
+			_, subnet, err := net.ParseCIDR(cfg.Subnet)
+			if err != nil || subnet.IP.To4() == nil {
+				continue
+			}
+
+			subnets = append(subnets, *subnet)
+		}
+	}
+
+	return subnets, nil
+}
+
+// PickSubnet returns a subnet of pool able to hold given amount of nodes, which does not overlap any of the used subnets.
+// Its prefix length is the one returned by SubnetPrefix, or the one of the pool if it is longer and the pool can hold the nodes.
+// The first candidate is picked at random, to limit conflicts between concurrent allocations.
+func PickSubnet(pool net.IPNet, nodes int, used []net.IPNet, rng *rand.Rand) (*net.IPNet, error) {
+	base := pool.IP.To4()
+	if base == nil {
+		return nil, fmt.Errorf("subnet pool %s is not an IPv4 subnet", pool.String())
+	}
+
+	if capacity := SubnetCapacity(pool); capacity < nodes {
+		return nil, fmt.Errorf("subnet pool %s is too small for %d nodes, it can hold %d nodes", pool.String(), nodes, capacity)
+	}
+
+	poolOnes, bits := pool.Mask.Size()
+
+	prefix := SubnetPrefix(nodes)
+	if prefix < poolOnes {
+		prefix = poolOnes
+	}
+
+	count := uint64(1) << uint(prefix-poolOnes)
+	start := uint64(rng.Int63n(int64(count)))
+
+	for i := uint64(0); i < count; i++ {
+		offset := (start + i) % count
+
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(base)+uint32(offset<<uint(bits-prefix)))
+
+		candidate := net.IPNet{IP: ip, Mask: net.CIDRMask(prefix, bits)}
+
+		if !overlapsAny(candidate, used) {
+			return &candidate, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no free /%d subnet left in pool %s", prefix, pool.String())
+}
+
+func overlapsAny(subnet net.IPNet, others []net.IPNet) bool {
+	for _, other := range others {
+		if subnet.Contains(other.IP) || other.Contains(subnet.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsSubnetConflict returns true if given network creation error is caused by a subnet already used by another network.
+func IsSubnetConflict(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "overlaps")
 }
 
 // CreateNetwork creates network according to given network config.
@@ -41,22 +144,168 @@ func CreateNetwork(ctx context.Context, client networkCreator, cfg NetworkConfig
 
 	cfg.Labels[ClusterNameLabel] = cfg.ClusterName
 
+	ipamConfig := []network.IPAMConfig{
+		{Subnet: cfg.Subnet},
+	}
+
+	if cfg.IPv6Subnet != "" {
+		ipamConfig = append(ipamConfig, network.IPAMConfig{Subnet: cfg.IPv6Subnet})
+	}
+
 	return client.NetworkCreate(
 		ctx,
 		cfg.Name,
 		types.NetworkCreate{
-			Driver: "bridge",
+			Driver:     "bridge",
+			EnableIPv6: cfg.IPv6Subnet != "",
 			IPAM: &network.IPAM{
 				Driver: "default",
-				Config: []network.IPAMConfig{
-					{Subnet: cfg.Subnet},
-				},
+				Config: ipamConfig,
 			},
 			Labels: cfg.Labels,
 		},
 	)
 }
 
+type networkInspector interface {
+	NetworkInspect(ctx context.Context, networkID string, opts types.NetworkInspectOptions) (types.NetworkResource, error)
+}
+
+// NetworkAddresses returns the subnet of given network and the addresses already allocated in it.
+func NetworkAddresses(ctx context.Context, client networkInspector, networkID string) (*net.IPNet, []string, error) {
+	resource, err := client.NetworkInspect(ctx, networkID, types.NetworkInspectOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to inspect network %q: %v", networkID, err)
+	}
+
+	subnet, err := networkSubnet(resource, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if subnet == nil {
+		return nil, nil, fmt.Errorf("network %q has no IPv4 IPAM configuration", networkID)
+	}
+
+	used := make([]string, 0, len(resource.Containers))
+
+	for _, endpoint := range resource.Containers {
+		ip, _, err := net.ParseCIDR(endpoint.IPv4Address)
+		if err != nil {
+			continue
+		}
+
+		used = append(used, ip.String())
+	}
+
+	return subnet, used, nil
+}
+
+// NetworkIPv6Subnet returns the IPv6 subnet of given network, or nil if IPv6 is not enabled on it.
+func NetworkIPv6Subnet(ctx context.Context, client networkInspector, networkID string) (*net.IPNet, error) {
+	resource, err := client.NetworkInspect(ctx, networkID, types.NetworkInspectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to inspect network %q: %v", networkID, err)
+	}
+
+	if !resource.EnableIPv6 {
+		return nil, nil
+	}
+
+	return networkSubnet(resource, true)
+}
+
+// networkSubnet returns the first IPv4 or IPv6 subnet of the IPAM configuration of a network, nil if there is none.
+func networkSubnet(resource types.NetworkResource, ipv6 bool) (*net.IPNet, error) {
+	for _, cfg := range resource.IPAM.Config {
+		_, subnet, err := net.ParseCIDR(cfg.Subnet)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subnet for network %q: %v", resource.ID, err)
+		}
+
+		if (subnet.IP.To4() == nil) == ipv6 {
+			return subnet, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// RandomIPv6Subnet returns a random /64 subnet of the IPv6 unique local addresses range, fd00::/8.
+func RandomIPv6Subnet(rng *rand.Rand) net.IPNet {
+	ip := make(net.IP, net.IPv6len)
+	ip[0] = 0xfd
+
+	for i := 1; i < 8; i++ {
+		ip[i] = byte(rng.Intn(256))
+	}
+
+	return net.IPNet{IP: ip, Mask: net.CIDRMask(64, 8*net.IPv6len)}
+}
+
+// IPv6Address returns the address of the IPv6 subnet at the same offset as given IPv4 address in the IPv4 subnet.
+// It gives each node of a dual stack network an IPv6 address derived from its IPv4 address.
+func IPv6Address(subnet4, subnet6 net.IPNet, ip4 string) (string, error) {
+	ip := net.ParseIP(ip4).To4()
+	if ip == nil || !subnet4.Contains(ip) {
+		return "", fmt.Errorf("address %q is not part of subnet %s", ip4, subnet4.String())
+	}
+
+	ones4, bits4 := subnet4.Mask.Size()
+	ones6, bits6 := subnet6.Mask.Size()
+
+	if bits6 != 8*net.IPv6len || bits6-ones6 < bits4-ones4 {
+		return "", fmt.Errorf("subnet %s is too small to map the addresses of subnet %s", subnet6.String(), subnet4.String())
+	}
+
+	offset := binary.BigEndian.Uint32(ip) - binary.BigEndian.Uint32(subnet4.IP.To4())
+
+	ip6 := make(net.IP, net.IPv6len)
+	copy(ip6, subnet6.IP.To16())
+
+	low := binary.BigEndian.Uint32(ip6[12:]) + offset
+	binary.BigEndian.PutUint32(ip6[12:], low)
+
+	return ip6.String(), nil
+}
+
+// FreeIPs returns count addresses of given subnet which are not already used.
+// The network address, the gateway address (first host address) and the broadcast address are never returned.
+func FreeIPs(subnet net.IPNet, used []string, count int) ([]string, error) {
+	base := subnet.IP.To4()
+	if base == nil {
+		return nil, fmt.Errorf("subnet %s is not an IPv4 subnet", subnet.String())
+	}
+
+	ones, bits := subnet.Mask.Size()
+	size := uint64(1) << uint(bits-ones)
+
+	usedIPs := make(map[string]bool, len(used))
+	for _, ip := range used {
+		usedIPs[ip] = true
+	}
+
+	result := make([]string, 0, count)
+
+	// Start at 2, 0 is the network address and 1 is the network gateway.
+	for offset := uint64(2); offset < size-1 && len(result) < count; offset++ {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(base)+uint32(offset))
+
+		if usedIPs[ip.String()] {
+			continue
+		}
+
+		result = append(result, ip.String())
+	}
+
+	if len(result) < count {
+		return nil, fmt.Errorf("not enough free addresses in subnet %s, %d requested, %d available", subnet.String(), count, len(result))
+	}
+
+	return result, nil
+}
+
 type networkLister interface {
 	NetworkList(ctx context.Context, opts types.NetworkListOptions) ([]types.NetworkResource, error)
 }
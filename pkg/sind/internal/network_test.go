@@ -3,6 +3,8 @@ package internal
 import (
 	"context"
 	"errors"
+	"math/rand"
+	"net"
 	"sort"
 	"testing"
 
@@ -65,6 +67,29 @@ func TestCreateNetwork(t *testing.T) {
 				},
 			},
 		},
+		{
+			desc: "with an IPv6 subnet",
+			cfg: NetworkConfig{
+				Name:        "hello",
+				ClusterName: "toto",
+				Subnet:      "10.0.117.0/24",
+				IPv6Subnet:  "fd00:1::/64",
+			},
+			expectedOpts: types.NetworkCreate{
+				Driver:     "bridge",
+				EnableIPv6: true,
+				IPAM: &network.IPAM{
+					Driver: "default",
+					Config: []network.IPAMConfig{
+						{Subnet: "10.0.117.0/24"},
+						{Subnet: "fd00:1::/64"},
+					},
+				},
+				Labels: map[string]string{
+					ClusterNameLabel: "toto",
+				},
+			},
+		},
 	}
 
 	for _, test := range testCases {
@@ -176,3 +201,300 @@ func TestDeleteNetwork(t *testing.T) {
 	sort.Strings(removedNetworks)
 	assert.Equal(t, []string{"a", "b", "c", "d"}, removedNetworks)
 }
+
+type networkInspectorMock func(ctx context.Context, networkID string, opts types.NetworkInspectOptions) (types.NetworkResource, error)
+
+func (n networkInspectorMock) NetworkInspect(ctx context.Context, networkID string, opts types.NetworkInspectOptions) (types.NetworkResource, error) {
+	return n(ctx, networkID, opts)
+}
+
+func TestNetworkAddresses(t *testing.T) {
+	ctx := context.Background()
+	client := networkInspectorMock(func(ctx context.Context, networkID string, opts types.NetworkInspectOptions) (types.NetworkResource, error) {
+		assert.Equal(t, "foo", networkID)
+
+		return types.NetworkResource{
+			IPAM: network.IPAM{
+				Config: []network.IPAMConfig{{Subnet: "10.0.117.0/24"}},
+			},
+			Containers: map[string]types.EndpointResource{
+				"a": {IPv4Address: "10.0.117.2/24"},
+				"b": {IPv4Address: "10.0.117.3/24"},
+			},
+		}, nil
+	})
+
+	subnet, used, err := NetworkAddresses(ctx, client, "foo")
+	require.NoError(t, err)
+
+	sort.Strings(used)
+	assert.Equal(t, "10.0.117.0/24", subnet.String())
+	assert.Equal(t, []string{"10.0.117.2", "10.0.117.3"}, used)
+}
+
+func TestNetworkAddressesOfADualStackNetwork(t *testing.T) {
+	client := networkInspectorMock(func(ctx context.Context, networkID string, opts types.NetworkInspectOptions) (types.NetworkResource, error) {
+		return types.NetworkResource{
+			EnableIPv6: true,
+			IPAM: network.IPAM{
+				Config: []network.IPAMConfig{{Subnet: "fd00:1::/64"}, {Subnet: "10.0.117.0/24"}},
+			},
+		}, nil
+	})
+
+	subnet, _, err := NetworkAddresses(context.Background(), client, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.117.0/24", subnet.String())
+
+	ipv6Subnet, err := NetworkIPv6Subnet(context.Background(), client, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, "fd00:1::/64", ipv6Subnet.String())
+}
+
+func TestNetworkIPv6SubnetWithoutIPv6(t *testing.T) {
+	client := networkInspectorMock(func(ctx context.Context, networkID string, opts types.NetworkInspectOptions) (types.NetworkResource, error) {
+		return types.NetworkResource{
+			IPAM: network.IPAM{
+				Config: []network.IPAMConfig{{Subnet: "10.0.117.0/24"}},
+			},
+		}, nil
+	})
+
+	ipv6Subnet, err := NetworkIPv6Subnet(context.Background(), client, "foo")
+	require.NoError(t, err)
+	assert.Nil(t, ipv6Subnet)
+}
+
+func TestIPv6Address(t *testing.T) {
+	_, subnet4, err := net.ParseCIDR("10.0.116.0/22")
+	require.NoError(t, err)
+
+	_, subnet6, err := net.ParseCIDR("fd00:1::/64")
+	require.NoError(t, err)
+
+	ip, err := IPv6Address(*subnet4, *subnet6, "10.0.117.2")
+	require.NoError(t, err)
+	assert.Equal(t, "fd00:1::102", ip)
+
+	_, err = IPv6Address(*subnet4, *subnet6, "10.0.120.2")
+	assert.Error(t, err)
+
+	_, tooSmall, err := net.ParseCIDR("fd00:1::/120")
+	require.NoError(t, err)
+
+	_, err = IPv6Address(*subnet4, *tooSmall, "10.0.117.2")
+	assert.Error(t, err)
+}
+
+func TestRandomIPv6Subnet(t *testing.T) {
+	subnet := RandomIPv6Subnet(rand.New(rand.NewSource(42)))
+
+	ones, bits := subnet.Mask.Size()
+	assert.Equal(t, 64, ones)
+	assert.Equal(t, 128, bits)
+	assert.Equal(t, byte(0xfd), subnet.IP[0])
+}
+
+func TestFreeIPs(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		subnet        string
+		used          []string
+		count         int
+		expectedIPs   []string
+		expectedError error
+	}{
+		{
+			desc:        "with an empty subnet",
+			subnet:      "10.0.117.0/24",
+			count:       3,
+			expectedIPs: []string{"10.0.117.2", "10.0.117.3", "10.0.117.4"},
+		},
+		{
+			desc:        "with used addresses",
+			subnet:      "10.0.117.0/24",
+			used:        []string{"10.0.117.2", "10.0.117.4"},
+			count:       3,
+			expectedIPs: []string{"10.0.117.3", "10.0.117.5", "10.0.117.6"},
+		},
+		{
+			desc:          "with not enough addresses",
+			subnet:        "10.0.117.0/30",
+			count:         2,
+			expectedError: errors.New("not enough free addresses in subnet 10.0.117.0/30, 2 requested, 1 available"),
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			_, subnet, err := net.ParseCIDR(test.subnet)
+			require.NoError(t, err)
+
+			ips, err := FreeIPs(*subnet, test.used, test.count)
+			assert.Equal(t, test.expectedError, err)
+			assert.Equal(t, test.expectedIPs, ips)
+		})
+	}
+}
+
+func TestFreeIPsAcrossOctets(t *testing.T) {
+	_, subnet, err := net.ParseCIDR("10.0.0.0/23")
+	require.NoError(t, err)
+
+	ips, err := FreeIPs(*subnet, nil, 509)
+	require.NoError(t, err)
+
+	assert.Equal(t, "10.0.0.2", ips[0])
+	assert.Equal(t, "10.0.0.255", ips[253])
+	assert.Equal(t, "10.0.1.0", ips[254])
+	assert.Equal(t, "10.0.1.254", ips[508])
+
+	_, err = FreeIPs(*subnet, nil, 510)
+	assert.Error(t, err)
+}
+
+func TestUsedSubnets(t *testing.T) {
+	client := networkListerMock(func(ctx context.Context, opts types.NetworkListOptions) ([]types.NetworkResource, error) {
+		return []types.NetworkResource{
+			{IPAM: network.IPAM{Config: []network.IPAMConfig{{Subnet: "172.17.0.0/16"}}}},
+			{IPAM: network.IPAM{Config: []network.IPAMConfig{{Subnet: "10.0.3.0/24"}, {Subnet: "fd00::/64"}}}},
+			{IPAM: network.IPAM{}},
+		}, nil
+	})
+
+	subnets, err := UsedSubnets(context.Background(), client)
+	require.NoError(t, err)
+
+	var cidrs []string
+	for _, subnet := range subnets {
+		cidrs = append(cidrs, subnet.String())
+	}
+
+	assert.Equal(t, []string{"172.17.0.0/16", "10.0.3.0/24"}, cidrs)
+}
+
+func TestPickSubnet(t *testing.T) {
+	mustParse := func(cidr string) net.IPNet {
+		_, subnet, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+
+		return *subnet
+	}
+
+	testCases := []struct {
+		desc           string
+		pool           string
+		nodes          int
+		used           []string
+		expectedSubnet string
+		expectedError  string
+	}{
+		{
+			desc:           "with a free pool",
+			pool:           "10.0.0.0/23",
+			nodes:          10,
+			used:           []string{"10.0.0.0/24"},
+			expectedSubnet: "10.0.1.0/24",
+		},
+		{
+			desc:           "with a pool smaller than the prefix",
+			pool:           "10.0.0.0/25",
+			nodes:          10,
+			expectedSubnet: "10.0.0.0/25",
+		},
+		{
+			desc:          "with a pool too small for the nodes",
+			pool:          "10.0.0.0/28",
+			nodes:         20,
+			expectedError: "subnet pool 10.0.0.0/28 is too small for 20 nodes, it can hold 13 nodes",
+		},
+		{
+			desc:           "with a larger used subnet",
+			pool:           "10.0.0.0/22",
+			nodes:          10,
+			used:           []string{"10.0.0.0/23", "10.0.3.0/24"},
+			expectedSubnet: "10.0.2.0/24",
+		},
+		{
+			desc:          "with an exhausted pool",
+			pool:          "10.0.0.0/23",
+			nodes:         10,
+			used:          []string{"10.0.0.0/16"},
+			expectedError: "no free /24 subnet left in pool 10.0.0.0/23",
+		},
+		{
+			desc:          "with an IPv6 pool",
+			pool:          "fd00::/64",
+			nodes:         10,
+			expectedError: "subnet pool fd00::/64 is not an IPv4 subnet",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			var used []net.IPNet
+			for _, cidr := range test.used {
+				used = append(used, mustParse(cidr))
+			}
+
+			subnet, err := PickSubnet(mustParse(test.pool), test.nodes, used, rand.New(rand.NewSource(42)))
+			if test.expectedError != "" {
+				require.Error(t, err)
+				assert.Equal(t, test.expectedError, err.Error())
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedSubnet, subnet.String())
+		})
+	}
+}
+
+func TestPickSubnetSpreadsAllocations(t *testing.T) {
+	_, pool, err := net.ParseCIDR("10.0.0.0/16")
+	require.NoError(t, err)
+
+	rng := rand.New(rand.NewSource(42))
+	picked := make(map[string]bool)
+
+	for i := 0; i < 10; i++ {
+		subnet, err := PickSubnet(*pool, 10, nil, rng)
+		require.NoError(t, err)
+
+		picked[subnet.String()] = true
+	}
+
+	assert.True(t, len(picked) > 1)
+}
+
+func TestIsSubnetConflict(t *testing.T) {
+	assert.True(t, IsSubnetConflict(errors.New("Error response from daemon: Pool overlaps with other one on this address space")))
+	assert.False(t, IsSubnetConflict(errors.New("network with name foo already exists")))
+	assert.False(t, IsSubnetConflict(nil))
+}
+
+func TestSubnetCapacity(t *testing.T) {
+	for cidr, capacity := range map[string]int{
+		"10.0.0.0/24": 253,
+		"10.0.0.0/22": 1021,
+		"10.0.0.0/31": 0,
+		"fd00::/64":   0,
+	} {
+		_, subnet, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+
+		assert.Equal(t, capacity, SubnetCapacity(*subnet), cidr)
+	}
+}
+
+func TestSubnetPrefix(t *testing.T) {
+	for nodes, prefix := range map[int]int{
+		1:     24,
+		253:   24,
+		254:   23,
+		600:   22,
+		65533: 16,
+	} {
+		assert.Equal(t, prefix, SubnetPrefix(nodes), nodes)
+	}
+}
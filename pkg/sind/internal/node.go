@@ -2,8 +2,11 @@ package internal
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
@@ -21,11 +24,137 @@ type NodesConfig struct {
 	NetworkName  string
 	PortBindings []string
 	Subnet       net.IPNet
+	// IPv6Subnet is the IPv6 subnet of a dual stack cluster network, nil otherwise.
+	IPv6Subnet *net.IPNet
 
 	Managers uint16
 	Workers  uint16
 
 	DaemonArgs []string
+
+	// Nodes overrides the configuration of specific nodes, keyed by node name.
+	Nodes map[string]NodeOverride
+
+	// Created is called concurrently with the container ID and the name of each node once created, if set.
+	Created func(cID, nodeName string)
+}
+
+func (n *NodesConfig) created(cID, nodeName string) {
+	if n.Created != nil {
+		n.Created(cID, nodeName)
+	}
+}
+
+// NodeOverride overrides the configuration of a single node.
+type NodeOverride struct {
+	ImageRef   string
+	DaemonArgs []string
+}
+
+func (n *NodesConfig) imageRef(nodeName string) string {
+	if override, ok := n.Nodes[nodeName]; ok && override.ImageRef != "" {
+		return override.ImageRef
+	}
+
+	return n.ImageRef
+}
+
+func (n *NodesConfig) daemonArgs(nodeName string) []string {
+	if override, ok := n.Nodes[nodeName]; ok && override.DaemonArgs != nil {
+		return override.DaemonArgs
+	}
+
+	return n.DaemonArgs
+}
+
+// daemonHosts are the daemon args making the daemon of a manager node listen on its published port.
+var daemonHosts = []string{
+	"-H unix:///var/run/docker.sock",
+	fmt.Sprintf("-H tcp://0.0.0.0:%d", dockerDaemonPort),
+}
+
+// daemonPort is the container port of the daemon of a manager node.
+var daemonPort = nat.Port(fmt.Sprintf("%d/tcp", dockerDaemonPort))
+
+// ApplyNodeRole updates the configuration of a node container for given role, as CreateNode configures it:
+// the daemon of a manager or primary node listens on a port published on the host, the daemon of a worker node doesn't.
+func ApplyNodeRole(role string, cConfig *container.Config, hConfig *container.HostConfig) {
+	labels := make(map[string]string, len(cConfig.Labels))
+	for key, value := range cConfig.Labels {
+		labels[key] = value
+	}
+
+	labels[NodeRoleLabel] = role
+	cConfig.Labels = labels
+
+	var cmd []string
+	for _, arg := range cConfig.Cmd {
+		if !contains(daemonHosts, arg) {
+			cmd = append(cmd, arg)
+		}
+	}
+
+	exposedPorts := make(nat.PortSet, len(cConfig.ExposedPorts))
+	for port := range cConfig.ExposedPorts {
+		exposedPorts[port] = struct{}{}
+	}
+
+	portBindings := make(nat.PortMap, len(hConfig.PortBindings))
+	for port, bindings := range hConfig.PortBindings {
+		portBindings[port] = bindings
+	}
+
+	if role == NodeRoleWorker {
+		delete(exposedPorts, daemonPort)
+		delete(portBindings, daemonPort)
+	} else {
+		cmd = append(append([]string{}, daemonHosts...), cmd...)
+		exposedPorts[daemonPort] = struct{}{}
+
+		if _, ok := portBindings[daemonPort]; !ok {
+			portBindings[daemonPort] = []nat.PortBinding{{}}
+		}
+	}
+
+	cConfig.Cmd = cmd
+	cConfig.ExposedPorts = exposedPorts
+	hConfig.PortBindings = portBindings
+}
+
+// NodeName returns the name of the node of given role and index, unique within a cluster.
+func NodeName(role string, index uint16) string {
+	return fmt.Sprintf("%s-%d", role, index)
+}
+
+// ContainerName returns the name of the container of given node.
+func ContainerName(clusterName, nodeName string) string {
+	return fmt.Sprintf("sind-%s-%s", clusterName, nodeName)
+}
+
+// ContainerNodeName returns the name of the node running in given container.
+func ContainerNodeName(clusterName string, container types.Container) string {
+	if len(container.Names) == 0 {
+		return ""
+	}
+
+	return strings.TrimPrefix(
+		strings.TrimPrefix(container.Names[0], "/"),
+		ContainerName(clusterName, ""),
+	)
+}
+
+// NodeIndex returns the index of a node given its name and role.
+func NodeIndex(role, nodeName string) (uint16, bool) {
+	if !strings.HasPrefix(nodeName, role+"-") {
+		return 0, false
+	}
+
+	index, err := strconv.ParseUint(strings.TrimPrefix(nodeName, role+"-"), 10, 16)
+	if err != nil {
+		return 0, false
+	}
+
+	return uint16(index), true
 }
 
 // NodeIDs carries the IDs of various nodes in the cluster.
@@ -46,10 +175,14 @@ func CreateNodes(ctx context.Context, docker nodeCreator, cfg NodesConfig) (*Nod
 		managerIndex uint16
 		workerIndex  uint16
 
-		// Start at 2, 1 is the network gateway.
-		nodeIPIdentifier uint16 = 2
+		nodeIPIndex int
 	)
 
+	ips, err := FreeIPs(cfg.Subnet, nil, int(cfg.Managers)+int(cfg.Workers))
+	if err != nil {
+		return nil, fmt.Errorf("unable to allocate node addresses: %v", err)
+	}
+
 	primaryCreated := make(chan string, 1)
 	managerCreated := make(chan string, cfg.Managers-1)
 	workerCreated := make(chan string, cfg.Workers)
@@ -59,30 +192,51 @@ func CreateNodes(ctx context.Context, docker nodeCreator, cfg NodesConfig) (*Nod
 		return nil, fmt.Errorf("unable to define port bindings: %v", err)
 	}
 
+	// Build every node configuration upfront, so that no node is being created when one of them is invalid.
+	primaryCfg, err := cfg.nodeConfig(NodeRoleManager, managerIndex, ips[nodeIPIndex])
+	if err != nil {
+		return nil, err
+	}
+
+	nodeIPIndex++
+	managerIndex++
+
+	managerCfgs := make([]NodeConfig, 0, cfg.Managers-1)
+	for ; managerIndex < cfg.Managers; managerIndex++ {
+		nodeCfg, err := cfg.nodeConfig(NodeRoleManager, managerIndex, ips[nodeIPIndex])
+		if err != nil {
+			return nil, err
+		}
+
+		managerCfgs = append(managerCfgs, nodeCfg)
+		nodeIPIndex++
+	}
+
+	workerCfgs := make([]NodeConfig, 0, cfg.Workers)
+	for ; workerIndex < cfg.Workers; workerIndex++ {
+		nodeCfg, err := cfg.nodeConfig(NodeRoleWorker, workerIndex, ips[nodeIPIndex])
+		if err != nil {
+			return nil, err
+		}
+
+		workerCfgs = append(workerCfgs, nodeCfg)
+		nodeIPIndex++
+	}
+
 	errg, groupCtx := errgroup.WithContext(ctx)
 
 	// Create the primary node.
-	primaryIndex := managerIndex
-	primaryIPSuffix := nodeIPIdentifier
-
 	errg.Go(func() error {
-		nodeName := fmt.Sprintf("sind-%s-manager-%d", cfg.ClusterName, primaryIndex)
 		cID, err := runContainer(
 			groupCtx,
 			docker,
 			&container.Config{
-				Hostname:     nodeName,
-				Image:        cfg.ImageRef,
+				Hostname:     ContainerName(cfg.ClusterName, primaryCfg.Name),
+				Image:        primaryCfg.ImageRef,
 				Entrypoint:   []string{"dockerd"},
 				ExposedPorts: nat.PortSet(exposedPorts),
-				Labels: map[string]string{
-					ClusterNameLabel: cfg.ClusterName,
-					NodeRoleLabel:    NodeRolePrimary,
-				},
-				Cmd: append([]string{
-					"-H unix:///var/run/docker.sock",
-					"-H tcp://0.0.0.0:2375",
-				}, cfg.DaemonArgs...),
+				Labels:       primaryCfg.labels(NodeRolePrimary),
+				Cmd:          append(append([]string{}, daemonHosts...), primaryCfg.DaemonArgs...),
 			},
 			&container.HostConfig{
 				Privileged:      true,
@@ -92,16 +246,8 @@ func CreateNodes(ctx context.Context, docker nodeCreator, cfg NodesConfig) (*Nod
 			&network.NetworkingConfig{
 				EndpointsConfig: map[string]*network.EndpointSettings{
 					cfg.NetworkName: {
-						NetworkID: cfg.NetworkID,
-						IPAMConfig: &network.EndpointIPAMConfig{
-							IPv4Address: fmt.Sprintf(
-								"%d.%d.%d.%d",
-								cfg.Subnet.IP[0],
-								cfg.Subnet.IP[1],
-								cfg.Subnet.IP[2],
-								primaryIPSuffix,
-							),
-						},
+						NetworkID:  cfg.NetworkID,
+						IPAMConfig: primaryCfg.ipamConfig(),
 					},
 				},
 			},
@@ -110,110 +256,43 @@ func CreateNodes(ctx context.Context, docker nodeCreator, cfg NodesConfig) (*Nod
 		if err != nil {
 			return err
 		}
+
+		cfg.created(cID, primaryCfg.Name)
 		primaryCreated <- cID
 		return nil
 	})
 
-	nodeIPIdentifier++
-	managerIndex++
-
 	// Create the managers.
-	for ; managerIndex < cfg.Managers; managerIndex++ {
-		idx := managerIndex
-		ipSuffix := nodeIPIdentifier
+	for _, nodeCfg := range managerCfgs {
+		nodeCfg := nodeCfg
 
 		errg.Go(func() error {
-			nodeName := fmt.Sprintf("sind-%s-manager-%d", cfg.ClusterName, idx)
-			cID, err := runContainer(
-				groupCtx,
-				docker,
-				&container.Config{
-					Image:      cfg.ImageRef,
-					Entrypoint: []string{"dockerd"},
-					Hostname:   nodeName,
-					Labels: map[string]string{
-						ClusterNameLabel: cfg.ClusterName,
-						NodeRoleLabel:    NodeRoleManager,
-					},
-					Cmd: cfg.DaemonArgs,
-				},
-				&container.HostConfig{Privileged: true},
-				&network.NetworkingConfig{
-					EndpointsConfig: map[string]*network.EndpointSettings{
-						cfg.NetworkName: {
-							NetworkID: cfg.NetworkID,
-							IPAMConfig: &network.EndpointIPAMConfig{
-								IPv4Address: fmt.Sprintf(
-									"%d.%d.%d.%d",
-									cfg.Subnet.IP[0],
-									cfg.Subnet.IP[1],
-									cfg.Subnet.IP[2],
-									ipSuffix,
-								),
-							},
-						},
-					},
-				},
-			)
-
+			cID, err := CreateNode(groupCtx, docker, nodeCfg)
 			if err != nil {
 				return err
 			}
 
+			cfg.created(cID, nodeCfg.Name)
 			managerCreated <- cID
 
 			return nil
 		})
-		nodeIPIdentifier++
 	}
 
 	// Create the workers.
-	for ; workerIndex < cfg.Workers; workerIndex++ {
-		idx := workerIndex
-		ipSuffix := nodeIPIdentifier
+	for _, nodeCfg := range workerCfgs {
+		nodeCfg := nodeCfg
 
 		errg.Go(func() error {
-			nodeName := fmt.Sprintf("sind-%s-worker-%d", cfg.ClusterName, idx)
-			cID, err := runContainer(
-				ctx,
-				docker,
-				&container.Config{
-					Image:      cfg.ImageRef,
-					Hostname:   nodeName,
-					Entrypoint: []string{"dockerd"},
-					Labels: map[string]string{
-						ClusterNameLabel: cfg.ClusterName,
-						NodeRoleLabel:    NodeRoleWorker,
-					},
-					Cmd: cfg.DaemonArgs,
-				},
-				&container.HostConfig{Privileged: true},
-				&network.NetworkingConfig{
-					EndpointsConfig: map[string]*network.EndpointSettings{
-						cfg.NetworkName: {
-							NetworkID: cfg.NetworkID,
-							IPAMConfig: &network.EndpointIPAMConfig{
-								IPv4Address: fmt.Sprintf(
-									"%d.%d.%d.%d",
-									cfg.Subnet.IP[0],
-									cfg.Subnet.IP[1],
-									cfg.Subnet.IP[2],
-									ipSuffix,
-								),
-							},
-						},
-					},
-				},
-			)
-
+			cID, err := CreateNode(groupCtx, docker, nodeCfg)
 			if err != nil {
 				return err
 			}
 
+			cfg.created(cID, nodeCfg.Name)
 			workerCreated <- cID
 			return nil
 		})
-		nodeIPIdentifier++
 	}
 
 	if err = errg.Wait(); err != nil {
@@ -239,6 +318,121 @@ func CreateNodes(ctx context.Context, docker nodeCreator, cfg NodesConfig) (*Nod
 	return &result, nil
 }
 
+// NodeConfig is the configuration of a single manager or worker node.
+type NodeConfig struct {
+	ClusterName string
+	Name        string
+	Role        string
+	ImageRef    string
+
+	NetworkID   string
+	NetworkName string
+	IPAddress   string
+	IPv6Address string
+
+	DaemonArgs []string
+	// ClusterDaemonArgs are the daemon args of the cluster, without node overrides, recorded on the node to create new ones alike.
+	ClusterDaemonArgs []string
+}
+
+func (n *NodeConfig) labels(role string) map[string]string {
+	// Encoding a slice of strings can't fail.
+	args, _ := json.Marshal(n.ClusterDaemonArgs)
+
+	return map[string]string{
+		ClusterNameLabel: n.ClusterName,
+		NodeRoleLabel:    role,
+		DaemonArgsLabel:  string(args),
+	}
+}
+
+// ClusterDaemonArgs returns the daemon args of the cluster recorded in the labels of a node.
+// It returns false if the node has no such record, as with nodes created by previous versions.
+func ClusterDaemonArgs(labels map[string]string) ([]string, bool, error) {
+	value, ok := labels[DaemonArgsLabel]
+	if !ok {
+		return nil, false, nil
+	}
+
+	var args []string
+	if err := json.Unmarshal([]byte(value), &args); err != nil {
+		return nil, false, fmt.Errorf("invalid cluster daemon args %q: %v", value, err)
+	}
+
+	return args, true, nil
+}
+
+func (n *NodeConfig) ipamConfig() *network.EndpointIPAMConfig {
+	return &network.EndpointIPAMConfig{
+		IPv4Address: n.IPAddress,
+		IPv6Address: n.IPv6Address,
+	}
+}
+
+func (n *NodesConfig) nodeConfig(role string, index uint16, ip string) (NodeConfig, error) {
+	nodeName := NodeName(role, index)
+
+	var ipv6 string
+
+	if n.IPv6Subnet != nil {
+		var err error
+
+		ipv6, err = IPv6Address(n.Subnet, *n.IPv6Subnet, ip)
+		if err != nil {
+			return NodeConfig{}, fmt.Errorf("unable to allocate an IPv6 address to node %q: %v", nodeName, err)
+		}
+	}
+
+	return NodeConfig{
+		ClusterName: n.ClusterName,
+		Name:        nodeName,
+		Role:        role,
+		ImageRef:    n.imageRef(nodeName),
+		NetworkID:   n.NetworkID,
+		NetworkName: n.NetworkName,
+		IPAddress:   ip,
+		IPv6Address: ipv6,
+		DaemonArgs:  n.daemonArgs(nodeName),
+
+		ClusterDaemonArgs: n.DaemonArgs,
+	}, nil
+}
+
+// CreateNode creates and starts a manager or worker node container, and returns its ID.
+// The daemon of a manager node is published on a random port of the host, so that it can be used when the primary node is down.
+func CreateNode(ctx context.Context, docker nodeCreator, cfg NodeConfig) (string, error) {
+	cConfig := &container.Config{
+		Image:      cfg.ImageRef,
+		Entrypoint: []string{"dockerd"},
+		Hostname:   ContainerName(cfg.ClusterName, cfg.Name),
+		Labels:     cfg.labels(cfg.Role),
+		Cmd:        cfg.DaemonArgs,
+	}
+
+	hConfig := &container.HostConfig{Privileged: true}
+
+	if cfg.Role == NodeRoleManager {
+		cConfig.Cmd = append(append([]string{}, daemonHosts...), cfg.DaemonArgs...)
+		cConfig.ExposedPorts = nat.PortSet{daemonPort: struct{}{}}
+		hConfig.PortBindings = nat.PortMap{daemonPort: []nat.PortBinding{{}}}
+	}
+
+	return runContainer(
+		ctx,
+		docker,
+		cConfig,
+		hConfig,
+		&network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				cfg.NetworkName: {
+					NetworkID:  cfg.NetworkID,
+					IPAMConfig: cfg.ipamConfig(),
+				},
+			},
+		},
+	)
+}
+
 func runContainer(ctx context.Context, client nodeCreator, cConfig *container.Config, hConfig *container.HostConfig, nConfig *network.NetworkingConfig) (string, error) {
 	resp, err := client.ContainerCreate(
 		ctx,
@@ -257,3 +451,13 @@ func runContainer(ctx context.Context, client nodeCreator, cConfig *container.Co
 
 	return resp.ID, nil
 }
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"sort"
+	"sync"
 	"testing"
 
 	"github.com/docker/docker/api/types"
@@ -39,12 +40,91 @@ func TestCreateNodesFailsWhenPortBindingsIsInvalid(t *testing.T) {
 	ctx := context.Background()
 	cfg := NodesConfig{
 		PortBindings: []string{"notaport"},
+		Subnet:       net.IPNet{IP: net.IP([]byte{10, 0, 117, 0}), Mask: net.CIDRMask(24, 32)},
+		Managers:     1,
 	}
 	mock := nodeStarterMock{}
 
 	_, err := CreateNodes(ctx, mock, cfg)
 
-	assert.Error(t, err)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to define port bindings")
+}
+
+func TestCreateNodesFailsWhenNodesDoNotFitTheSubnet(t *testing.T) {
+	ctx := context.Background()
+	cfg := NodesConfig{
+		Subnet:   net.IPNet{IP: net.IP([]byte{10, 0, 117, 0}), Mask: net.CIDRMask(24, 32)},
+		Managers: 3,
+		Workers:  251,
+	}
+	mock := nodeStarterMock{}
+
+	_, err := CreateNodes(ctx, mock, cfg)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not enough free addresses in subnet 10.0.117.0/24, 254 requested, 253 available")
+}
+
+func TestCreateNodesFailsBeforeCreatingAnyNodeWhenANodeConfigIsInvalid(t *testing.T) {
+	ctx := context.Background()
+	_, ipv6Subnet, err := net.ParseCIDR("fd00:1::/120")
+	require.NoError(t, err)
+
+	cfg := NodesConfig{
+		Subnet:     net.IPNet{IP: net.IP([]byte{10, 0, 0, 0}), Mask: net.CIDRMask(16, 32)},
+		IPv6Subnet: ipv6Subnet,
+		Managers:   1,
+		Workers:    1,
+	}
+
+	// The mock panics when a container is created.
+	mock := nodeStarterMock{}
+
+	_, err = CreateNodes(ctx, mock, cfg)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to allocate an IPv6 address to node \"manager-0\"")
+}
+
+func TestCreateNodesWithALargeSubnet(t *testing.T) {
+	ctx := context.Background()
+	cfg := NodesConfig{
+		ClusterName: "TestCluster",
+		Subnet:      net.IPNet{IP: net.IP([]byte{10, 0, 116, 0}), Mask: net.CIDRMask(22, 32)},
+		Managers:    3,
+		Workers:     500,
+	}
+
+	var (
+		mu  sync.Mutex
+		ips = make(map[string]string)
+	)
+
+	mock := nodeStarterMock{
+		containerCreate: func(ctx context.Context, cConfig *container.Config, hConfig *container.HostConfig, nConfig *network.NetworkingConfig, name string) (container.ContainerCreateCreatedBody, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			ips[nConfig.EndpointsConfig[cfg.NetworkName].IPAMConfig.IPv4Address] = name
+
+			return container.ContainerCreateCreatedBody{ID: name}, nil
+		},
+		containerStart: func(ctx context.Context, cID string, opts types.ContainerStartOptions) error {
+			return nil
+		},
+	}
+
+	ids, err := CreateNodes(ctx, mock, cfg)
+	require.NoError(t, err)
+
+	assert.Len(t, ids.Workers, 500)
+	assert.Len(t, ips, 503)
+	assert.Equal(t, "sind-TestCluster-manager-0", ips["10.0.116.2"])
+
+	for ip := range ips {
+		assert.True(t, cfg.Subnet.Contains(net.ParseIP(ip)), ip)
+	}
 }
 
 func TestCreateNodes(t *testing.T) {
@@ -54,7 +134,7 @@ func TestCreateNodes(t *testing.T) {
 		ImageRef:     "foo",
 		NetworkID:    "ababababab",
 		NetworkName:  "bar",
-		Subnet:       net.IPNet{IP: net.IP([]byte{10, 0, 117, 0})},
+		Subnet:       net.IPNet{IP: net.IP([]byte{10, 0, 117, 0}), Mask: net.CIDRMask(24, 32)},
 		PortBindings: []string{"8080:8080"},
 		Managers:     3,
 		Workers:      3,
@@ -63,6 +143,11 @@ func TestCreateNodes(t *testing.T) {
 
 	containerCreated := make(chan *fakeContainer, cfg.Managers+cfg.Workers)
 	containerRun := make(chan string, cfg.Managers+cfg.Workers)
+	nodeCreated := make(chan [2]string, cfg.Managers+cfg.Workers)
+
+	cfg.Created = func(cID, nodeName string) {
+		nodeCreated <- [2]string{cID, nodeName}
+	}
 
 	mock := nodeStarterMock{
 		containerCreate: func(ctx context.Context, cConfig *container.Config, hConfig *container.HostConfig, nConfig *network.NetworkingConfig, cName string) (container.ContainerCreateCreatedBody, error) {
@@ -86,6 +171,16 @@ func TestCreateNodes(t *testing.T) {
 
 	close(containerCreated)
 	close(containerRun)
+	close(nodeCreated)
+
+	var createdNodes []string
+
+	for created := range nodeCreated {
+		assert.Equal(t, ContainerName(cfg.ClusterName, created[1]), created[0])
+		createdNodes = append(createdNodes, created[1])
+	}
+
+	assert.ElementsMatch(t, []string{"manager-0", "manager-1", "manager-2", "worker-0", "worker-1", "worker-2"}, createdNodes)
 
 	t.Log(cIDs)
 
@@ -124,8 +219,9 @@ func TestCreateNodes(t *testing.T) {
 			Entrypoint:   []string{"dockerd"},
 			Cmd:          []string{"-H unix:///var/run/docker.sock", "-H tcp://0.0.0.0:2375", "--fake-arg"},
 			Labels: map[string]string{
-				"com.sind.cluster.name": "TestCluster",
-				"com.sind.cluster.role": "primary",
+				"com.sind.cluster.name":        "TestCluster",
+				"com.sind.cluster.role":        "primary",
+				"com.sind.cluster.daemon-args": `["--fake-arg"]`,
 			},
 		},
 		primary.cConfig,
@@ -167,21 +263,28 @@ func TestCreateNodes(t *testing.T) {
 		assert.Equal(
 			t,
 			&container.Config{
-				Hostname:   expectedContainerName,
-				Image:      cfg.ImageRef,
-				Entrypoint: []string{"dockerd"},
+				Hostname:     expectedContainerName,
+				Image:        cfg.ImageRef,
+				Entrypoint:   []string{"dockerd"},
+				ExposedPorts: nat.PortSet(map[nat.Port]struct{}{nat.Port("2375/tcp"): {}}),
 				Labels: map[string]string{
-					"com.sind.cluster.name": "TestCluster",
-					"com.sind.cluster.role": "manager",
+					"com.sind.cluster.name":        "TestCluster",
+					"com.sind.cluster.role":        "manager",
+					"com.sind.cluster.daemon-args": `["--fake-arg"]`,
 				},
-				Cmd: []string{"--fake-arg"},
+				Cmd: []string{"-H unix:///var/run/docker.sock", "-H tcp://0.0.0.0:2375", "--fake-arg"},
 			},
 			c.cConfig,
 		)
 
 		assert.Equal(
 			t,
-			&container.HostConfig{Privileged: true},
+			&container.HostConfig{
+				Privileged: true,
+				PortBindings: map[nat.Port][]nat.PortBinding{
+					nat.Port("2375/tcp"): {{}},
+				},
+			},
 			c.hConfig,
 		)
 
@@ -212,8 +315,9 @@ func TestCreateNodes(t *testing.T) {
 				Image:      cfg.ImageRef,
 				Entrypoint: []string{"dockerd"},
 				Labels: map[string]string{
-					"com.sind.cluster.name": "TestCluster",
-					"com.sind.cluster.role": "worker",
+					"com.sind.cluster.name":        "TestCluster",
+					"com.sind.cluster.role":        "worker",
+					"com.sind.cluster.daemon-args": `["--fake-arg"]`,
 				},
 				Cmd: []string{"--fake-arg"},
 			},
@@ -273,3 +377,197 @@ func TestCreateNodes(t *testing.T) {
 		},
 	})
 }
+
+func TestCreateNodesWithOverrides(t *testing.T) {
+	ctx := context.Background()
+	cfg := NodesConfig{
+		ClusterName: "TestCluster",
+		ImageRef:    "foo",
+		NetworkID:   "ababababab",
+		NetworkName: "bar",
+		Subnet:      net.IPNet{IP: net.IP([]byte{10, 0, 117, 0}), Mask: net.CIDRMask(24, 32)},
+		Managers:    1,
+		Workers:     2,
+		DaemonArgs:  []string{"--fake-arg"},
+		Nodes: map[string]NodeOverride{
+			"manager-0": {DaemonArgs: []string{"--other-arg"}},
+			"worker-1":  {ImageRef: "bar"},
+		},
+	}
+
+	containerCreated := make(chan *container.Config, cfg.Managers+cfg.Workers)
+
+	mock := nodeStarterMock{
+		containerCreate: func(ctx context.Context, cConfig *container.Config, hConfig *container.HostConfig, nConfig *network.NetworkingConfig, cName string) (container.ContainerCreateCreatedBody, error) {
+			containerCreated <- cConfig
+			return container.ContainerCreateCreatedBody{ID: cName}, nil
+		},
+		containerStart: func(ctx context.Context, cID string, opts types.ContainerStartOptions) error {
+			return nil
+		},
+	}
+
+	_, err := CreateNodes(ctx, mock, cfg)
+	require.NoError(t, err)
+
+	close(containerCreated)
+
+	configs := make(map[string]*container.Config)
+	for c := range containerCreated {
+		configs[c.Hostname] = c
+	}
+
+	require.Len(t, configs, 3)
+
+	assert.Equal(t, "foo", configs["sind-TestCluster-manager-0"].Image)
+	assert.EqualValues(t, []string{"-H unix:///var/run/docker.sock", "-H tcp://0.0.0.0:2375", "--other-arg"}, configs["sind-TestCluster-manager-0"].Cmd)
+
+	assert.Equal(t, "foo", configs["sind-TestCluster-worker-0"].Image)
+	assert.EqualValues(t, cfg.DaemonArgs, configs["sind-TestCluster-worker-0"].Cmd)
+
+	assert.Equal(t, "bar", configs["sind-TestCluster-worker-1"].Image)
+	assert.EqualValues(t, cfg.DaemonArgs, configs["sind-TestCluster-worker-1"].Cmd)
+	assert.Equal(t, []string{"--fake-arg"}, cfg.DaemonArgs)
+
+	// Every node records the daemon args of the cluster, without its own overrides.
+	for _, c := range configs {
+		args, ok, err := ClusterDaemonArgs(c.Labels)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, []string{"--fake-arg"}, args)
+	}
+}
+
+func TestApplyNodeRole(t *testing.T) {
+	worker := func() (*container.Config, *container.HostConfig) {
+		return &container.Config{
+				Labels:       map[string]string{ClusterNameLabel: "test", NodeRoleLabel: NodeRoleWorker},
+				Cmd:          []string{"--debug"},
+				ExposedPorts: nat.PortSet{"8080/tcp": {}},
+			},
+			&container.HostConfig{
+				PortBindings: nat.PortMap{"8080/tcp": []nat.PortBinding{{HostPort: "8080"}}},
+			}
+	}
+
+	cConfig, hConfig := worker()
+
+	ApplyNodeRole(NodeRoleManager, cConfig, hConfig)
+
+	assert.Equal(t, map[string]string{ClusterNameLabel: "test", NodeRoleLabel: NodeRoleManager}, cConfig.Labels)
+	assert.EqualValues(t, []string{"-H unix:///var/run/docker.sock", "-H tcp://0.0.0.0:2375", "--debug"}, cConfig.Cmd)
+	assert.Equal(t, nat.PortSet{"8080/tcp": {}, "2375/tcp": {}}, cConfig.ExposedPorts)
+	assert.Equal(
+		t,
+		nat.PortMap{"8080/tcp": []nat.PortBinding{{HostPort: "8080"}}, "2375/tcp": []nat.PortBinding{{}}},
+		hConfig.PortBindings,
+	)
+
+	ApplyNodeRole(NodeRoleWorker, cConfig, hConfig)
+
+	expectedCConfig, expectedHConfig := worker()
+
+	assert.Equal(t, expectedCConfig, cConfig)
+	assert.Equal(t, expectedHConfig, hConfig)
+}
+
+func TestClusterDaemonArgs(t *testing.T) {
+	args, ok, err := ClusterDaemonArgs(map[string]string{DaemonArgsLabel: "null"})
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Nil(t, args)
+
+	_, ok, err = ClusterDaemonArgs(map[string]string{})
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, _, err = ClusterDaemonArgs(map[string]string{DaemonArgsLabel: "--debug"})
+	require.Error(t, err)
+}
+
+func TestContainerNodeName(t *testing.T) {
+	assert.Equal(t, "worker-3", ContainerNodeName("foo", types.Container{Names: []string{"/sind-foo-worker-3"}}))
+	assert.Equal(t, "", ContainerNodeName("foo", types.Container{}))
+}
+
+func TestNodeIndex(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		role          string
+		nodeName      string
+		expectedIndex uint16
+		expectedOK    bool
+	}{
+		{
+			desc:          "with a matching role",
+			role:          NodeRoleWorker,
+			nodeName:      "worker-12",
+			expectedIndex: 12,
+			expectedOK:    true,
+		},
+		{
+			desc:     "with another role",
+			role:     NodeRoleManager,
+			nodeName: "worker-12",
+		},
+		{
+			desc:     "with an invalid index",
+			role:     NodeRoleWorker,
+			nodeName: "worker-foo",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			index, ok := NodeIndex(test.role, test.nodeName)
+			assert.Equal(t, test.expectedOK, ok)
+			assert.Equal(t, test.expectedIndex, index)
+		})
+	}
+}
+
+func TestCreateNodesWithIPv6(t *testing.T) {
+	ctx := context.Background()
+	_, ipv6Subnet, err := net.ParseCIDR("fd00:1::/64")
+	require.NoError(t, err)
+
+	cfg := NodesConfig{
+		ClusterName: "TestCluster",
+		NetworkName: "bar",
+		Subnet:      net.IPNet{IP: net.IP([]byte{10, 0, 117, 0}), Mask: net.CIDRMask(24, 32)},
+		IPv6Subnet:  ipv6Subnet,
+		Managers:    1,
+		Workers:     1,
+	}
+
+	var (
+		mu        sync.Mutex
+		addresses = make(map[string]*network.EndpointIPAMConfig)
+	)
+
+	mock := nodeStarterMock{
+		containerCreate: func(ctx context.Context, cConfig *container.Config, hConfig *container.HostConfig, nConfig *network.NetworkingConfig, name string) (container.ContainerCreateCreatedBody, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			addresses[name] = nConfig.EndpointsConfig["bar"].IPAMConfig
+
+			return container.ContainerCreateCreatedBody{ID: name}, nil
+		},
+		containerStart: func(ctx context.Context, cID string, opts types.ContainerStartOptions) error {
+			return nil
+		},
+	}
+
+	_, err = CreateNodes(ctx, mock, cfg)
+	require.NoError(t, err)
+
+	assert.Equal(
+		t,
+		map[string]*network.EndpointIPAMConfig{
+			"sind-TestCluster-manager-0": {IPv4Address: "10.0.117.2", IPv6Address: "fd00:1::2"},
+			"sind-TestCluster-worker-0":  {IPv4Address: "10.0.117.3", IPv6Address: "fd00:1::3"},
+		},
+		addresses,
+	)
+}
@@ -0,0 +1,370 @@
+package internal
+
+import (
+	"archive/tar"
+	"bytes"
+	// Registers the digest algorithm of the OCI layout blobs.
+	_ "crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// dockerManifestList and dockerManifest are the docker media types of the image indexes and manifests, used by some OCI layouts.
+	dockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	dockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+
+	// containerdImageName is the annotation holding the full name of an image, set by buildkit.
+	containerdImageName = "io.containerd.image.name"
+)
+
+// OCILayout is an OCI image layout directory, which can be streamed as a docker save archive.
+type OCILayout struct {
+	dir    string
+	images []ociImage
+}
+
+// ociImage is an image of an OCI layout, as described in the manifest of a docker save archive.
+type ociImage struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+
+	configDigest digest.Digest
+	layerDigests []digest.Digest
+}
+
+// ReadOCILayout reads the images of an OCI image layout directory.
+// The manifest of given platform is picked from multi platform images. Images are tagged with the name annotated by buildkit,
+// or with the ref name annotation if it is a tagged reference, and are left untagged otherwise.
+func ReadOCILayout(dir, platformOS, platformArch string) (*OCILayout, error) {
+	layout := OCILayout{dir: dir}
+
+	var index ocispec.Index
+	if err := layout.readJSON(filepath.Join(dir, "index.json"), &index); err != nil {
+		return nil, fmt.Errorf("unable to read the OCI layout index: %v", err)
+	}
+
+	for _, desc := range index.Manifests {
+		manifestDesc, err := layout.platformManifest(desc, platformOS, platformArch)
+		if err != nil {
+			return nil, err
+		}
+
+		var manifest ocispec.Manifest
+		if err = layout.readBlob(manifestDesc.Digest, &manifest); err != nil {
+			return nil, fmt.Errorf("unable to read manifest %q: %v", manifestDesc.Digest, err)
+		}
+
+		if err = validateDigests(manifest); err != nil {
+			return nil, fmt.Errorf("invalid manifest %q: %v", manifestDesc.Digest, err)
+		}
+
+		image := ociImage{
+			Config:       manifest.Config.Digest.Hex() + ".json",
+			RepoTags:     ociRepoTags(desc.Annotations),
+			configDigest: manifest.Config.Digest,
+		}
+
+		for _, layer := range manifest.Layers {
+			image.Layers = append(image.Layers, layer.Digest.Hex()+"/layer.tar")
+			image.layerDigests = append(image.layerDigests, layer.Digest)
+		}
+
+		layout.images = append(layout.images, image)
+	}
+
+	if len(layout.images) == 0 {
+		return nil, fmt.Errorf("OCI layout %q holds no image", dir)
+	}
+
+	return &layout, nil
+}
+
+// platformManifest returns the descriptor of the image manifest of given platform, resolving image indexes.
+func (l *OCILayout) platformManifest(desc ocispec.Descriptor, platformOS, platformArch string) (ocispec.Descriptor, error) {
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageManifest, dockerManifest:
+		return desc, nil
+	case ocispec.MediaTypeImageIndex, dockerManifestList:
+		var index ocispec.Index
+		if err := l.readBlob(desc.Digest, &index); err != nil {
+			return ocispec.Descriptor{}, fmt.Errorf("unable to read image index %q: %v", desc.Digest, err)
+		}
+
+		for _, manifest := range index.Manifests {
+			if manifest.Platform != nil && manifest.Platform.OS == platformOS && manifest.Platform.Architecture == platformArch {
+				return l.platformManifest(manifest, platformOS, platformArch)
+			}
+		}
+
+		return ocispec.Descriptor{}, fmt.Errorf("image index %q has no manifest for platform %s/%s", desc.Digest, platformOS, platformArch)
+	default:
+		return ocispec.Descriptor{}, fmt.Errorf("unsupported media type %q for manifest %q", desc.MediaType, desc.Digest)
+	}
+}
+
+// ociRepoTags returns the tags of an image given the annotations of its descriptor.
+func ociRepoTags(annotations map[string]string) []string {
+	for _, name := range []string{annotations[containerdImageName], annotations[ocispec.AnnotationRefName]} {
+		named, err := reference.ParseNormalizedNamed(name)
+		if err != nil {
+			continue
+		}
+
+		// A ref name holding a tag only is parsed as an untagged repository.
+		if _, ok := named.(reference.Tagged); ok {
+			return []string{reference.FamiliarString(named)}
+		}
+	}
+
+	return nil
+}
+
+// Archive streams the images of the layout as a docker save archive. Zstd compressed layers are decompressed,
+// as the daemons of the nodes can't load them.
+func (l *OCILayout) Archive() io.ReadCloser {
+	reader, writer := io.Pipe()
+
+	go func() {
+		writer.CloseWithError(l.writeArchive(writer))
+	}()
+
+	return reader
+}
+
+func (l *OCILayout) writeArchive(out io.Writer) error {
+	tarWriter := tar.NewWriter(out)
+
+	manifest, err := json.Marshal(l.images)
+	if err != nil {
+		return fmt.Errorf("unable to encode the archive manifest: %v", err)
+	}
+
+	if err = writeTarFile(tarWriter, "manifest.json", int64(len(manifest)), bytesOpener(manifest)); err != nil {
+		return err
+	}
+
+	written := make(map[string]bool)
+
+	for _, image := range l.images {
+		if err = l.writeBlob(tarWriter, written, image.Config, image.configDigest, false); err != nil {
+			return err
+		}
+
+		for i, layer := range image.layerDigests {
+			if err = l.writeBlob(tarWriter, written, image.Layers[i], layer, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = tarWriter.Close(); err != nil {
+		return fmt.Errorf("unable to close the tar writer properly: %v", err)
+	}
+
+	return nil
+}
+
+// writeBlob writes a blob of the layout to the archive under given name, once.
+func (l *OCILayout) writeBlob(tarWriter *tar.Writer, written map[string]bool, name string, dgst digest.Digest, layer bool) error {
+	if written[name] {
+		return nil
+	}
+
+	written[name] = true
+
+	path, err := l.blobPath(dgst)
+	if err != nil {
+		return err
+	}
+
+	open := fileOpener(path)
+
+	header, err := readHeader(open)
+	if err != nil {
+		return fmt.Errorf("unable to read blob %q: %v", dgst, err)
+	}
+
+	if layer && ArchiveCompression(header) == CompressionZstd {
+		open = zstdOpener(open)
+	}
+
+	size, err := blobSize(open)
+	if err != nil {
+		return fmt.Errorf("unable to read blob %q: %v", dgst, err)
+	}
+
+	if layer {
+		if err = tarWriter.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: filepath.Dir(name) + "/", Mode: 0755}); err != nil {
+			return fmt.Errorf("unable to write tar directory header: %v", err)
+		}
+	}
+
+	return writeTarFile(tarWriter, name, size, open)
+}
+
+// validateDigests checks the digests of the blobs of a manifest, which are used as paths.
+func validateDigests(manifest ocispec.Manifest) error {
+	if err := manifest.Config.Digest.Validate(); err != nil {
+		return fmt.Errorf("invalid config digest %q: %v", manifest.Config.Digest, err)
+	}
+
+	for _, layer := range manifest.Layers {
+		if err := layer.Digest.Validate(); err != nil {
+			return fmt.Errorf("invalid layer digest %q: %v", layer.Digest, err)
+		}
+	}
+
+	return nil
+}
+
+func (l *OCILayout) blobPath(dgst digest.Digest) (string, error) {
+	if err := dgst.Validate(); err != nil {
+		return "", fmt.Errorf("invalid blob digest %q: %v", dgst, err)
+	}
+
+	return filepath.Join(l.dir, "blobs", dgst.Algorithm().String(), dgst.Hex()), nil
+}
+
+func (l *OCILayout) readBlob(dgst digest.Digest, value interface{}) error {
+	path, err := l.blobPath(dgst)
+	if err != nil {
+		return err
+	}
+
+	return l.readJSON(path, value)
+}
+
+func (l *OCILayout) readJSON(path string, value interface{}) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(content, value)
+}
+
+// opener opens a content to write to an archive, it can be called several times.
+type opener func() (io.ReadCloser, error)
+
+func fileOpener(path string) opener {
+	return func() (io.ReadCloser, error) {
+		return os.Open(path)
+	}
+}
+
+func bytesOpener(content []byte) opener {
+	return func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(content)), nil
+	}
+}
+
+// zstdOpener decompresses the zstd content of given opener.
+func zstdOpener(open opener) opener {
+	return func() (io.ReadCloser, error) {
+		compressed, err := open()
+		if err != nil {
+			return nil, err
+		}
+
+		decompressed, err := ZstdReader(compressed)
+		if err != nil {
+			compressed.Close()
+			return nil, err
+		}
+
+		return readCloser{Reader: decompressed, closers: []io.Closer{decompressed, compressed}}, nil
+	}
+}
+
+type readCloser struct {
+	io.Reader
+
+	closers []io.Closer
+}
+
+func (r readCloser) Close() error {
+	var err error
+
+	for _, closer := range r.closers {
+		if closeErr := closer.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+// readHeader returns the first bytes of a content, to detect its compression.
+func readHeader(open opener) ([]byte, error) {
+	content, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	header := make([]byte, len(zstdMagic))
+
+	n, err := io.ReadFull(content, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+
+	return header[:n], nil
+}
+
+// blobSize returns the size of a content, which has to be read entirely if it is decompressed.
+func blobSize(open opener) (int64, error) {
+	content, err := open()
+	if err != nil {
+		return 0, err
+	}
+	defer content.Close()
+
+	if file, ok := content.(*os.File); ok {
+		info, err := file.Stat()
+		if err != nil {
+			return 0, err
+		}
+
+		return info.Size(), nil
+	}
+
+	return io.Copy(ioutil.Discard, content)
+}
+
+// writeTarFile writes a regular file of given name and size to a tar archive.
+func writeTarFile(tarWriter *tar.Writer, name string, size int64, open opener) error {
+	err := tarWriter.WriteHeader(
+		&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     size,
+			Mode:     0644,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("unable to write tar file header: %v", err)
+	}
+
+	content, err := open()
+	if err != nil {
+		return fmt.Errorf("unable to open %q: %v", name, err)
+	}
+	defer content.Close()
+
+	if _, err = io.Copy(tarWriter, content); err != nil {
+		return fmt.Errorf("unable to tar %q: %v", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ociLayoutBuilder writes the blobs of an OCI layout to a directory.
+type ociLayoutBuilder struct {
+	t   *testing.T
+	dir string
+}
+
+func (b *ociLayoutBuilder) blob(mediaType string, content []byte) ocispec.Descriptor {
+	dgst := digest.FromBytes(content)
+
+	path := filepath.Join(b.dir, "blobs", "sha256", dgst.Hex())
+	require.NoError(b.t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(b.t, ioutil.WriteFile(path, content, 0644))
+
+	return ocispec.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(content))}
+}
+
+func (b *ociLayoutBuilder) jsonBlob(mediaType string, value interface{}) ocispec.Descriptor {
+	content, err := json.Marshal(value)
+	require.NoError(b.t, err)
+
+	return b.blob(mediaType, content)
+}
+
+func (b *ociLayoutBuilder) index(manifests ...ocispec.Descriptor) {
+	content, err := json.Marshal(ocispec.Index{Manifests: manifests})
+	require.NoError(b.t, err)
+
+	require.NoError(b.t, ioutil.WriteFile(filepath.Join(b.dir, "index.json"), content, 0644))
+}
+
+func TestOCILayoutArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sind_oci")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	builder := ociLayoutBuilder{t: t, dir: dir}
+
+	encoder, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+
+	config := builder.blob(ocispec.MediaTypeImageConfig, []byte(`{"architecture":"amd64"}`))
+	gzipLayer := builder.blob(ocispec.MediaTypeImageLayerGzip, []byte{0x1f, 0x8b, 0x08, 0x00, 0x42})
+	zstdLayer := builder.blob("application/vnd.oci.image.layer.v1.tar+zstd", encoder.EncodeAll([]byte("zstd layer"), nil))
+
+	manifest := builder.jsonBlob(ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Config: config,
+		Layers: []ocispec.Descriptor{gzipLayer, zstdLayer},
+	})
+	manifest.Platform = &ocispec.Platform{OS: "linux", Architecture: "amd64"}
+
+	armManifest := builder.jsonBlob(ocispec.MediaTypeImageManifest, ocispec.Manifest{Config: config})
+	armManifest.Platform = &ocispec.Platform{OS: "linux", Architecture: "arm64"}
+
+	index := builder.jsonBlob(ocispec.MediaTypeImageIndex, ocispec.Index{Manifests: []ocispec.Descriptor{armManifest, manifest}})
+	index.Annotations = map[string]string{
+		containerdImageName:       "docker.io/jlevesy/sind:v1",
+		ocispec.AnnotationRefName: "v1",
+	}
+
+	builder.index(index)
+
+	layout, err := ReadOCILayout(dir, "linux", "amd64")
+	require.NoError(t, err)
+
+	archive := layout.Archive()
+	defer archive.Close()
+
+	files := make(map[string][]byte)
+	tarReader := tar.NewReader(archive)
+
+	for {
+		hdr, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+
+		require.NoError(t, err)
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		content, err := ioutil.ReadAll(tarReader)
+		require.NoError(t, err)
+
+		files[hdr.Name] = content
+	}
+
+	assert.JSONEq(
+		t,
+		`[{
+			"Config": "`+config.Digest.Hex()+`.json",
+			"RepoTags": ["jlevesy/sind:v1"],
+			"Layers": ["`+gzipLayer.Digest.Hex()+`/layer.tar", "`+zstdLayer.Digest.Hex()+`/layer.tar"]
+		}]`,
+		string(files["manifest.json"]),
+	)
+
+	assert.Equal(t, []byte(`{"architecture":"amd64"}`), files[config.Digest.Hex()+".json"])
+	assert.Equal(t, []byte{0x1f, 0x8b, 0x08, 0x00, 0x42}, files[gzipLayer.Digest.Hex()+"/layer.tar"])
+	assert.Equal(t, []byte("zstd layer"), files[zstdLayer.Digest.Hex()+"/layer.tar"])
+}
+
+func TestReadOCILayoutErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sind_oci")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	builder := ociLayoutBuilder{t: t, dir: dir}
+
+	manifest := builder.jsonBlob(ocispec.MediaTypeImageManifest, ocispec.Manifest{})
+	manifest.Platform = &ocispec.Platform{OS: "linux", Architecture: "arm64"}
+
+	builder.index(builder.jsonBlob(ocispec.MediaTypeImageIndex, ocispec.Index{Manifests: []ocispec.Descriptor{manifest}}))
+
+	_, err = ReadOCILayout(dir, "linux", "amd64")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no manifest for platform linux/amd64")
+
+	_, err = ReadOCILayout(dir, "linux", "arm64")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid config digest")
+
+	_, err = ReadOCILayout(filepath.Join(dir, "missing"), "linux", "amd64")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to read the OCI layout index")
+}
+
+func TestOCIRepoTags(t *testing.T) {
+	assert.Equal(t, []string{"jlevesy/sind:v1"}, ociRepoTags(map[string]string{containerdImageName: "docker.io/jlevesy/sind:v1"}))
+	assert.Equal(t, []string{"alpine:3.12"}, ociRepoTags(map[string]string{ocispec.AnnotationRefName: "alpine:3.12"}))
+	assert.Nil(t, ociRepoTags(map[string]string{ocispec.AnnotationRefName: "latest"}))
+	assert.Nil(t, ociRepoTags(nil))
+}
@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+)
+
+// ProxyConfig is the configuration of a proxy container, forwarding ports published on the host to the cluster nodes.
+type ProxyConfig struct {
+	ClusterName string
+	Name        string
+	Component   string
+	ImageRef    string
+	// Labels are additional labels applied to the proxy container.
+	Labels map[string]string
+
+	NetworkID   string
+	NetworkName string
+
+	// PortBindings are the bindings to publish on the host, the traffic is forwarded to the same port of the upstreams.
+	PortBindings []string
+	// Upstreams are the addresses of the nodes to forward the traffic to.
+	Upstreams []string
+}
+
+// CreateProxy creates and starts a proxy container, and returns its ID.
+func CreateProxy(ctx context.Context, docker nodeCreator, cfg ProxyConfig) (string, error) {
+	exposedPorts, portBindings, err := nat.ParsePortSpecs(cfg.PortBindings)
+	if err != nil {
+		return "", fmt.Errorf("unable to define port bindings: %v", err)
+	}
+
+	labels := map[string]string{
+		ClusterNameLabel:      cfg.ClusterName,
+		ClusterComponentLabel: cfg.Component,
+	}
+
+	for key, value := range cfg.Labels {
+		labels[key] = value
+	}
+
+	return runContainer(
+		ctx,
+		docker,
+		&container.Config{
+			Image:        cfg.ImageRef,
+			Hostname:     ContainerName(cfg.ClusterName, cfg.Name),
+			ExposedPorts: nat.PortSet(exposedPorts),
+			Labels:       labels,
+			Env:          proxyEnv(exposedPorts, cfg.Upstreams),
+			Entrypoint:   []string{"/bin/sh", "-c"},
+			Cmd:          []string{`printf '%s' "$NGINX_CONFIG" > /etc/nginx/nginx.conf && exec nginx -g 'daemon off;'`},
+		},
+		&container.HostConfig{
+			PortBindings:  nat.PortMap(portBindings),
+			RestartPolicy: container.RestartPolicy{Name: "unless-stopped"},
+		},
+		&network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				cfg.NetworkName: {NetworkID: cfg.NetworkID},
+			},
+		},
+	)
+}
+
+// UpdateProxyUpstreams makes a proxy container forward its traffic to given upstreams, and returns the ID of the proxy container.
+// As the configuration of a container can't be updated, the proxy container is recreated, unless its upstreams are already the given ones.
+func UpdateProxyUpstreams(ctx context.Context, client containerRecreator, cID string, upstreams []string) (string, error) {
+	info, err := client.ContainerInspect(ctx, cID)
+	if err != nil {
+		return "", fmt.Errorf("unable to inspect proxy %q: %v", cID, err)
+	}
+
+	// The exposed ports of the container include the ones of its image, the published ones are the proxied ports.
+	ports := make(map[nat.Port]struct{}, len(info.HostConfig.PortBindings))
+	for port := range info.HostConfig.PortBindings {
+		ports[port] = struct{}{}
+	}
+
+	env := proxyEnv(ports, upstreams)
+	if contains(info.Config.Env, env[0]) {
+		return info.ID, nil
+	}
+
+	return RecreateContainer(ctx, client, info.ID, func(cConfig *container.Config, _ *container.HostConfig) {
+		cConfig.Env = env
+	})
+}
+
+// proxyEnv returns the environment of a proxy container forwarding given ports to the upstreams.
+func proxyEnv(ports map[nat.Port]struct{}, upstreams []string) []string {
+	return []string{"NGINX_CONFIG=" + nginxConfig(ports, upstreams)}
+}
+
+// nginxConfig returns a nginx configuration forwarding each port to the same port of the upstreams.
+// Upstreams failing to accept a connection are skipped, so the traffic keeps flowing while a node is down.
+func nginxConfig(ports map[nat.Port]struct{}, upstreams []string) string {
+	sortedPorts := make([]nat.Port, 0, len(ports))
+	for port := range ports {
+		sortedPorts = append(sortedPorts, port)
+	}
+
+	sort.Slice(sortedPorts, func(i, j int) bool {
+		if sortedPorts[i].Int() != sortedPorts[j].Int() {
+			return sortedPorts[i].Int() < sortedPorts[j].Int()
+		}
+
+		return sortedPorts[i].Proto() < sortedPorts[j].Proto()
+	})
+
+	var conf strings.Builder
+
+	conf.WriteString("events {}\nstream {\n")
+
+	for _, port := range sortedPorts {
+		upstream := fmt.Sprintf("%s_%s", port.Proto(), port.Port())
+
+		fmt.Fprintf(&conf, "  upstream %s {\n", upstream)
+
+		for _, addr := range upstreams {
+			fmt.Fprintf(&conf, "    server %s:%s;\n", addr, port.Port())
+		}
+
+		conf.WriteString("  }\n")
+		conf.WriteString("  server {\n")
+
+		if port.Proto() == "udp" {
+			fmt.Fprintf(&conf, "    listen %s udp;\n", port.Port())
+		} else {
+			fmt.Fprintf(&conf, "    listen %s;\n", port.Port())
+		}
+
+		conf.WriteString("    proxy_connect_timeout 1s;\n")
+		fmt.Fprintf(&conf, "    proxy_pass %s;\n", upstream)
+		conf.WriteString("  }\n")
+	}
+
+	conf.WriteString("}\n")
+
+	return conf.String()
+}
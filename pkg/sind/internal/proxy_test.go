@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateProxy(t *testing.T) {
+	ctx := context.Background()
+
+	var (
+		created fakeContainer
+		started string
+	)
+
+	mock := nodeStarterMock{
+		containerCreate: func(ctx context.Context, cConfig *container.Config, hConfig *container.HostConfig, nConfig *network.NetworkingConfig, name string) (container.ContainerCreateCreatedBody, error) {
+			created = fakeContainer{name: name, cConfig: cConfig, hConfig: hConfig, nConfig: nConfig}
+
+			return container.ContainerCreateCreatedBody{ID: "proxyID"}, nil
+		},
+		containerStart: func(ctx context.Context, cID string, opts types.ContainerStartOptions) error {
+			started = cID
+
+			return nil
+		},
+	}
+
+	cID, err := CreateProxy(ctx, mock, ProxyConfig{
+		ClusterName:  "foo",
+		Name:         "lb",
+		Component:    ComponentLoadBalancer,
+		ImageRef:     "nginx",
+		NetworkID:    "netID",
+		NetworkName:  "bar",
+		PortBindings: []string{"8080:80"},
+		Upstreams:    []string{"10.0.0.2", "10.0.0.3"},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "proxyID", cID)
+	assert.Equal(t, "proxyID", started)
+	assert.Equal(t, "sind-foo-lb", created.name)
+	assert.Equal(t, "nginx", created.cConfig.Image)
+	assert.Equal(
+		t,
+		map[string]string{ClusterNameLabel: "foo", ClusterComponentLabel: ComponentLoadBalancer},
+		created.cConfig.Labels,
+	)
+	assert.Contains(t, created.cConfig.Env[0], "server 10.0.0.3:80;")
+	assert.Equal(
+		t,
+		nat.PortMap{"80/tcp": []nat.PortBinding{{HostPort: "8080"}}},
+		created.hConfig.PortBindings,
+	)
+	assert.Equal(t, "netID", created.nConfig.EndpointsConfig["bar"].NetworkID)
+}
+
+func TestCreateProxyFailsWhenPortBindingsIsInvalid(t *testing.T) {
+	_, err := CreateProxy(context.Background(), nodeStarterMock{}, ProxyConfig{PortBindings: []string{"notaport"}})
+	assert.Error(t, err)
+}
+
+func TestUpdateProxyUpstreams(t *testing.T) {
+	ports := map[nat.Port]struct{}{"8080/tcp": {}}
+	proxy := func(upstreams []string) types.ContainerJSON {
+		return types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				ID:         "old",
+				Name:       "/sind-test-lb",
+				State:      &types.ContainerState{Running: true},
+				HostConfig: &container.HostConfig{PortBindings: nat.PortMap{"8080/tcp": []nat.PortBinding{{HostPort: "8080"}}}},
+			},
+			Config: &container.Config{
+				Image: "nginx:1.19-alpine",
+				// The image exposes a port which is not published.
+				ExposedPorts: nat.PortSet{"80/tcp": {}, "8080/tcp": {}},
+				Env:          append([]string{"PATH=/usr/bin"}, proxyEnv(ports, upstreams)...),
+			},
+			NetworkSettings: &types.NetworkSettings{},
+		}
+	}
+
+	var created *container.Config
+
+	client := containerRecreatorMock{info: proxy([]string{"10.0.0.2"})}
+	client.nodeStarterMock = nodeStarterMock{
+		containerCreate: func(ctx context.Context, ccfg *container.Config, hcfg *container.HostConfig, ncfg *network.NetworkingConfig, cName string) (container.ContainerCreateCreatedBody, error) {
+			created = ccfg
+			return container.ContainerCreateCreatedBody{ID: "new"}, nil
+		},
+		containerStart: func(ctx context.Context, cID string, opts types.ContainerStartOptions) error {
+			return nil
+		},
+	}
+
+	cID, err := UpdateProxyUpstreams(context.Background(), &client, "old", []string{"10.0.0.2"})
+	require.NoError(t, err)
+	assert.Equal(t, "old", cID)
+	assert.Empty(t, client.calls)
+
+	cID, err = UpdateProxyUpstreams(context.Background(), &client, "old", []string{"10.0.0.2", "10.0.0.4"})
+	require.NoError(t, err)
+	assert.Equal(t, "new", cID)
+
+	require.NotNil(t, created)
+	assert.Equal(t, proxyEnv(ports, []string{"10.0.0.2", "10.0.0.4"}), created.Env)
+}
+
+func TestNginxConfig(t *testing.T) {
+	ports := map[nat.Port]struct{}{
+		"8080/tcp": {},
+		"53/udp":   {},
+	}
+
+	assert.Equal(
+		t,
+		`events {}
+stream {
+  upstream udp_53 {
+    server 10.0.0.2:53;
+    server 10.0.0.3:53;
+  }
+  server {
+    listen 53 udp;
+    proxy_connect_timeout 1s;
+    proxy_pass udp_53;
+  }
+  upstream tcp_8080 {
+    server 10.0.0.2:8080;
+    server 10.0.0.3:8080;
+  }
+  server {
+    listen 8080;
+    proxy_connect_timeout 1s;
+    proxy_pass tcp_8080;
+  }
+}
+`,
+		nginxConfig(ports, []string{"10.0.0.2", "10.0.0.3"}),
+	)
+}
@@ -2,13 +2,19 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/url"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/docker/docker/api/types"
-	"github.com/golang/sync/errgroup"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
 )
 
 const (
@@ -21,25 +27,27 @@ func SwarmDefaultListenAddress() string {
 	return net.JoinHostPort("0.0.0.0", strconv.Itoa(swarmGossipPort))
 }
 
-// SwarmPort returns the port to use to communicate with the swarm cluster on given primary container.
+// SwarmIPv6ListenAddress returns the listen address of the swarm nodes of a dual stack cluster.
+func SwarmIPv6ListenAddress() string {
+	return net.JoinHostPort("::", strconv.Itoa(swarmGossipPort))
+}
+
+// SwarmPort returns the port to use to communicate with the swarm cluster on given manager container.
 func SwarmPort(container types.Container) (uint16, error) {
-	var swarmPort *types.Port
+	return publishedPort(container, dockerDaemonPort)
+}
 
+// publishedPort returns the port of the host publishing given port of a container.
+func publishedPort(container types.Container, privatePort uint16) (uint16, error) {
 	for _, port := range container.Ports {
-		if port.PrivatePort != dockerDaemonPort {
+		if port.PrivatePort != privatePort || port.PublicPort == 0 {
 			continue
 		}
 
-		swarmPort = &port
-
-		break
+		return port.PublicPort, nil
 	}
 
-	if swarmPort == nil {
-		return 0, fmt.Errorf("container does not export port %d", dockerDaemonPort)
-	}
-
-	return swarmPort.PublicPort, nil
+	return 0, fmt.Errorf("container does not export port %d", privatePort)
 }
 
 type hoster interface {
@@ -67,57 +75,346 @@ type ClusterParams struct {
 	PrimaryNodeIP    string
 	ManagerJoinToken string
 	WorkerJoinToken  string
+
+	// ListenAddr is the listen address of the joining nodes, defaults to the swarm default.
+	ListenAddr string
+	// AdvertiseAddrs are the addresses advertised by the joining nodes, indexed by container ID.
+	// Nodes without address let the swarm pick one.
+	AdvertiseAddrs map[string]string
+
+	// Joined is called concurrently with the container ID of each node once joined, if set.
+	Joined func(cID string)
+}
+
+// joinCommand returns the command joining the node of given container to the swarm.
+func (p *ClusterParams) joinCommand(cID, token string) []string {
+	cmd := []string{"docker", "swarm", "join", "--token", token}
+
+	if p.ListenAddr != "" {
+		cmd = append(cmd, "--listen-addr", p.ListenAddr)
+	}
+
+	if addr, ok := p.AdvertiseAddrs[cID]; ok {
+		cmd = append(cmd, "--advertise-addr", addr)
+	}
+
+	return append(cmd, net.JoinHostPort(p.PrimaryNodeIP, strconv.Itoa(swarmGossipPort)))
 }
 
 // FormCluster make managers and workers to join the primary node.
+// It waits for the daemon of each node to be ready before joining, and reports every node which failed to join.
 func FormCluster(ctx context.Context, client executor, params ClusterParams) error {
-	errg, groupCtx := errgroup.WithContext(ctx)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		failures []string
+	)
 
-	managerAddr := net.JoinHostPort(params.PrimaryNodeIP, strconv.Itoa(swarmGossipPort))
+	join := func(cID, token string) {
+		defer wg.Done()
+
+		err := WaitNodeDaemonReady(ctx, client, cID)
+		if err == nil {
+			err = execContainer(ctx, client, cID, params.joinCommand(cID, token))
+		}
+
+		if err == nil {
+			if params.Joined != nil {
+				params.Joined(cID)
+			}
+
+			return
+		}
+
+		var execErr *ExecError
+
+		failure := fmt.Sprintf("container %q failed to join: %v", cID, err)
+		if errors.As(err, &execErr) {
+			failure = execErr.Error()
+		}
+
+		mu.Lock()
+		failures = append(failures, failure)
+		mu.Unlock()
+	}
 
 	for _, managerID := range params.IDs.Managers {
-		cid := managerID
-
-		errg.Go(func() error {
-			return execContainer(
-				groupCtx,
-				client,
-				cid,
-				[]string{
-					"docker",
-					"swarm",
-					"join",
-					"--token",
-					params.ManagerJoinToken,
-					managerAddr,
-				},
-			)
-		})
+		wg.Add(1)
+
+		go join(managerID, params.ManagerJoinToken)
 	}
 
 	for _, workerID := range params.IDs.Workers {
-		cid := workerID
+		wg.Add(1)
+
+		go join(workerID, params.WorkerJoinToken)
+	}
+
+	wg.Wait()
+
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return fmt.Errorf("unable to form the cluster: %s", strings.Join(failures, "; "))
+	}
+
+	return nil
+}
+
+type swarmNodeLister interface {
+	NodeList(context.Context, types.NodeListOptions) ([]swarm.Node, error)
+}
+
+// SwarmNode returns the swarm node of given hostname, or nil if the host is not a member of the swarm.
+func SwarmNode(ctx context.Context, client swarmNodeLister, hostname string) (*swarm.Node, error) {
+	nodes, err := client.NodeList(ctx, types.NodeListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list swarm nodes: %v", err)
+	}
+
+	for _, node := range nodes {
+		if node.Description.Hostname == hostname {
+			return &node, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// SwarmNodes returns the members of the swarm indexed by hostname.
+// A node which left and joined the swarm again is listed twice by the swarm, in that case the ready one is returned.
+func SwarmNodes(ctx context.Context, client swarmNodeLister) (map[string]swarm.Node, error) {
+	nodes, err := client.NodeList(ctx, types.NodeListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list swarm nodes: %v", err)
+	}
+
+	return nodesByHostname(nodes), nil
+}
+
+// nodesByHostname indexes given swarm nodes by hostname, preferring the ready node when a hostname is listed twice.
+func nodesByHostname(nodes []swarm.Node) map[string]swarm.Node {
+	result := make(map[string]swarm.Node, len(nodes))
+
+	for _, node := range nodes {
+		known, ok := result[node.Description.Hostname]
+		if ok && known.Status.State == swarm.NodeStateReady {
+			continue
+		}
+
+		result[node.Description.Hostname] = node
+	}
+
+	return result
+}
+
+// WaitSwarmNode waits until the node of given hostname is a member of the swarm, and returns it.
+func WaitSwarmNode(ctx context.Context, client swarmNodeLister, hostname string) (*swarm.Node, error) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			node, err := SwarmNode(ctx, client, hostname)
+			if err != nil || node == nil {
+				continue
+			}
+
+			return node, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// WaitSwarmConverged waits until all the expected nodes, given as a map of hostname to role, are members of the swarm,
+// ready and active, and until expected managers are reachable.
+// If the context is done before, it returns an error detailing the state of each node which did not converge.
+func WaitSwarmConverged(ctx context.Context, client swarmNodeLister, expected map[string]string) error {
+	return waitSwarm(ctx, client, "converge", func(nodes []swarm.Node) []string {
+		return pendingNodes(nodes, expected, true)
+	})
+}
+
+// WaitSwarmHealthy waits until all the expected nodes, given as a map of hostname to role, are ready members of the swarm,
+// until expected managers are reachable and a leader is elected. Unlike WaitSwarmConverged, the availability of the nodes is ignored.
+// If the context is done before, it returns an error detailing the state of each node which is not healthy.
+func WaitSwarmHealthy(ctx context.Context, client swarmNodeLister, expected map[string]string) error {
+	return waitSwarm(ctx, client, "become healthy", func(nodes []swarm.Node) []string {
+		pending := pendingNodes(nodes, expected, false)
+
+		for _, node := range nodes {
+			if node.ManagerStatus != nil && node.ManagerStatus.Leader {
+				return pending
+			}
+		}
+
+		return append(pending, "no leader is elected")
+	})
+}
+
+// waitSwarm polls the swarm nodes until the pending func reports no pending node.
+func waitSwarm(ctx context.Context, client swarmNodeLister, what string, pendingFunc func([]swarm.Node) []string) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	var pending []string
+
+	for {
+		select {
+		case <-ticker.C:
+			nodes, err := client.NodeList(ctx, types.NodeListOptions{})
+			if err != nil {
+				pending = []string{fmt.Sprintf("unable to list swarm nodes: %v", err)}
+				continue
+			}
+
+			pending = pendingFunc(nodes)
+			if len(pending) == 0 {
+				return nil
+			}
+		case <-ctx.Done():
+			return fmt.Errorf("swarm did not %s: %v: %s", what, ctx.Err(), strings.Join(pending, "; "))
+		}
+	}
+}
+
+// pendingNodes returns a description of each expected node which is not ready yet, or not active if checkAvailability is set.
+func pendingNodes(nodes []swarm.Node, expected map[string]string, checkAvailability bool) []string {
+	byHostname := nodesByHostname(nodes)
+
+	var pending []string
 
-		errg.Go(func() error {
-			return execContainer(
-				groupCtx,
-				client,
-				cid,
-				[]string{
-					"docker",
-					"swarm",
-					"join",
-					"--token",
-					params.WorkerJoinToken,
-					managerAddr,
-				},
-			)
-		})
+	for hostname, role := range expected {
+		node, ok := byHostname[hostname]
+
+		switch {
+		case !ok:
+			pending = append(pending, fmt.Sprintf("node %q has not joined the swarm", hostname))
+		case node.Status.State != swarm.NodeStateReady:
+			pending = append(pending, fmt.Sprintf("node %q is %s: %s", hostname, node.Status.State, node.Status.Message))
+		case checkAvailability && node.Spec.Availability != swarm.NodeAvailabilityActive:
+			pending = append(pending, fmt.Sprintf("node %q availability is %s", hostname, node.Spec.Availability))
+		case role != NodeRoleWorker && (node.ManagerStatus == nil || node.ManagerStatus.Reachability != swarm.ReachabilityReachable):
+			pending = append(pending, fmt.Sprintf("manager %q is not reachable", hostname))
+		}
 	}
 
-	if err := errg.Wait(); err != nil {
-		return fmt.Errorf("unable to form the cluster: %v", err)
+	sort.Strings(pending)
+
+	return pending
+}
+
+type swarmNodeUpdater interface {
+	NodeInspectWithRaw(context.Context, string) (swarm.Node, []byte, error)
+	NodeUpdate(context.Context, string, swarm.Version, swarm.NodeSpec) error
+}
+
+// UpdateSwarmNode applies given update to the spec of a swarm node.
+func UpdateSwarmNode(ctx context.Context, client swarmNodeUpdater, nodeID string, update func(*swarm.NodeSpec)) error {
+	node, _, err := client.NodeInspectWithRaw(ctx, nodeID)
+	if err != nil {
+		return fmt.Errorf("unable to inspect swarm node %q: %v", nodeID, err)
+	}
+
+	update(&node.Spec)
+
+	if err = client.NodeUpdate(ctx, nodeID, node.Version, node.Spec); err != nil {
+		return fmt.Errorf("unable to update swarm node %q: %v", nodeID, err)
 	}
 
 	return nil
 }
+
+type taskLister interface {
+	TaskList(context.Context, types.TaskListOptions) ([]swarm.Task, error)
+}
+
+// WaitTasksStopped waits until no task assigned to given swarm nodes is running or about to run.
+func WaitTasksStopped(ctx context.Context, client taskLister, nodeIDs []string) error {
+	if len(nodeIDs) == 0 {
+		return nil
+	}
+
+	args := filters.NewArgs()
+	for _, nodeID := range nodeIDs {
+		args.Add("node", nodeID)
+	}
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	var running int
+
+	for {
+		select {
+		case <-ticker.C:
+			tasks, err := client.TaskList(ctx, types.TaskListOptions{Filters: args})
+			if err != nil {
+				continue
+			}
+
+			running = runningTasks(tasks)
+			if running == 0 {
+				return nil
+			}
+		case <-ctx.Done():
+			return fmt.Errorf("%d task(s) are still running: %v", running, ctx.Err())
+		}
+	}
+}
+
+// runningTasks returns the amount of given tasks which are running or about to run.
+func runningTasks(tasks []swarm.Task) int {
+	var running int
+
+	for _, task := range tasks {
+		switch task.Status.State {
+		case swarm.TaskStateAssigned,
+			swarm.TaskStateAccepted,
+			swarm.TaskStatePreparing,
+			swarm.TaskStateReady,
+			swarm.TaskStateStarting,
+			swarm.TaskStateRunning:
+			running++
+		}
+	}
+
+	return running
+}
+
+// LeaveSwarm makes the node running in given container leave the swarm.
+func LeaveSwarm(ctx context.Context, client executor, cID string) error {
+	return execContainer(ctx, client, cID, []string{"docker", "swarm", "leave", "--force"})
+}
+
+// LocalSwarmState returns the swarm state of the node running in given container, as seen by its own daemon.
+func LocalSwarmState(ctx context.Context, client executor, cID string) (swarm.LocalNodeState, error) {
+	out, err := execContainerOutput(
+		ctx,
+		client,
+		cID,
+		types.ExecConfig{Cmd: []string{"docker", "info", "--format", "{{.Swarm.LocalNodeState}}"}},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return swarm.LocalNodeState(strings.TrimSpace(out)), nil
+}
+
+// UnlockSwarm unlocks the manager running in given container using given unlock key.
+// The key is passed through the environment of the command, to keep it out of the process list of the node.
+func UnlockSwarm(ctx context.Context, client executor, cID, key string) error {
+	_, err := execContainerOutput(
+		ctx,
+		client,
+		cID,
+		types.ExecConfig{
+			Cmd: []string{"sh", "-c", `printf '%s\n' "$SIND_UNLOCK_KEY" | docker swarm unlock`},
+			Env: []string{"SIND_UNLOCK_KEY=" + key},
+		},
+	)
+
+	return err
+}
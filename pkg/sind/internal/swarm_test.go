@@ -5,9 +5,12 @@ import (
 	"errors"
 	"sort"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -111,21 +114,35 @@ func TestFormCluster(t *testing.T) {
 		Cmd []string
 	}
 
-	execCreated := make(chan execCreation, len(params.IDs.Managers)+len(params.IDs.Workers))
-	execStarted := make(chan string, len(params.IDs.Managers)+len(params.IDs.Workers))
+	// Each node runs a daemon readiness check, then joins the swarm.
+	execCreated := make(chan execCreation, 2*(len(params.IDs.Managers)+len(params.IDs.Workers)))
+	execStarted := make(chan string, 2*(len(params.IDs.Managers)+len(params.IDs.Workers)))
+	nodeJoined := make(chan string, len(params.IDs.Managers)+len(params.IDs.Workers))
+
+	params.Joined = func(cID string) {
+		nodeJoined <- cID
+	}
 
 	client := executorMock{
 		containerExecCreate: func(ctx context.Context, cID string, opts types.ExecConfig) (types.IDResponse, error) {
 			assert.True(t, opts.AttachStdout)
 			assert.True(t, opts.AttachStderr)
+
+			if opts.Cmd[1] == "version" {
+				return types.IDResponse{ID: "version-" + cID}, nil
+			}
+
 			execCreated <- execCreation{cID: cID, Cmd: opts.Cmd}
 			return types.IDResponse{
 				ID: cID,
 			}, nil
 		},
-		containerExecStart: func(ctx context.Context, eID string, opts types.ExecStartCheck) error {
-			execStarted <- eID
-			return nil
+		containerExecAttach: func(ctx context.Context, eID string, opts types.ExecStartCheck) (types.HijackedResponse, error) {
+			if !strings.HasPrefix(eID, "version-") {
+				execStarted <- eID
+			}
+
+			return hijackedResponse(""), nil
 		},
 	}
 
@@ -133,6 +150,15 @@ func TestFormCluster(t *testing.T) {
 
 	close(execCreated)
 	close(execStarted)
+	close(nodeJoined)
+
+	var joinedNodes []string
+
+	for cID := range nodeJoined {
+		joinedNodes = append(joinedNodes, cID)
+	}
+
+	assert.ElementsMatch(t, []string{"b", "c", "d", "e", "f"}, joinedNodes)
 
 	var (
 		createdExecs     []execCreation
@@ -195,3 +221,392 @@ func TestFormCluster(t *testing.T) {
 	// Assert that all the created execs are executed.
 	assert.Equal(t, cIDs, startedExecs)
 }
+
+type swarmNodeListerMock func(context.Context, types.NodeListOptions) ([]swarm.Node, error)
+
+func (s swarmNodeListerMock) NodeList(ctx context.Context, opts types.NodeListOptions) ([]swarm.Node, error) {
+	return s(ctx, opts)
+}
+
+func TestSwarmNode(t *testing.T) {
+	ctx := context.Background()
+	client := swarmNodeListerMock(func(ctx context.Context, opts types.NodeListOptions) ([]swarm.Node, error) {
+		return []swarm.Node{
+			{ID: "a", Description: swarm.NodeDescription{Hostname: "sind-foo-manager-0"}},
+			{ID: "b", Description: swarm.NodeDescription{Hostname: "sind-foo-worker-0"}},
+		}, nil
+	})
+
+	node, err := SwarmNode(ctx, client, "sind-foo-worker-0")
+	require.NoError(t, err)
+	require.NotNil(t, node)
+	assert.Equal(t, "b", node.ID)
+
+	node, err = SwarmNode(ctx, client, "sind-foo-worker-1")
+	require.NoError(t, err)
+	assert.Nil(t, node)
+}
+
+type swarmNodeUpdaterMock struct {
+	nodeInspectWithRaw func(context.Context, string) (swarm.Node, []byte, error)
+	nodeUpdate         func(context.Context, string, swarm.Version, swarm.NodeSpec) error
+}
+
+func (s swarmNodeUpdaterMock) NodeInspectWithRaw(ctx context.Context, nodeID string) (swarm.Node, []byte, error) {
+	return s.nodeInspectWithRaw(ctx, nodeID)
+}
+
+func (s swarmNodeUpdaterMock) NodeUpdate(ctx context.Context, nodeID string, version swarm.Version, spec swarm.NodeSpec) error {
+	return s.nodeUpdate(ctx, nodeID, version, spec)
+}
+
+func TestUpdateSwarmNode(t *testing.T) {
+	ctx := context.Background()
+
+	var (
+		updatedVersion swarm.Version
+		updatedSpec    swarm.NodeSpec
+	)
+
+	client := swarmNodeUpdaterMock{
+		nodeInspectWithRaw: func(ctx context.Context, nodeID string) (swarm.Node, []byte, error) {
+			return swarm.Node{
+				ID:   nodeID,
+				Meta: swarm.Meta{Version: swarm.Version{Index: 42}},
+				Spec: swarm.NodeSpec{
+					Role:         swarm.NodeRoleManager,
+					Availability: swarm.NodeAvailabilityActive,
+				},
+			}, nil, nil
+		},
+		nodeUpdate: func(ctx context.Context, nodeID string, version swarm.Version, spec swarm.NodeSpec) error {
+			updatedVersion = version
+			updatedSpec = spec
+			return nil
+		},
+	}
+
+	err := UpdateSwarmNode(ctx, client, "a", func(spec *swarm.NodeSpec) {
+		spec.Availability = swarm.NodeAvailabilityDrain
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, swarm.Version{Index: 42}, updatedVersion)
+	assert.Equal(t, swarm.NodeSpec{Role: swarm.NodeRoleManager, Availability: swarm.NodeAvailabilityDrain}, updatedSpec)
+}
+
+func TestFormClusterReportsJoinFailures(t *testing.T) {
+	ctx := context.Background()
+	params := ClusterParams{
+		IDs: NodeIDs{
+			Primary: "a",
+			Workers: []string{"b", "c"},
+		},
+		PrimaryNodeIP:   "10.0.0.1",
+		WorkerJoinToken: "hh",
+	}
+
+	client := executorMock{
+		containerExecCreate: func(ctx context.Context, cID string, opts types.ExecConfig) (types.IDResponse, error) {
+			return types.IDResponse{ID: cID + "-" + opts.Cmd[1]}, nil
+		},
+		containerExecAttach: func(ctx context.Context, eID string, opts types.ExecStartCheck) (types.HijackedResponse, error) {
+			if eID == "c-swarm" {
+				return hijackedResponse("Error response from daemon: rpc error"), nil
+			}
+
+			return hijackedResponse(""), nil
+		},
+		containerExecInspect: func(ctx context.Context, eID string) (types.ContainerExecInspect, error) {
+			if eID == "c-swarm" {
+				return types.ContainerExecInspect{ExitCode: 1}, nil
+			}
+
+			return types.ContainerExecInspect{}, nil
+		},
+	}
+
+	err := FormCluster(ctx, &client, params)
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), `on node "c" exited with code 1`)
+	assert.Contains(t, err.Error(), "Error response from daemon: rpc error")
+	assert.NotContains(t, err.Error(), `node "b"`)
+}
+
+func TestWaitSwarmConverged(t *testing.T) {
+	readyNode := func(hostname string, role swarm.NodeRole) swarm.Node {
+		node := swarm.Node{
+			Spec:        swarm.NodeSpec{Role: role, Availability: swarm.NodeAvailabilityActive},
+			Description: swarm.NodeDescription{Hostname: hostname},
+			Status:      swarm.NodeStatus{State: swarm.NodeStateReady},
+		}
+
+		if role == swarm.NodeRoleManager {
+			node.ManagerStatus = &swarm.ManagerStatus{Reachability: swarm.ReachabilityReachable}
+		}
+
+		return node
+	}
+
+	expected := map[string]string{
+		"manager-0": NodeRoleManager,
+		"manager-1": NodeRoleManager,
+		"worker-0":  NodeRoleWorker,
+		"worker-1":  NodeRoleWorker,
+	}
+
+	testCases := []struct {
+		desc          string
+		nodes         []swarm.Node
+		expectedError string
+	}{
+		{
+			desc: "with a converged swarm",
+			nodes: []swarm.Node{
+				readyNode("manager-0", swarm.NodeRoleManager),
+				readyNode("manager-1", swarm.NodeRoleManager),
+				readyNode("worker-0", swarm.NodeRoleWorker),
+				readyNode("worker-1", swarm.NodeRoleWorker),
+			},
+		},
+		{
+			desc: "with pending nodes",
+			nodes: []swarm.Node{
+				readyNode("manager-0", swarm.NodeRoleManager),
+				{
+					Spec:          swarm.NodeSpec{Role: swarm.NodeRoleManager, Availability: swarm.NodeAvailabilityActive},
+					Description:   swarm.NodeDescription{Hostname: "manager-1"},
+					Status:        swarm.NodeStatus{State: swarm.NodeStateReady},
+					ManagerStatus: &swarm.ManagerStatus{Reachability: swarm.ReachabilityUnreachable},
+				},
+				{
+					Spec:        swarm.NodeSpec{Role: swarm.NodeRoleWorker, Availability: swarm.NodeAvailabilityActive},
+					Description: swarm.NodeDescription{Hostname: "worker-0"},
+					Status:      swarm.NodeStatus{State: swarm.NodeStateDown, Message: "heartbeat failure"},
+				},
+			},
+			expectedError: `swarm did not converge: context deadline exceeded: ` +
+				`manager "manager-1" is not reachable; ` +
+				`node "worker-0" is down: heartbeat failure; ` +
+				`node "worker-1" has not joined the swarm`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+
+			client := swarmNodeListerMock(func(ctx context.Context, opts types.NodeListOptions) ([]swarm.Node, error) {
+				return test.nodes, nil
+			})
+
+			err := WaitSwarmConverged(ctx, client, expected)
+			if test.expectedError != "" {
+				require.Error(t, err)
+				assert.Equal(t, test.expectedError, err.Error())
+				return
+			}
+
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestSwarmNodes(t *testing.T) {
+	ctx := context.Background()
+	client := swarmNodeListerMock(func(ctx context.Context, opts types.NodeListOptions) ([]swarm.Node, error) {
+		return []swarm.Node{
+			{ID: "a", Description: swarm.NodeDescription{Hostname: "sind-foo-manager-0"}, Status: swarm.NodeStatus{State: swarm.NodeStateReady}},
+			{ID: "b", Description: swarm.NodeDescription{Hostname: "sind-foo-worker-0"}, Status: swarm.NodeStatus{State: swarm.NodeStateReady}},
+			{ID: "c", Description: swarm.NodeDescription{Hostname: "sind-foo-worker-0"}, Status: swarm.NodeStatus{State: swarm.NodeStateDown}},
+			{ID: "d", Description: swarm.NodeDescription{Hostname: "sind-foo-worker-1"}, Status: swarm.NodeStatus{State: swarm.NodeStateDown}},
+		}, nil
+	})
+
+	nodes, err := SwarmNodes(ctx, client)
+	require.NoError(t, err)
+
+	ids := make(map[string]string, len(nodes))
+	for hostname, node := range nodes {
+		ids[hostname] = node.ID
+	}
+
+	assert.Equal(
+		t,
+		map[string]string{
+			"sind-foo-manager-0": "a",
+			"sind-foo-worker-0":  "b",
+			"sind-foo-worker-1":  "d",
+		},
+		ids,
+	)
+}
+
+func TestSwarmNodesFailsOnListError(t *testing.T) {
+	client := swarmNodeListerMock(func(ctx context.Context, opts types.NodeListOptions) ([]swarm.Node, error) {
+		return nil, errors.New("nope")
+	})
+
+	_, err := SwarmNodes(context.Background(), client)
+	assert.Error(t, err)
+}
+
+func TestClusterParamsJoinCommand(t *testing.T) {
+	params := ClusterParams{
+		PrimaryNodeIP:  "fd00:1::2",
+		ListenAddr:     SwarmIPv6ListenAddress(),
+		AdvertiseAddrs: map[string]string{"foo": "fd00:1::3"},
+	}
+
+	assert.Equal(
+		t,
+		[]string{"docker", "swarm", "join", "--token", "token", "--listen-addr", "[::]:2377", "--advertise-addr", "fd00:1::3", "[fd00:1::2]:2377"},
+		params.joinCommand("foo", "token"),
+	)
+	assert.Equal(
+		t,
+		[]string{"docker", "swarm", "join", "--token", "token", "--listen-addr", "[::]:2377", "[fd00:1::2]:2377"},
+		params.joinCommand("bar", "token"),
+	)
+}
+
+func TestLocalSwarmState(t *testing.T) {
+	client := executorMock{
+		containerExecCreate: func(ctx context.Context, cID string, opts types.ExecConfig) (types.IDResponse, error) {
+			assert.Equal(t, []string{"docker", "info", "--format", "{{.Swarm.LocalNodeState}}"}, opts.Cmd)
+			return types.IDResponse{ID: cID}, nil
+		},
+		containerExecAttach: func(ctx context.Context, eID string, opts types.ExecStartCheck) (types.HijackedResponse, error) {
+			return hijackedResponse("locked\n"), nil
+		},
+	}
+
+	state, err := LocalSwarmState(context.Background(), &client, "a")
+	require.NoError(t, err)
+	assert.Equal(t, swarm.LocalNodeStateLocked, state)
+}
+
+func TestUnlockSwarm(t *testing.T) {
+	var sentOpts types.ExecConfig
+
+	client := executorMock{
+		containerExecCreate: func(ctx context.Context, cID string, opts types.ExecConfig) (types.IDResponse, error) {
+			sentOpts = opts
+			return types.IDResponse{ID: cID}, nil
+		},
+		containerExecAttach: func(ctx context.Context, eID string, opts types.ExecStartCheck) (types.HijackedResponse, error) {
+			return hijackedResponse(""), nil
+		},
+	}
+
+	require.NoError(t, UnlockSwarm(context.Background(), &client, "a", "SWMKEY-1-foo"))
+	assert.Equal(t, []string{"SIND_UNLOCK_KEY=SWMKEY-1-foo"}, sentOpts.Env)
+	assert.NotContains(t, strings.Join(sentOpts.Cmd, " "), "SWMKEY-1-foo")
+}
+
+func TestWaitSwarmHealthy(t *testing.T) {
+	expected := map[string]string{
+		"manager-0": NodeRolePrimary,
+		"worker-0":  NodeRoleWorker,
+	}
+
+	manager := swarm.Node{
+		Spec:          swarm.NodeSpec{Role: swarm.NodeRoleManager, Availability: swarm.NodeAvailabilityActive},
+		Description:   swarm.NodeDescription{Hostname: "manager-0"},
+		Status:        swarm.NodeStatus{State: swarm.NodeStateReady},
+		ManagerStatus: &swarm.ManagerStatus{Reachability: swarm.ReachabilityReachable},
+	}
+
+	worker := swarm.Node{
+		Spec:        swarm.NodeSpec{Role: swarm.NodeRoleWorker, Availability: swarm.NodeAvailabilityDrain},
+		Description: swarm.NodeDescription{Hostname: "worker-0"},
+		Status:      swarm.NodeStatus{State: swarm.NodeStateReady},
+	}
+
+	leader := manager
+	leader.ManagerStatus = &swarm.ManagerStatus{Reachability: swarm.ReachabilityReachable, Leader: true}
+
+	testCases := []struct {
+		desc          string
+		nodes         []swarm.Node
+		expectedError string
+	}{
+		{
+			desc:  "with a healthy swarm and a drained node",
+			nodes: []swarm.Node{leader, worker},
+		},
+		{
+			desc:          "without leader",
+			nodes:         []swarm.Node{manager, worker},
+			expectedError: "swarm did not become healthy: context deadline exceeded: no leader is elected",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+
+			client := swarmNodeListerMock(func(ctx context.Context, opts types.NodeListOptions) ([]swarm.Node, error) {
+				return test.nodes, nil
+			})
+
+			err := WaitSwarmHealthy(ctx, client, expected)
+			if test.expectedError != "" {
+				require.Error(t, err)
+				assert.Equal(t, test.expectedError, err.Error())
+				return
+			}
+
+			require.NoError(t, err)
+		})
+	}
+}
+
+type taskListerMock func(context.Context, types.TaskListOptions) ([]swarm.Task, error)
+
+func (t taskListerMock) TaskList(ctx context.Context, opts types.TaskListOptions) ([]swarm.Task, error) {
+	return t(ctx, opts)
+}
+
+func TestWaitTasksStopped(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	var calls int
+
+	client := taskListerMock(func(ctx context.Context, opts types.TaskListOptions) ([]swarm.Task, error) {
+		assert.True(t, opts.Filters.ExactMatch("node", "a"))
+		assert.True(t, opts.Filters.ExactMatch("node", "b"))
+
+		calls++
+
+		state := swarm.TaskStateRunning
+		if calls > 1 {
+			state = swarm.TaskStateShutdown
+		}
+
+		return []swarm.Task{
+			{Status: swarm.TaskStatus{State: swarm.TaskStateComplete}},
+			{Status: swarm.TaskStatus{State: state}},
+		}, nil
+	})
+
+	require.NoError(t, WaitTasksStopped(ctx, client, []string{"a", "b"}))
+	assert.Equal(t, 2, calls)
+}
+
+func TestWaitTasksStoppedTimesOut(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	client := taskListerMock(func(ctx context.Context, opts types.TaskListOptions) ([]swarm.Task, error) {
+		return []swarm.Task{{Status: swarm.TaskStatus{State: swarm.TaskStatePreparing}}}, nil
+	})
+
+	err := WaitTasksStopped(ctx, client, []string{"a"})
+	require.Error(t, err)
+	assert.Equal(t, "1 task(s) are still running: context deadline exceeded", err.Error())
+}
@@ -15,6 +15,7 @@ func ListClusters(ctx context.Context, hostClient internal.ContainerLister) ([]C
 	}
 
 	result := make([]ClusterStatus, 0, len(primaryNodes))
+	listed := make(map[string]bool, len(primaryNodes))
 
 	for _, node := range primaryNodes {
 		clusterName, ok := node.Labels[internal.ClusterNameLabel]
@@ -22,6 +23,13 @@ func ListClusters(ctx context.Context, hostClient internal.ContainerLister) ([]C
 			return nil, fmt.Errorf("node %q has no cluster name", node.ID)
 		}
 
+		// A cluster electing a new primary node briefly has two of them.
+		if listed[clusterName] {
+			continue
+		}
+
+		listed[clusterName] = true
+
 		status, err := InspectCluster(ctx, hostClient, clusterName)
 		if err != nil {
 			return nil, err
@@ -102,10 +102,16 @@ func TestListClusters(t *testing.T) {
 					return test.primaryNodes, test.primaryNodesError
 				}
 
-				clusterNames := opts.Filters.Get("label")
-				require.Len(t, clusterNames, 1)
+				var clusterName string
 
-				return test.clusters[strings.TrimPrefix(clusterNames[0], internal.ClusterNameLabel+"=")], test.clusterListError
+				for _, label := range opts.Filters.Get("label") {
+					if strings.HasPrefix(label, internal.ClusterNameLabel+"=") {
+						require.Empty(t, clusterName)
+						clusterName = strings.TrimPrefix(label, internal.ClusterNameLabel+"=")
+					}
+				}
+
+				return test.clusters[clusterName], test.clusterListError
 			})
 
 			clusters, err := ListClusters(ctx, client)
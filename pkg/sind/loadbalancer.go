@@ -0,0 +1,95 @@
+package sind
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/docker/docker/api/types"
+	docker "github.com/docker/docker/client"
+	"github.com/jlevesy/sind/pkg/sind/internal"
+)
+
+// createLoadBalancer creates a container publishing given port bindings, and spreading the traffic across the managers of the cluster.
+func createLoadBalancer(ctx context.Context, hostClient *docker.Client, clusterName string, portBindings []string, networkID, networkName string) error {
+	nodes, err := internal.ListContainers(ctx, hostClient, clusterName)
+	if err != nil {
+		return fmt.Errorf("unable to list nodes: %v", err)
+	}
+
+	_, err = internal.CreateProxy(ctx, hostClient, internal.ProxyConfig{
+		ClusterName:  clusterName,
+		Name:         internal.ComponentLoadBalancer,
+		Component:    internal.ComponentLoadBalancer,
+		ImageRef:     DefaultProxyImageName,
+		NetworkID:    networkID,
+		NetworkName:  networkName,
+		PortBindings: portBindings,
+		Upstreams:    managerAddresses(nodes, networkName),
+	})
+
+	return err
+}
+
+// managerAddresses returns the sorted addresses of the managers on given network.
+func managerAddresses(nodes []types.Container, networkName string) []string {
+	var addresses []string
+
+	for _, node := range nodes {
+		if node.Labels[internal.NodeRoleLabel] == internal.NodeRoleWorker || node.NetworkSettings == nil {
+			continue
+		}
+
+		endpoint, ok := node.NetworkSettings.Networks[networkName]
+		if !ok || endpoint == nil {
+			continue
+		}
+
+		// Stopped nodes have no address, but keep the one they were given on creation.
+		address := endpoint.IPAddress
+		if endpoint.IPAMConfig != nil && endpoint.IPAMConfig.IPv4Address != "" {
+			address = endpoint.IPAMConfig.IPv4Address
+		}
+
+		if address == "" {
+			continue
+		}
+
+		addresses = append(addresses, address)
+	}
+
+	sort.Strings(addresses)
+
+	return addresses
+}
+
+// refreshProxies makes the load balancer and the port proxies of a cluster forward the traffic to its current managers.
+// It has to be called whenever the managers of the cluster change.
+func refreshProxies(ctx context.Context, hostClient *docker.Client, clusterName string) error {
+	nodes, err := listClusterNodes(ctx, hostClient, clusterName)
+	if err != nil {
+		return err
+	}
+
+	networkName, _, err := clusterNetwork(*nodes.primary)
+	if err != nil {
+		return err
+	}
+
+	upstreams := managerAddresses(nodes.all(), networkName)
+
+	for _, component := range []string{internal.ComponentLoadBalancer, internal.ComponentPort} {
+		proxies, err := internal.ListComponentContainers(ctx, hostClient, clusterName, component)
+		if err != nil {
+			return fmt.Errorf("unable to list proxies: %v", err)
+		}
+
+		for _, proxy := range proxies {
+			if _, err = internal.UpdateProxyUpstreams(ctx, hostClient, proxy.ID, upstreams); err != nil {
+				return fmt.Errorf("unable to update proxy %q: %v", internal.ContainerNodeName(clusterName, proxy), err)
+			}
+		}
+	}
+
+	return nil
+}
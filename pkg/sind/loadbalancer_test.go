@@ -0,0 +1,35 @@
+package sind
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/jlevesy/sind/pkg/sind/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManagerAddresses(t *testing.T) {
+	endpoint := func(ip string) *types.SummaryNetworkSettings {
+		return &types.SummaryNetworkSettings{
+			Networks: map[string]*network.EndpointSettings{"bar": {IPAddress: ip}},
+		}
+	}
+
+	nodes := []types.Container{
+		{Labels: map[string]string{internal.NodeRoleLabel: internal.NodeRoleManager}, NetworkSettings: endpoint("10.0.0.4")},
+		{Labels: map[string]string{internal.NodeRoleLabel: internal.NodeRolePrimary}, NetworkSettings: endpoint("10.0.0.2")},
+		{Labels: map[string]string{internal.NodeRoleLabel: internal.NodeRoleWorker}, NetworkSettings: endpoint("10.0.0.3")},
+		{Labels: map[string]string{internal.NodeRoleLabel: internal.NodeRoleManager}},
+		// A stopped manager keeps its configured address.
+		{
+			Labels: map[string]string{internal.NodeRoleLabel: internal.NodeRoleManager},
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{"bar": {IPAMConfig: &network.EndpointIPAMConfig{IPv4Address: "10.0.0.5"}}},
+			},
+		},
+		{Labels: map[string]string{internal.NodeRoleLabel: internal.NodeRoleManager}, NetworkSettings: endpoint("")},
+	}
+
+	assert.Equal(t, []string{"10.0.0.2", "10.0.0.4", "10.0.0.5"}, managerAddresses(nodes, "bar"))
+}
@@ -0,0 +1,641 @@
+package sind
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/swarm"
+	docker "github.com/docker/docker/client"
+	"github.com/golang/sync/errgroup"
+	"github.com/jlevesy/sind/pkg/sind/internal"
+)
+
+// clusterNodes represents the node containers of a cluster, sorted by index.
+type clusterNodes struct {
+	primary  *types.Container
+	managers []types.Container
+	workers  []types.Container
+}
+
+func listClusterNodes(ctx context.Context, hostClient internal.ContainerLister, clusterName string) (*clusterNodes, error) {
+	containers, err := internal.ListContainers(ctx, hostClient, clusterName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list nodes: %v", err)
+	}
+
+	var result clusterNodes
+
+	for i, container := range containers {
+		switch container.Labels[internal.NodeRoleLabel] {
+		case internal.NodeRolePrimary:
+			// A new primary node can be elected before the former one is relabeled, the running one prevails.
+			if result.primary == nil || (result.primary.State != "running" && container.State == "running") {
+				if result.primary != nil {
+					result.managers = append(result.managers, *result.primary)
+				}
+
+				result.primary = &containers[i]
+				continue
+			}
+
+			result.managers = append(result.managers, container)
+		case internal.NodeRoleManager:
+			result.managers = append(result.managers, container)
+		case internal.NodeRoleWorker:
+			result.workers = append(result.workers, container)
+		}
+	}
+
+	if result.primary == nil {
+		return nil, fmt.Errorf("primary node for cluster %q not found", clusterName)
+	}
+
+	sortNodes(clusterName, internal.NodeRoleManager, result.managers)
+	sortNodes(clusterName, internal.NodeRoleWorker, result.workers)
+
+	return &result, nil
+}
+
+func sortNodes(clusterName, role string, nodes []types.Container) {
+	sort.Slice(nodes, func(i, j int) bool {
+		a, _ := internal.NodeIndex(role, internal.ContainerNodeName(clusterName, nodes[i]))
+		b, _ := internal.NodeIndex(role, internal.ContainerNodeName(clusterName, nodes[j]))
+
+		return a < b
+	})
+}
+
+// all returns all the node containers, primary first.
+func (c *clusterNodes) all() []types.Container {
+	nodes := append([]types.Container{*c.primary}, c.managers...)
+
+	return append(nodes, c.workers...)
+}
+
+// find returns the container of the node of given name.
+func (c *clusterNodes) find(clusterName, nodeName string) (types.Container, bool) {
+	for _, node := range c.all() {
+		if internal.ContainerNodeName(clusterName, node) == nodeName {
+			return node, true
+		}
+	}
+
+	return types.Container{}, false
+}
+
+// nextIndex returns the next free node index for given role.
+// Nodes of all roles are accounted, as a promoted or demoted node keeps its name.
+func (c *clusterNodes) nextIndex(clusterName, role string) uint16 {
+	var next uint16
+
+	for _, node := range c.all() {
+		index, ok := internal.NodeIndex(role, internal.ContainerNodeName(clusterName, node))
+		if ok && index >= next {
+			next = index + 1
+		}
+	}
+
+	return next
+}
+
+// newNode describes a node to add to a running cluster.
+type newNode struct {
+	name string
+	role string
+	cfg  NodeConfiguration
+}
+
+// AddNode adds a node of given role to a running cluster, and returns its name.
+// The node uses the image of the primary node and the daemon args recorded for the cluster, unless they are set in the given configuration.
+func AddNode(ctx context.Context, hostClient *docker.Client, clusterName, role string, cfg NodeConfiguration) (string, error) {
+	if role != NodeRoleManager && role != NodeRoleWorker {
+		return "", fmt.Errorf("invalid node role %q", role)
+	}
+
+	nodes, err := listClusterNodes(ctx, hostClient, clusterName)
+	if err != nil {
+		return "", err
+	}
+
+	swarmClient, err := newSwarmClient(ctx, hostClient, clusterName)
+	if err != nil {
+		return "", err
+	}
+
+	node := newNode{
+		name: internal.NodeName(role, nodes.nextIndex(clusterName, role)),
+		role: role,
+		cfg:  cfg,
+	}
+
+	if err = addNodes(ctx, hostClient, swarmClient, clusterName, *nodes.primary, []newNode{node}); err != nil {
+		return "", fmt.Errorf("unable to add node: %v", err)
+	}
+
+	if role == NodeRoleManager {
+		if err = refreshProxies(ctx, hostClient, clusterName); err != nil {
+			return "", err
+		}
+	}
+
+	return node.name, nil
+}
+
+// RemoveNode removes a node from a running cluster. The primary node can't be removed.
+func RemoveNode(ctx context.Context, hostClient *docker.Client, clusterName, nodeName string) error {
+	nodes, err := listClusterNodes(ctx, hostClient, clusterName)
+	if err != nil {
+		return err
+	}
+
+	if internal.ContainerNodeName(clusterName, *nodes.primary) == nodeName {
+		return fmt.Errorf("node %q is the primary node of the cluster and can't be removed", nodeName)
+	}
+
+	container, ok := nodes.find(clusterName, nodeName)
+	if !ok {
+		return fmt.Errorf("node %q not found in cluster %q", nodeName, clusterName)
+	}
+
+	swarmClient, err := newSwarmClient(ctx, hostClient, clusterName)
+	if err != nil {
+		return err
+	}
+
+	if err = removeNode(ctx, hostClient, swarmClient, clusterName, container); err != nil {
+		return err
+	}
+
+	if container.Labels[internal.NodeRoleLabel] != internal.NodeRoleManager {
+		return nil
+	}
+
+	return refreshProxies(ctx, hostClient, clusterName)
+}
+
+// PromoteNode promotes a worker node of a running cluster to manager.
+// As the role label of a container can't be updated, the node container is recreated, keeping its volumes and swarm state.
+func PromoteNode(ctx context.Context, hostClient *docker.Client, clusterName, nodeName string) error {
+	return setNodeRole(ctx, hostClient, clusterName, nodeName, internal.NodeRoleManager)
+}
+
+// DemoteNode demotes a manager node of a running cluster to worker. The primary node can't be demoted.
+// As the role label of a container can't be updated, the node container is recreated, keeping its volumes and swarm state.
+func DemoteNode(ctx context.Context, hostClient *docker.Client, clusterName, nodeName string) error {
+	return setNodeRole(ctx, hostClient, clusterName, nodeName, internal.NodeRoleWorker)
+}
+
+// SetNodeAvailability sets the swarm availability of a node of a running cluster: active, pause or drain.
+func SetNodeAvailability(ctx context.Context, hostClient *docker.Client, clusterName, nodeName string, availability swarm.NodeAvailability) error {
+	switch availability {
+	case swarm.NodeAvailabilityActive, swarm.NodeAvailabilityPause, swarm.NodeAvailabilityDrain:
+	default:
+		return fmt.Errorf("invalid node availability %q", availability)
+	}
+
+	nodes, err := listClusterNodes(ctx, hostClient, clusterName)
+	if err != nil {
+		return err
+	}
+
+	container, ok := nodes.find(clusterName, nodeName)
+	if !ok {
+		return fmt.Errorf("node %q not found in cluster %q", nodeName, clusterName)
+	}
+
+	swarmClient, err := newSwarmClient(ctx, hostClient, clusterName)
+	if err != nil {
+		return err
+	}
+
+	hostname := internal.ContainerName(clusterName, internal.ContainerNodeName(clusterName, container))
+
+	node, err := internal.SwarmNode(ctx, swarmClient, hostname)
+	if err != nil {
+		return err
+	}
+
+	if node == nil {
+		return fmt.Errorf("node %q is not a member of the swarm", nodeName)
+	}
+
+	err = internal.UpdateSwarmNode(ctx, swarmClient, node.ID, func(spec *swarm.NodeSpec) {
+		spec.Availability = availability
+	})
+	if err != nil {
+		return fmt.Errorf("unable to set node %q availability: %v", nodeName, err)
+	}
+
+	return nil
+}
+
+// setNodeRole changes the swarm role of a node, and recreates its container with the matching role label and daemon hosts.
+// The container is recreated while the node is a worker, before a promotion or after a demotion, so that restarting it
+// never makes the swarm lose its quorum, nor requires to unlock it.
+func setNodeRole(ctx context.Context, hostClient *docker.Client, clusterName, nodeName, role string) error {
+	nodes, err := listClusterNodes(ctx, hostClient, clusterName)
+	if err != nil {
+		return err
+	}
+
+	if internal.ContainerNodeName(clusterName, *nodes.primary) == nodeName {
+		return fmt.Errorf("node %q is the primary node of the cluster and its role can't be changed", nodeName)
+	}
+
+	container, ok := nodes.find(clusterName, nodeName)
+	if !ok {
+		return fmt.Errorf("node %q not found in cluster %q", nodeName, clusterName)
+	}
+
+	if container.Labels[internal.NodeRoleLabel] == role {
+		return fmt.Errorf("node %q is already a %s", nodeName, role)
+	}
+
+	swarmClient, err := newSwarmClient(ctx, hostClient, clusterName)
+	if err != nil {
+		return err
+	}
+
+	hostname := internal.ContainerName(clusterName, nodeName)
+
+	node, err := internal.SwarmNode(ctx, swarmClient, hostname)
+	if err != nil {
+		return err
+	}
+
+	if node == nil {
+		return fmt.Errorf("node %q is not a member of the swarm", nodeName)
+	}
+
+	if role == internal.NodeRoleManager {
+		if err = recreateWorker(ctx, hostClient, swarmClient, container.ID, hostname, role); err != nil {
+			return err
+		}
+	}
+
+	err = internal.UpdateSwarmNode(ctx, swarmClient, node.ID, func(spec *swarm.NodeSpec) {
+		spec.Role = swarm.NodeRole(role)
+	})
+	if err != nil {
+		if role == internal.NodeRoleManager {
+			if restoreErr := recreateWorker(ctx, hostClient, swarmClient, hostname, hostname, internal.NodeRoleWorker); restoreErr != nil {
+				return fmt.Errorf("unable to change node %q role: %v, and unable to restore it: %v", nodeName, err, restoreErr)
+			}
+		}
+
+		return fmt.Errorf("unable to change node %q role: %v", nodeName, err)
+	}
+
+	if err = internal.WaitSwarmHealthy(ctx, swarmClient, map[string]string{hostname: role}); err != nil {
+		return err
+	}
+
+	if role == internal.NodeRoleWorker {
+		if err = recreateWorker(ctx, hostClient, swarmClient, container.ID, hostname, role); err != nil {
+			return err
+		}
+	}
+
+	return refreshProxies(ctx, hostClient, clusterName)
+}
+
+// recreateWorker recreates the container of a worker node with the configuration of given role,
+// then waits until the node is back in the swarm.
+func recreateWorker(ctx context.Context, hostClient, swarmClient *docker.Client, cID, hostname, role string) error {
+	if _, err := internal.RecreateContainer(ctx, hostClient, cID, withRole(role)); err != nil {
+		return fmt.Errorf("unable to recreate node %q: %v", hostname, err)
+	}
+
+	return internal.WaitSwarmHealthy(ctx, swarmClient, map[string]string{hostname: internal.NodeRoleWorker})
+}
+
+// clusterNetwork returns the name of the cluster network, and the endpoint of given node on it.
+func clusterNetwork(node types.Container) (string, *network.EndpointSettings, error) {
+	if node.NetworkSettings == nil || len(node.NetworkSettings.Networks) != 1 {
+		return "", nil, errors.New("node must be member of exactly one network")
+	}
+
+	for name, settings := range node.NetworkSettings.Networks {
+		return name, settings, nil
+	}
+
+	return "", nil, nil
+}
+
+// addNodes creates the given nodes, then joins them to the swarm of the cluster.
+// The nodes created so far are removed if one of them fails to be created or to join the swarm.
+func addNodes(ctx context.Context, hostClient, swarmClient *docker.Client, clusterName string, primary types.Container, nodes []newNode) (err error) {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		if rollbackErr := rollbackNodes(hostClient, swarmClient, clusterName, nodes); rollbackErr != nil {
+			err = fmt.Errorf("%v, and unable to remove the new nodes: %v", err, rollbackErr)
+		}
+	}()
+
+	primaryInfo, err := hostClient.ContainerInspect(ctx, primary.ID)
+	if err != nil {
+		return fmt.Errorf("unable to inspect the primary node: %v", err)
+	}
+
+	networkName, primaryEndpoint, err := clusterNetwork(primary)
+	if err != nil {
+		return err
+	}
+
+	subnet, usedIPs, err := internal.NetworkAddresses(ctx, hostClient, primaryEndpoint.NetworkID)
+	if err != nil {
+		return fmt.Errorf("unable to collect cluster network addresses: %v", err)
+	}
+
+	ips, err := internal.FreeIPs(*subnet, usedIPs, len(nodes))
+	if err != nil {
+		return fmt.Errorf("unable to allocate node addresses: %v", err)
+	}
+
+	ipv6Subnet, err := internal.NetworkIPv6Subnet(ctx, hostClient, primaryEndpoint.NetworkID)
+	if err != nil {
+		return fmt.Errorf("unable to collect cluster network addresses: %v", err)
+	}
+
+	baseArgs, err := clusterDaemonArgs(clusterName, primaryInfo.Config)
+	if err != nil {
+		return fmt.Errorf("unable to read the daemon args of the cluster: %v", err)
+	}
+
+	var (
+		ids     internal.NodeIDs
+		created = make(chan newNodeID, len(nodes))
+	)
+
+	errg, groupCtx := errgroup.WithContext(ctx)
+
+	for i, node := range nodes {
+		nodeCfg := internal.NodeConfig{
+			ClusterName: clusterName,
+			Name:        node.name,
+			Role:        node.role,
+			ImageRef:    primaryInfo.Config.Image,
+			NetworkID:   primaryEndpoint.NetworkID,
+			NetworkName: networkName,
+			IPAddress:   ips[i],
+			DaemonArgs:  append([]string{}, baseArgs...),
+
+			ClusterDaemonArgs: baseArgs,
+		}
+
+		if ipv6Subnet != nil {
+			if nodeCfg.IPv6Address, err = internal.IPv6Address(*subnet, *ipv6Subnet, ips[i]); err != nil {
+				return fmt.Errorf("unable to allocate an IPv6 address to node %q: %v", node.name, err)
+			}
+		}
+
+		if node.cfg.ImageName != "" {
+			nodeCfg.ImageRef = node.cfg.ImageName
+		}
+
+		if args := node.cfg.daemonArgs(nodeCfg.DaemonArgs); args != nil {
+			nodeCfg.DaemonArgs = args
+		}
+
+		errg.Go(func() error {
+			cID, err := internal.CreateNode(groupCtx, hostClient, nodeCfg)
+			if err != nil {
+				return fmt.Errorf("unable to create node %q: %v", nodeCfg.Name, err)
+			}
+
+			created <- newNodeID{id: cID, role: nodeCfg.Role}
+
+			return nil
+		})
+	}
+
+	if err = errg.Wait(); err != nil {
+		return err
+	}
+
+	close(created)
+
+	for node := range created {
+		if node.role == internal.NodeRoleManager {
+			ids.Managers = append(ids.Managers, node.id)
+			continue
+		}
+
+		ids.Workers = append(ids.Workers, node.id)
+	}
+
+	if err = joinSwarm(ctx, hostClient, swarmClient, clusterName, ids); err != nil {
+		return fmt.Errorf("unable to join new nodes to the swarm: %v", err)
+	}
+
+	expected := make(map[string]string, len(nodes))
+	for _, node := range nodes {
+		expected[internal.ContainerName(clusterName, node.name)] = node.role
+	}
+
+	if err = internal.WaitSwarmConverged(ctx, swarmClient, expected); err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		if err = labelSwarmNode(ctx, swarmClient, internal.ContainerName(clusterName, node.name), node.cfg.Labels); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rollbackNodes removes the containers of given nodes, after making them leave the swarm if they joined it.
+// It does not rely on the context of the addition, which might be canceled already.
+func rollbackNodes(hostClient, swarmClient *docker.Client, clusterName string, nodes []newNode) error {
+	ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+
+	containers, err := internal.ListClusterContainers(ctx, hostClient, clusterName)
+	if err != nil {
+		return fmt.Errorf("unable to list nodes: %v", err)
+	}
+
+	names := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		names[node.name] = true
+	}
+
+	var (
+		created  []types.Container
+		leaveErr error
+	)
+
+	for _, container := range containers {
+		if !names[internal.ContainerNodeName(clusterName, container)] {
+			continue
+		}
+
+		created = append(created, container)
+
+		// The container is removed even if its node can't leave the swarm.
+		hostname := internal.ContainerName(clusterName, internal.ContainerNodeName(clusterName, container))
+		if _, err = leaveSwarm(ctx, hostClient, swarmClient, hostname, container.ID); err != nil && leaveErr == nil {
+			leaveErr = err
+		}
+	}
+
+	if err = internal.RemoveContainers(ctx, hostClient, created); err != nil {
+		return fmt.Errorf("unable to delete nodes: %v", err)
+	}
+
+	return leaveErr
+}
+
+// joinSwarm makes the nodes running in given containers join the swarm of a cluster through its primary node,
+// or through a reachable manager if the primary node is not running.
+func joinSwarm(ctx context.Context, hostClient, swarmClient *docker.Client, clusterName string, ids internal.NodeIDs) error {
+	manager, _, err := clusterEntrypoint(ctx, hostClient, clusterName)
+	if err != nil {
+		return err
+	}
+
+	networkName, managerEndpoint, err := clusterNetwork(manager)
+	if err != nil {
+		return err
+	}
+
+	swarmInfo, err := swarmClient.SwarmInspect(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to collect swarm cluster informations: %v", err)
+	}
+
+	clusterParams := internal.ClusterParams{
+		IDs: ids,
+
+		PrimaryNodeIP:    managerEndpoint.IPAddress,
+		ManagerJoinToken: swarmInfo.JoinTokens.Manager,
+		WorkerJoinToken:  swarmInfo.JoinTokens.Worker,
+	}
+
+	// The swarm of a dual stack cluster is formed over IPv6.
+	if managerEndpoint.GlobalIPv6Address != "" {
+		clusterParams.PrimaryNodeIP = managerEndpoint.GlobalIPv6Address
+		clusterParams.ListenAddr = internal.SwarmIPv6ListenAddress()
+
+		if clusterParams.AdvertiseAddrs, err = ipv6Addresses(ctx, hostClient, clusterName, networkName); err != nil {
+			return err
+		}
+	}
+
+	return internal.FormCluster(ctx, hostClient, clusterParams)
+}
+
+// labelSwarmNode waits for the node of given hostname to join the swarm, then adds the given labels to it.
+func labelSwarmNode(ctx context.Context, swarmClient *docker.Client, hostname string, labels map[string]string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	node, err := internal.WaitSwarmNode(ctx, swarmClient, hostname)
+	if err != nil {
+		return fmt.Errorf("node %q did not join the swarm: %v", hostname, err)
+	}
+
+	err = internal.UpdateSwarmNode(ctx, swarmClient, node.ID, func(spec *swarm.NodeSpec) {
+		if spec.Labels == nil {
+			spec.Labels = make(map[string]string, len(labels))
+		}
+
+		for key, value := range labels {
+			spec.Labels[key] = value
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("unable to label node %q: %v", hostname, err)
+	}
+
+	return nil
+}
+
+type newNodeID struct {
+	id   string
+	role string
+}
+
+// clusterDaemonArgs returns the daemon args of the cluster, without node overrides, given the config of the primary node.
+// The daemon hosts are not part of them, they are set depending on the node role.
+func clusterDaemonArgs(clusterName string, primary *container.Config) ([]string, error) {
+	args, ok, err := internal.ClusterDaemonArgs(primary.Labels)
+	if err != nil || ok {
+		return args, err
+	}
+
+	// The primary node doesn't record them, they are guessed from its command.
+	// Its engine labels are dropped, as they can't be told apart from its own overrides.
+	for _, arg := range primary.Cmd {
+		if strings.HasPrefix(arg, "-H ") || strings.HasPrefix(arg, "--label=") {
+			continue
+		}
+
+		args = append(args, arg)
+	}
+
+	return args, nil
+}
+
+// removeNode removes a node from the swarm of the cluster, then deletes its container.
+func removeNode(ctx context.Context, hostClient, swarmClient *docker.Client, clusterName string, container types.Container) error {
+	hostname := internal.ContainerName(clusterName, internal.ContainerNodeName(clusterName, container))
+
+	if _, err := leaveSwarm(ctx, hostClient, swarmClient, hostname, container.ID); err != nil {
+		return err
+	}
+
+	if err := internal.RemoveContainers(ctx, hostClient, []types.Container{container}); err != nil {
+		return fmt.Errorf("unable to delete node %q: %v", hostname, err)
+	}
+
+	return nil
+}
+
+// leaveSwarm demotes and drains the node of given hostname, waits for its tasks to stop,
+// then makes it leave the swarm and removes it from the swarm nodes.
+// It returns the swarm node as it was before leaving, or nil if the node was not a member of the swarm.
+func leaveSwarm(ctx context.Context, hostClient, swarmClient *docker.Client, hostname, cID string) (*swarm.Node, error) {
+	node, err := internal.SwarmNode(ctx, swarmClient, hostname)
+	if err != nil || node == nil {
+		return nil, err
+	}
+
+	err = internal.UpdateSwarmNode(ctx, swarmClient, node.ID, func(spec *swarm.NodeSpec) {
+		spec.Role = swarm.NodeRoleWorker
+		spec.Availability = swarm.NodeAvailabilityDrain
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to drain node %q: %v", hostname, err)
+	}
+
+	if err = internal.WaitTasksStopped(ctx, swarmClient, []string{node.ID}); err != nil {
+		return nil, fmt.Errorf("unable to wait for the tasks of node %q to stop: %v", hostname, err)
+	}
+
+	if err = internal.LeaveSwarm(ctx, hostClient, cID); err != nil {
+		return nil, fmt.Errorf("unable to make node %q leave the swarm: %v", hostname, err)
+	}
+
+	if err = swarmClient.NodeRemove(ctx, node.ID, types.NodeRemoveOptions{Force: true}); err != nil {
+		return nil, fmt.Errorf("unable to remove node %q from the swarm: %v", hostname, err)
+	}
+
+	return node, nil
+}
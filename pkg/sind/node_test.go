@@ -0,0 +1,154 @@
+package sind
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/jlevesy/sind/pkg/sind/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeConfigurationDaemonArgs(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		cfg          NodeConfiguration
+		defaultArgs  []string
+		expectedArgs []string
+	}{
+		{
+			desc:        "without override",
+			defaultArgs: []string{"--debug"},
+		},
+		{
+			desc:         "with daemon args",
+			cfg:          NodeConfiguration{DaemonArgs: []string{"--experimental"}},
+			defaultArgs:  []string{"--debug"},
+			expectedArgs: []string{"--experimental"},
+		},
+		{
+			desc:         "with engine labels",
+			cfg:          NodeConfiguration{EngineLabels: map[string]string{"zone": "a", "disk": "ssd"}},
+			defaultArgs:  []string{"--debug"},
+			expectedArgs: []string{"--debug", "--label=disk=ssd", "--label=zone=a"},
+		},
+		{
+			desc: "with daemon args and engine labels",
+			cfg: NodeConfiguration{
+				DaemonArgs:   []string{"--experimental"},
+				EngineLabels: map[string]string{"zone": "a"},
+			},
+			defaultArgs:  []string{"--debug"},
+			expectedArgs: []string{"--experimental", "--label=zone=a"},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			assert.Equal(t, test.expectedArgs, test.cfg.daemonArgs(test.defaultArgs))
+		})
+	}
+}
+
+func TestClusterDaemonArgs(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		primary      container.Config
+		expectedArgs []string
+	}{
+		{
+			desc: "with recorded args",
+			primary: container.Config{
+				Cmd:    []string{"-H unix:///var/run/docker.sock", "--debug", "--label=zone=a"},
+				Labels: map[string]string{internal.DaemonArgsLabel: `["--debug"]`},
+			},
+			expectedArgs: []string{"--debug"},
+		},
+		{
+			desc: "without recorded args",
+			primary: container.Config{
+				Cmd: []string{
+					"-H unix:///var/run/docker.sock",
+					"-H tcp://0.0.0.0:2375",
+					"--debug",
+					"--label=zone=a",
+				},
+			},
+			expectedArgs: []string{"--debug"},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			args, err := clusterDaemonArgs("test", &test.primary)
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedArgs, args)
+		})
+	}
+}
+
+func TestListClusterNodesPrefersTheRunningPrimaryNode(t *testing.T) {
+	node := func(name, role, state string) types.Container {
+		return types.Container{
+			ID:     name,
+			Names:  []string{"/" + internal.ContainerName("test", name)},
+			Labels: map[string]string{internal.NodeRoleLabel: role},
+			State:  state,
+		}
+	}
+
+	client := internal.ContainerListerMock(func(ctx context.Context, opts types.ContainerListOptions) ([]types.Container, error) {
+		return []types.Container{
+			node("manager-1", internal.NodeRolePrimary, "running"),
+			node("manager-0", internal.NodeRolePrimary, "exited"),
+			node("manager-2", internal.NodeRoleManager, "running"),
+		}, nil
+	})
+
+	nodes, err := listClusterNodes(context.Background(), client, "test")
+	require.NoError(t, err)
+
+	assert.Equal(t, "manager-1", nodes.primary.ID)
+	require.Len(t, nodes.managers, 2)
+	assert.Equal(t, "manager-0", nodes.managers[0].ID)
+	assert.Equal(t, "manager-2", nodes.managers[1].ID)
+}
+
+func TestClusterNodesNextIndex(t *testing.T) {
+	node := func(name, role string) types.Container {
+		return types.Container{
+			Names:  []string{"/" + internal.ContainerName("test", name)},
+			Labels: map[string]string{internal.NodeRoleLabel: role},
+		}
+	}
+
+	primary := node("manager-0", internal.NodeRolePrimary)
+	nodes := clusterNodes{
+		primary: &primary,
+		// worker-1 has been promoted, and keeps its name.
+		managers: []types.Container{node("manager-1", internal.NodeRoleManager), node("worker-1", internal.NodeRoleManager)},
+		workers:  []types.Container{node("worker-0", internal.NodeRoleWorker)},
+	}
+
+	assert.EqualValues(t, 2, nodes.nextIndex("test", internal.NodeRoleManager))
+	assert.EqualValues(t, 2, nodes.nextIndex("test", internal.NodeRoleWorker))
+
+	found, ok := nodes.find("test", "worker-1")
+	assert.True(t, ok)
+	assert.Equal(t, internal.NodeRoleManager, found.Labels[internal.NodeRoleLabel])
+
+	_, ok = nodes.find("test", "worker-2")
+	assert.False(t, ok)
+}
+
+func TestWithRole(t *testing.T) {
+	labels := map[string]string{internal.ClusterNameLabel: "test", internal.NodeRoleLabel: internal.NodeRoleWorker}
+	cfg := container.Config{Labels: labels}
+
+	withRole(internal.NodeRoleManager)(&cfg, &container.HostConfig{})
+
+	assert.Equal(t, map[string]string{internal.ClusterNameLabel: "test", internal.NodeRoleLabel: internal.NodeRoleManager}, cfg.Labels)
+	assert.Equal(t, internal.NodeRoleWorker, labels[internal.NodeRoleLabel])
+}
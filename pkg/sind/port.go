@@ -0,0 +1,147 @@
+package sind
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/docker/docker/api/types"
+	docker "github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+	"github.com/jlevesy/sind/pkg/sind/internal"
+)
+
+// PortProxy is a port published on the host for a running cluster, by a proxy container forwarding the traffic to the managers.
+type PortProxy struct {
+	ContainerID string
+	Binding     string
+	State       string
+}
+
+// AddPort publishes a port binding, using the same format as the create port bindings, on a running cluster.
+// Docker does not allow to publish ports of an existing container, so a proxy container is created on the cluster network.
+// Like the load balancer, it follows the managers of the cluster as nodes are added, removed, promoted or demoted.
+func AddPort(ctx context.Context, hostClient *docker.Client, clusterName, binding string) error {
+	name, err := portProxyName(binding)
+	if err != nil {
+		return err
+	}
+
+	proxies, err := ListPorts(ctx, hostClient, clusterName)
+	if err != nil {
+		return err
+	}
+
+	for _, proxy := range proxies {
+		if proxyName, _ := portProxyName(proxy.Binding); proxyName == name {
+			return fmt.Errorf("port %q is already published by %q", binding, proxy.Binding)
+		}
+	}
+
+	nodes, err := listClusterNodes(ctx, hostClient, clusterName)
+	if err != nil {
+		return err
+	}
+
+	networkName, primaryEndpoint, err := clusterNetwork(*nodes.primary)
+	if err != nil {
+		return err
+	}
+
+	imageExists, err := internal.ImageExists(ctx, hostClient, DefaultProxyImageName)
+	if err != nil {
+		return fmt.Errorf("unable to check proxy image existence: %v", err)
+	}
+
+	if !imageExists {
+		if err = internal.PullImage(ctx, hostClient, DefaultProxyImageName); err != nil {
+			return fmt.Errorf("unable to pull the %s image: %v", DefaultProxyImageName, err)
+		}
+	}
+
+	_, err = internal.CreateProxy(ctx, hostClient, internal.ProxyConfig{
+		ClusterName:  clusterName,
+		Name:         name,
+		Component:    internal.ComponentPort,
+		ImageRef:     DefaultProxyImageName,
+		Labels:       map[string]string{internal.PortLabel: binding},
+		NetworkID:    primaryEndpoint.NetworkID,
+		NetworkName:  networkName,
+		PortBindings: []string{binding},
+		Upstreams:    managerAddresses(append([]types.Container{*nodes.primary}, nodes.managers...), networkName),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create the port proxy: %v", err)
+	}
+
+	return nil
+}
+
+// ListPorts returns the ports published on a running cluster using AddPort, sorted by binding.
+func ListPorts(ctx context.Context, hostClient internal.ContainerLister, clusterName string) ([]PortProxy, error) {
+	containers, err := internal.ListComponentContainers(ctx, hostClient, clusterName, internal.ComponentPort)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list port proxies: %v", err)
+	}
+
+	proxies := make([]PortProxy, 0, len(containers))
+
+	for _, container := range containers {
+		proxies = append(proxies, PortProxy{
+			ContainerID: container.ID,
+			Binding:     container.Labels[internal.PortLabel],
+			State:       container.State,
+		})
+	}
+
+	sort.Slice(proxies, func(i, j int) bool {
+		return proxies[i].Binding < proxies[j].Binding
+	})
+
+	return proxies, nil
+}
+
+// RemovePort removes the proxy publishing given port binding on a cluster.
+func RemovePort(ctx context.Context, hostClient *docker.Client, clusterName, binding string) error {
+	name, err := portProxyName(binding)
+	if err != nil {
+		return err
+	}
+
+	containers, err := internal.ListComponentContainers(ctx, hostClient, clusterName, internal.ComponentPort)
+	if err != nil {
+		return fmt.Errorf("unable to list port proxies: %v", err)
+	}
+
+	for _, container := range containers {
+		if internal.ContainerNodeName(clusterName, container) != name {
+			continue
+		}
+
+		if err = internal.RemoveContainers(ctx, hostClient, []types.Container{container}); err != nil {
+			return fmt.Errorf("unable to remove the port proxy: %v", err)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("port %q is not published on cluster %q", binding, clusterName)
+}
+
+// portProxyName returns the name of the proxy publishing given port binding, named after its host port and protocol.
+func portProxyName(binding string) (string, error) {
+	mappings, err := nat.ParsePortSpec(binding)
+	if err != nil {
+		return "", fmt.Errorf("invalid port binding %q: %v", binding, err)
+	}
+
+	if len(mappings) != 1 {
+		return "", fmt.Errorf("invalid port binding %q: port ranges are not supported", binding)
+	}
+
+	if mappings[0].Binding.HostPort == "" {
+		return "", fmt.Errorf("invalid port binding %q: a host port is required", binding)
+	}
+
+	return fmt.Sprintf("port-%s-%s", mappings[0].Binding.HostPort, mappings[0].Port.Proto()), nil
+}
@@ -0,0 +1,89 @@
+package sind
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/jlevesy/sind/pkg/sind/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPortProxyName(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		binding       string
+		expectedName  string
+		expectedError string
+	}{
+		{
+			desc:         "with a tcp binding",
+			binding:      "9090:8080",
+			expectedName: "port-9090-tcp",
+		},
+		{
+			desc:         "with an udp binding on a host ip",
+			binding:      "127.0.0.1:53:53/udp",
+			expectedName: "port-53-udp",
+		},
+		{
+			desc:          "without host port",
+			binding:       "8080",
+			expectedError: `invalid port binding "8080": a host port is required`,
+		},
+		{
+			desc:          "with a port range",
+			binding:       "9090-9091:8080-8081",
+			expectedError: `invalid port binding "9090-9091:8080-8081": port ranges are not supported`,
+		},
+		{
+			desc:          "with an invalid binding",
+			binding:       "notaport",
+			expectedError: `invalid port binding "notaport"`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			name, err := portProxyName(test.binding)
+			if test.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedName, name)
+		})
+	}
+}
+
+func TestListPorts(t *testing.T) {
+	var sentOpts types.ContainerListOptions
+
+	client := internal.ContainerListerMock(func(ctx context.Context, opts types.ContainerListOptions) ([]types.Container, error) {
+		sentOpts = opts
+
+		return []types.Container{
+			{ID: "b", State: "running", Labels: map[string]string{internal.PortLabel: "9091:9091"}},
+			{ID: "a", State: "exited", Labels: map[string]string{internal.PortLabel: "9090:9090"}},
+		}, nil
+	})
+
+	ports, err := ListPorts(context.Background(), client, "foo")
+	require.NoError(t, err)
+
+	assert.Equal(
+		t,
+		[]PortProxy{
+			{ContainerID: "a", Binding: "9090:9090", State: "exited"},
+			{ContainerID: "b", Binding: "9091:9091", State: "running"},
+		},
+		ports,
+	)
+	assert.True(t, sentOpts.Filters.MatchKVList("label", map[string]string{
+		internal.ClusterNameLabel:      "foo",
+		internal.ClusterComponentLabel: internal.ComponentPort,
+	}))
+}
@@ -0,0 +1,107 @@
+package sind
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	docker "github.com/docker/docker/client"
+	"github.com/jlevesy/sind/pkg/sind/internal"
+)
+
+// ElectPrimary makes a running manager the primary node of a cluster whose primary node is not running, and returns its name.
+// If no node name is given, the first reachable manager is elected.
+// Both nodes are recreated to swap their role labels, keeping their swarm state. The former primary node stays stopped,
+// and the port bindings published on it are not moved to the new primary node.
+// Recreating the elected manager restarts it, so the swarm briefly loses its quorum if it can't afford a second manager down,
+// as with three managers. The former primary node is relabeled only once the swarm is healthy again.
+func ElectPrimary(ctx context.Context, hostClient *docker.Client, clusterName, nodeName string) (string, error) {
+	nodes, err := listClusterNodes(ctx, hostClient, clusterName)
+	if err != nil {
+		return "", err
+	}
+
+	if nodes.primary.State == "running" {
+		return "", fmt.Errorf("primary node %q is running", internal.ContainerNodeName(clusterName, *nodes.primary))
+	}
+
+	candidate, err := primaryCandidate(ctx, hostClient, clusterName, nodes, nodeName)
+	if err != nil {
+		return "", err
+	}
+
+	nodeName = internal.ContainerNodeName(clusterName, candidate)
+	hostname := internal.ContainerName(clusterName, nodeName)
+
+	key, err := ClusterUnlockKey(ctx, hostClient, clusterName)
+	if err != nil {
+		return "", err
+	}
+
+	// Until the former primary node is relabeled, the running primary node prevails.
+	cID, err := internal.RecreateContainer(ctx, hostClient, candidate.ID, withRole(internal.NodeRolePrimary))
+	if err != nil {
+		return "", fmt.Errorf("unable to recreate node %q: %v", hostname, err)
+	}
+
+	if key != "" {
+		if err = internal.WriteContainerFile(ctx, hostClient, cID, unlockKeyPath, []byte(key)); err != nil {
+			return "", fmt.Errorf("unable to store the unlock key: %v", err)
+		}
+
+		newPrimary := types.Container{ID: cID, Labels: candidate.Labels}
+		if err = unlockManagers(ctx, hostClient, []types.Container{newPrimary}, key); err != nil {
+			return "", fmt.Errorf("unable to unlock the primary node: %w", err)
+		}
+	}
+
+	swarmClient, err := newSwarmClient(ctx, hostClient, clusterName)
+	if err != nil {
+		return "", err
+	}
+
+	if err = internal.WaitDaemonReady(ctx, swarmClient); err != nil {
+		return "", fmt.Errorf("unable to contact the primary node daemon: %v", err)
+	}
+
+	if err = internal.WaitSwarmHealthy(ctx, swarmClient, map[string]string{hostname: internal.NodeRolePrimary}); err != nil {
+		return "", err
+	}
+
+	if _, err = internal.RecreateContainer(ctx, hostClient, nodes.primary.ID, withRole(internal.NodeRoleManager)); err != nil {
+		return "", fmt.Errorf("unable to recreate the former primary node: %v", err)
+	}
+
+	if err = refreshProxies(ctx, hostClient, clusterName); err != nil {
+		return "", err
+	}
+
+	return nodeName, nil
+}
+
+// primaryCandidate returns the manager of given name if it can be elected, or the first reachable manager if no name is given.
+func primaryCandidate(ctx context.Context, hostClient *docker.Client, clusterName string, nodes *clusterNodes, nodeName string) (types.Container, error) {
+	if nodeName == "" {
+		candidate, _, err := clusterEntrypoint(ctx, hostClient, clusterName)
+		return candidate, err
+	}
+
+	candidate, ok := nodes.find(clusterName, nodeName)
+	if !ok {
+		return types.Container{}, fmt.Errorf("node %q not found in cluster %q", nodeName, clusterName)
+	}
+
+	if candidate.Labels[internal.NodeRoleLabel] != internal.NodeRoleManager {
+		return types.Container{}, fmt.Errorf("node %q is not a manager", nodeName)
+	}
+
+	if candidate.State != "running" {
+		return types.Container{}, fmt.Errorf("node %q is not running", nodeName)
+	}
+
+	if _, err := internal.SwarmPort(candidate); err != nil {
+		return types.Container{}, fmt.Errorf("node %q does not publish its daemon: %v", nodeName, err)
+	}
+
+	return candidate, nil
+}
@@ -0,0 +1,87 @@
+package sind
+
+import (
+	"io"
+	"sync"
+)
+
+// ProgressKind is the kind of a progress event.
+type ProgressKind string
+
+// Progress event kinds.
+const (
+	// ProgressImageSaved reports the bytes of the image archive saved by the host daemon, or read from the archive file.
+	ProgressImageSaved ProgressKind = "image-saved"
+	// ProgressImageCopied reports the bytes of the image archive streamed to a node.
+	ProgressImageCopied ProgressKind = "image-copied"
+	// ProgressImageLoaded reports that a node loaded the image archive.
+	ProgressImageLoaded ProgressKind = "image-loaded"
+	// ProgressNodeCreated reports that the container of a node is created.
+	ProgressNodeCreated ProgressKind = "node-created"
+	// ProgressNodeJoined reports that a node joined the swarm, or initialized it for the primary node.
+	ProgressNodeJoined ProgressKind = "node-joined"
+)
+
+// ProgressEvent is a step of a long running operation on a cluster.
+type ProgressEvent struct {
+	Kind ProgressKind
+	// Node is the name of the node the event is about, empty for events about the host.
+	Node string
+	// Bytes is the amount of bytes of the image archive saved or copied so far.
+	Bytes int64
+	// Total is the size of the image archive, 0 if it is not known upfront.
+	Total int64
+}
+
+// ProgressFunc receives the progress events of an operation. It is called concurrently, and must not block.
+type ProgressFunc func(ProgressEvent)
+
+// emit sends an event to the progress func, if any.
+func (p ProgressFunc) emit(event ProgressEvent) {
+	if p != nil {
+		p(event)
+	}
+}
+
+// progressReader reports the bytes read from an image archive.
+type progressReader struct {
+	io.ReadCloser
+
+	progress ProgressFunc
+	total    int64
+	read     int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.ReadCloser.Read(b)
+	if n > 0 {
+		p.read += int64(n)
+		p.progress.emit(ProgressEvent{Kind: ProgressImageSaved, Bytes: p.read, Total: p.total})
+	}
+
+	return n, err
+}
+
+// nodeNames maps node container IDs to node names as the nodes are created, to report their progress.
+type nodeNames struct {
+	mu    sync.Mutex
+	names map[string]string
+}
+
+func (n *nodeNames) set(cID, nodeName string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.names == nil {
+		n.names = make(map[string]string)
+	}
+
+	n.names[cID] = nodeName
+}
+
+func (n *nodeNames) get(cID string) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.names[cID]
+}
@@ -0,0 +1,38 @@
+package sind
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressReader(t *testing.T) {
+	var events []ProgressEvent
+
+	reader := progressReader{
+		ReadCloser: ioutil.NopCloser(strings.NewReader("image archive")),
+		progress:   func(event ProgressEvent) { events = append(events, event) },
+		total:      13,
+	}
+
+	b := make([]byte, 8)
+
+	n, err := reader.Read(b)
+	require.NoError(t, err)
+	assert.Equal(t, 8, n)
+
+	_, err = ioutil.ReadAll(&reader)
+	require.NoError(t, err)
+
+	assert.Equal(
+		t,
+		[]ProgressEvent{
+			{Kind: ProgressImageSaved, Bytes: 8, Total: 13},
+			{Kind: ProgressImageSaved, Bytes: 13, Total: 13},
+		},
+		events,
+	)
+}
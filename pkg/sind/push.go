@@ -3,69 +3,381 @@ package sind
 import (
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
-	"path/filepath"
+	"strings"
 
+	"github.com/docker/docker/api/types"
 	docker "github.com/docker/docker/client"
+	"github.com/golang/sync/errgroup"
 	"github.com/jlevesy/sind/pkg/sind/internal"
 )
 
-// PushImageRefs pushes given refs to all node of a cluster.
+// PushResult is the outcome of an image push.
+type PushResult struct {
+	// Skipped are the names of the nodes which already had all the images, with the same IDs as on the host.
+	Skipped []string
+}
+
+// PushOptions are the options of the image pushes.
+type PushOptions struct {
+	// Jobs is how many nodes receive the images in parallel, 0 means all of them.
+	Jobs int
+	// Roles restricts the push to the nodes of given roles, manager or worker. The manager role includes the primary node.
+	Roles []string
+	// Nodes restricts the push to the nodes of given names (eg. worker-1 or sind-test-worker-1).
+	// A node is pushed to if it matches one of the roles or one of the names, all the running nodes are pushed to if none is set.
+	Nodes []string
+	// Progress receives the progress events of the pushes streaming an image archive to the nodes, if set.
+	Progress ProgressFunc
+}
+
+// PushImageRefs pushes given refs to all running nodes of a cluster, to jobs nodes in parallel.
 func PushImageRefs(ctx context.Context, hostClient *docker.Client, clusterName string, jobs int, refs []string) error {
-	imagesFile, err := ioutil.TempFile(os.TempDir(), "sind_images")
+	_, err := PushImageRefsWithOptions(ctx, hostClient, clusterName, PushOptions{Jobs: jobs}, refs)
+	return err
+}
+
+// PushImageRefsWithOptions pushes given refs to the running nodes of a cluster selected by the options.
+// Each node only receives the images it does not already have with the same ID as on the host,
+// nodes having all of them are skipped.
+func PushImageRefsWithOptions(ctx context.Context, hostClient *docker.Client, clusterName string, opts PushOptions, refs []string) (*PushResult, error) {
+	containers, err := pushedNodes(ctx, hostClient, clusterName, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	missing, err := missingImages(ctx, hostClient, containers, refs)
 	if err != nil {
-		return fmt.Errorf("unable to create a temporary archive file: %v", err)
+		return nil, err
 	}
 
-	defer os.Remove(imagesFile.Name())
-	defer imagesFile.Close()
+	pushes, skipped := groupMissingImages(clusterName, containers, missing)
 
-	if err = internal.SaveImages(ctx, hostClient, imagesFile, refs); err != nil {
-		return fmt.Errorf("unable to save images to file: %v", err)
+	for _, push := range pushes {
+		if err = pushImageRefs(ctx, hostClient, clusterName, push.nodes, opts, push.refs); err != nil {
+			return nil, err
+		}
 	}
 
-	return PushImageFile(ctx, hostClient, clusterName, jobs, imagesFile)
+	return &PushResult{Skipped: skipped}, nil
 }
 
-// PushImageFile pushes a given image archive file on all the nodes of a given Cluster.
+// pushImageRefs streams the archive of given refs saved by the host daemon to given nodes.
+func pushImageRefs(ctx context.Context, hostClient *docker.Client, clusterName string, containers []types.Container, opts PushOptions, refs []string) error {
+	return pushArchive(ctx, hostClient, clusterName, containers, opts, 0, func() (io.ReadCloser, error) {
+		archive, err := hostClient.ImageSave(ctx, refs)
+		if err != nil {
+			return nil, fmt.Errorf("unable to save images: %v", err)
+		}
+
+		return archive, nil
+	})
+}
+
+// PushImageFile pushes a given image archive file on all the running nodes of a given Cluster, to jobs nodes in parallel.
+// Nodes which are not running are skipped.
 func PushImageFile(ctx context.Context, hostClient *docker.Client, clusterName string, jobs int, file *os.File) error {
-	containers, err := internal.ListContainers(ctx, hostClient, clusterName)
+	return PushImageFileWithOptions(ctx, hostClient, clusterName, PushOptions{Jobs: jobs}, file)
+}
+
+// PushImageFileWithOptions pushes a given image archive file on the running nodes of a given Cluster selected by the options.
+// The file is either an archive produced by docker save, uncompressed or compressed with gzip or zstd,
+// or an OCI image layout directory, whose images are converted to the platform of the host daemon.
+// Nodes which are not running are skipped.
+func PushImageFileWithOptions(ctx context.Context, hostClient *docker.Client, clusterName string, opts PushOptions, file *os.File) error {
+	containers, err := pushedNodes(ctx, hostClient, clusterName, opts)
 	if err != nil {
-		return fmt.Errorf("unable to list cluster %q containers: %v", clusterName, err)
+		return err
 	}
 
-	archiveFile, err := ioutil.TempFile(os.TempDir(), "sind_archive")
+	return pushImageFile(ctx, hostClient, clusterName, containers, opts, file)
+}
+
+// pushImageFile streams an image archive file or OCI image layout directory to given nodes.
+func pushImageFile(ctx context.Context, hostClient *docker.Client, clusterName string, containers []types.Container, opts PushOptions, file *os.File) error {
+	info, err := file.Stat()
 	if err != nil {
-		return fmt.Errorf("unable to create a temporary archive file: %v", err)
+		return fmt.Errorf("unable to stat file %q: %v", file.Name(), err)
 	}
 
-	defer os.Remove(archiveFile.Name())
-	defer archiveFile.Close()
+	if info.IsDir() {
+		return pushOCILayout(ctx, hostClient, clusterName, containers, opts, file.Name())
+	}
 
-	if err = internal.TarFile(file, archiveFile); err != nil {
-		return fmt.Errorf("unable to tar file: %v", err)
+	header := make([]byte, 4)
+
+	n, err := file.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("unable to read file %q: %v", file.Name(), err)
 	}
 
-	if err = internal.CopyToContainers(ctx, hostClient, containers, jobs, archiveFile.Name(), "/"); err != nil {
-		return fmt.Errorf("unable to copy content to containers: %v", err)
+	section := func() io.Reader {
+		return io.NewSectionReader(file, 0, info.Size())
 	}
 
-	err = internal.ExecContainers(
-		ctx,
-		hostClient,
-		containers,
-		jobs,
-		[]string{
-			"docker",
-			"load",
-			"-i",
-			filepath.Join("/", filepath.Base(file.Name())),
-		},
-	)
+	// The daemons of the nodes load gzip archives, but not zstd ones.
+	if internal.ArchiveCompression(header[:n]) == internal.CompressionZstd {
+		return pushArchive(ctx, hostClient, clusterName, containers, opts, 0, func() (io.ReadCloser, error) {
+			return internal.ZstdReader(section())
+		})
+	}
+
+	return pushArchive(ctx, hostClient, clusterName, containers, opts, info.Size(), func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(section()), nil
+	})
+}
+
+// pushOCILayout converts the images of an OCI image layout directory for the platform of the host daemon, and streams them to given nodes.
+func pushOCILayout(ctx context.Context, hostClient *docker.Client, clusterName string, containers []types.Container, opts PushOptions, dir string) error {
+	version, err := hostClient.ServerVersion(ctx)
 	if err != nil {
-		return fmt.Errorf("unable to load image on nodes daemons: %v", err)
+		return fmt.Errorf("unable to get the platform of the docker daemon: %v", err)
+	}
+
+	layout, err := internal.ReadOCILayout(dir, version.Os, version.Arch)
+	if err != nil {
+		return err
+	}
+
+	return pushArchive(ctx, hostClient, clusterName, containers, opts, 0, func() (io.ReadCloser, error) {
+		return layout.Archive(), nil
+	})
+}
+
+// pushArchive streams an image archive of given size, 0 if unknown, to the daemons of given nodes, at most opts.Jobs nodes at a time.
+// The archive is opened once per batch of nodes, then fanned out to all the nodes of the batch.
+func pushArchive(ctx context.Context, hostClient *docker.Client, clusterName string, containers []types.Container, opts PushOptions, size int64, open func() (io.ReadCloser, error)) error {
+	jobs := opts.Jobs
+	if jobs == 0 {
+		jobs = len(containers)
+	}
+
+	names := make(map[string]string, len(containers))
+
+	for _, container := range containers {
+		names[container.ID] = internal.ContainerNodeName(clusterName, container)
+	}
+
+	var progress internal.LoadProgress
+
+	if opts.Progress != nil {
+		progress.Copied = func(cID string, bytes int64) {
+			opts.Progress(ProgressEvent{Kind: ProgressImageCopied, Node: names[cID], Bytes: bytes, Total: size})
+		}
+
+		progress.Loaded = func(cID string) {
+			opts.Progress(ProgressEvent{Kind: ProgressImageLoaded, Node: names[cID], Total: size})
+		}
+	}
+
+	for len(containers) > 0 {
+		batch := containers
+		if len(batch) > jobs {
+			batch = batch[:jobs]
+		}
+
+		containers = containers[len(batch):]
+
+		archive, err := open()
+		if err != nil {
+			return err
+		}
+
+		if opts.Progress != nil {
+			archive = &progressReader{ReadCloser: archive, progress: opts.Progress, total: size}
+		}
+
+		err = internal.LoadImagesStream(ctx, hostClient, batch, archive, progress)
+		archive.Close()
+
+		if err != nil {
+			return fmt.Errorf("unable to load image on nodes daemons: %w", err)
+		}
 	}
 
 	return nil
 }
+
+// pushedNodes returns the running node containers of a cluster selected by given options.
+func pushedNodes(ctx context.Context, hostClient internal.ContainerLister, clusterName string, opts PushOptions) ([]types.Container, error) {
+	containers, err := internal.ListContainers(ctx, hostClient, clusterName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list cluster %q containers: %v", clusterName, err)
+	}
+
+	return selectNodes(clusterName, containers, opts)
+}
+
+// selectNodes returns the running containers matching the role and node selectors of given options.
+// Nodes selected by name must exist and be running, nodes selected by role which are not running are skipped.
+func selectNodes(clusterName string, containers []types.Container, opts PushOptions) ([]types.Container, error) {
+	for _, role := range opts.Roles {
+		if role != NodeRoleManager && role != NodeRoleWorker {
+			return nil, fmt.Errorf("invalid node role %q, expected %s or %s", role, NodeRoleManager, NodeRoleWorker)
+		}
+	}
+
+	for _, name := range opts.Nodes {
+		container, ok := findNode(clusterName, containers, name)
+		if !ok {
+			return nil, fmt.Errorf("node %q not found in cluster %q", name, clusterName)
+		}
+
+		if container.State != "running" {
+			return nil, fmt.Errorf("node %q is not running", name)
+		}
+	}
+
+	selectAll := len(opts.Roles) == 0 && len(opts.Nodes) == 0
+
+	var selected []types.Container
+
+	for _, container := range containers {
+		if container.State != "running" {
+			continue
+		}
+
+		if selectAll || contains(opts.Roles, nodeRole(container)) || nodeNamed(clusterName, container, opts.Nodes) {
+			selected = append(selected, container)
+		}
+	}
+
+	return selected, nil
+}
+
+// nodeRole returns the role of a node container, manager or worker.
+func nodeRole(container types.Container) string {
+	if container.Labels[internal.NodeRoleLabel] == internal.NodeRoleWorker {
+		return NodeRoleWorker
+	}
+
+	return NodeRoleManager
+}
+
+// findNode returns the container of the node of given node or container name.
+func findNode(clusterName string, containers []types.Container, name string) (types.Container, bool) {
+	for _, container := range containers {
+		if nodeNamed(clusterName, container, []string{name}) {
+			return container, true
+		}
+	}
+
+	return types.Container{}, false
+}
+
+// nodeNamed returns true if the node name or the container name of given container is one of given names.
+func nodeNamed(clusterName string, container types.Container, names []string) bool {
+	nodeName := internal.ContainerNodeName(clusterName, container)
+	if nodeName == "" {
+		return false
+	}
+
+	return contains(names, nodeName) || contains(names, internal.ContainerName(clusterName, nodeName))
+}
+
+// missingImages returns the refs each node does not have with the same image ID as the host, indexed by container ID.
+func missingImages(ctx context.Context, hostClient *docker.Client, containers []types.Container, refs []string) (map[string][]string, error) {
+	hostIDs := make(map[string]string, len(refs))
+
+	for _, ref := range refs {
+		normalized, err := internal.NormalizeImageRef(ref)
+		if err != nil {
+			return nil, err
+		}
+
+		image, _, err := hostClient.ImageInspectWithRaw(ctx, ref)
+		if err != nil {
+			return nil, fmt.Errorf("unable to inspect image %q: %v", ref, err)
+		}
+
+		hostIDs[normalized] = image.ID
+	}
+
+	results := make(chan nodeMissingImages, len(containers))
+
+	errg, groupCtx := errgroup.WithContext(ctx)
+
+	for _, container := range containers {
+		cID := container.ID
+
+		errg.Go(func() error {
+			images, err := internal.NodeImages(groupCtx, hostClient, cID)
+			if err != nil {
+				return fmt.Errorf("unable to list the images of node %q: %w", cID, err)
+			}
+
+			result := nodeMissingImages{cID: cID}
+
+			for _, ref := range refs {
+				// Refs are valid, they have been normalized already.
+				normalized, _ := internal.NormalizeImageRef(ref)
+
+				if images[normalized] != hostIDs[normalized] {
+					result.refs = append(result.refs, ref)
+				}
+			}
+
+			results <- result
+
+			return nil
+		})
+	}
+
+	if err := errg.Wait(); err != nil {
+		return nil, err
+	}
+
+	close(results)
+
+	missing := make(map[string][]string, len(containers))
+
+	for result := range results {
+		missing[result.cID] = result.refs
+	}
+
+	return missing, nil
+}
+
+type nodeMissingImages struct {
+	cID  string
+	refs []string
+}
+
+// imagePush is a set of images to push to a set of nodes.
+type imagePush struct {
+	refs  []string
+	nodes []types.Container
+}
+
+// groupMissingImages groups the nodes missing the same images, so that each set of images is saved once.
+// It also returns the names of the nodes which are not missing any image.
+func groupMissingImages(clusterName string, containers []types.Container, missing map[string][]string) ([]imagePush, []string) {
+	var (
+		pushes  []imagePush
+		skipped []string
+		indexes = make(map[string]int)
+	)
+
+	for _, container := range containers {
+		refs := missing[container.ID]
+		if len(refs) == 0 {
+			skipped = append(skipped, internal.ContainerNodeName(clusterName, container))
+			continue
+		}
+
+		key := strings.Join(refs, "\x00")
+
+		index, ok := indexes[key]
+		if !ok {
+			index = len(pushes)
+			indexes[key] = index
+			pushes = append(pushes, imagePush{refs: refs})
+		}
+
+		pushes[index].nodes = append(pushes[index].nodes, container)
+	}
+
+	return pushes, skipped
+}
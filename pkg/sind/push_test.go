@@ -0,0 +1,122 @@
+package sind
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/jlevesy/sind/pkg/sind/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupMissingImages(t *testing.T) {
+	node := func(name string) types.Container {
+		return types.Container{ID: name, Names: []string{"/" + internal.ContainerName("test", name)}}
+	}
+
+	containers := []types.Container{node("manager-0"), node("worker-0"), node("worker-1"), node("worker-2")}
+	missing := map[string][]string{
+		"manager-0": {"alpine:3.12", "nginx:1.21"},
+		"worker-0":  {"nginx:1.21"},
+		"worker-2":  {"alpine:3.12", "nginx:1.21"},
+	}
+
+	pushes, skipped := groupMissingImages("test", containers, missing)
+
+	assert.Equal(
+		t,
+		[]imagePush{
+			{refs: []string{"alpine:3.12", "nginx:1.21"}, nodes: []types.Container{node("manager-0"), node("worker-2")}},
+			{refs: []string{"nginx:1.21"}, nodes: []types.Container{node("worker-0")}},
+		},
+		pushes,
+	)
+	assert.Equal(t, []string{"worker-1"}, skipped)
+}
+
+func TestSelectNodes(t *testing.T) {
+	node := func(name, role, state string) types.Container {
+		return types.Container{
+			ID:     name,
+			Names:  []string{"/" + internal.ContainerName("test", name)},
+			Labels: map[string]string{internal.NodeRoleLabel: role},
+			State:  state,
+		}
+	}
+
+	containers := []types.Container{
+		node("manager-0", internal.NodeRolePrimary, "running"),
+		node("manager-1", internal.NodeRoleManager, "running"),
+		node("worker-0", internal.NodeRoleWorker, "running"),
+		node("worker-1", internal.NodeRoleWorker, "exited"),
+		node("worker-2", internal.NodeRoleWorker, "running"),
+	}
+
+	testCases := []struct {
+		desc          string
+		opts          PushOptions
+		expected      []string
+		expectedError string
+	}{
+		{
+			desc:     "all running nodes",
+			expected: []string{"manager-0", "manager-1", "worker-0", "worker-2"},
+		},
+		{
+			desc:     "workers",
+			opts:     PushOptions{Roles: []string{NodeRoleWorker}},
+			expected: []string{"worker-0", "worker-2"},
+		},
+		{
+			desc:     "managers include the primary node",
+			opts:     PushOptions{Roles: []string{NodeRoleManager}},
+			expected: []string{"manager-0", "manager-1"},
+		},
+		{
+			desc:     "nodes by node and container names",
+			opts:     PushOptions{Nodes: []string{"worker-2", "sind-test-manager-1"}},
+			expected: []string{"manager-1", "worker-2"},
+		},
+		{
+			desc:     "roles and nodes",
+			opts:     PushOptions{Roles: []string{NodeRoleWorker}, Nodes: []string{"manager-1"}},
+			expected: []string{"manager-1", "worker-0", "worker-2"},
+		},
+		{
+			desc:          "invalid role",
+			opts:          PushOptions{Roles: []string{"primary"}},
+			expectedError: `invalid node role "primary"`,
+		},
+		{
+			desc:          "unknown node",
+			opts:          PushOptions{Nodes: []string{"worker-3"}},
+			expectedError: `node "worker-3" not found in cluster "test"`,
+		},
+		{
+			desc:          "stopped node",
+			opts:          PushOptions{Nodes: []string{"worker-1"}},
+			expectedError: `node "worker-1" is not running`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			selected, err := selectNodes("test", containers, test.opts)
+			if test.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+
+			var names []string
+
+			for _, container := range selected {
+				names = append(names, container.ID)
+			}
+
+			assert.Equal(t, test.expected, names)
+		})
+	}
+}
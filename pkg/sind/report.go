@@ -0,0 +1,139 @@
+package sind
+
+import (
+	"sort"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/jlevesy/sind/pkg/sind/internal"
+)
+
+// Cluster states reported by ClusterStatus.State.
+const (
+	ClusterStateRunning  = "running"
+	ClusterStateStopped  = "stopped"
+	ClusterStateUnstable = "unstable"
+)
+
+// State returns the state of the cluster, computed from the amount of nodes running.
+// If the swarm state is known, a running node which is not a ready member of the swarm makes the cluster unstable.
+func (c *ClusterStatus) State() string {
+	if c.ManagersRunning == 0 && c.WorkersRunning == 0 {
+		return ClusterStateStopped
+	}
+
+	if c.ManagersRunning != c.Managers || c.WorkersRunning != c.Workers {
+		return ClusterStateUnstable
+	}
+
+	if c.Swarm == nil {
+		return ClusterStateRunning
+	}
+
+	for _, node := range c.Nodes {
+		if c.Swarm[node.ID].State != string(swarm.NodeStateReady) {
+			return ClusterStateUnstable
+		}
+	}
+
+	return ClusterStateRunning
+}
+
+// ClusterReport is the machine readable representation of a cluster.
+// Its fields, and their json and yaml names, are part of sind output format and must remain stable.
+type ClusterReport struct {
+	Name     string       `json:"name" yaml:"name"`
+	State    string       `json:"state" yaml:"state"`
+	Managers NodesReport  `json:"managers" yaml:"managers"`
+	Workers  NodesReport  `json:"workers" yaml:"workers"`
+	Nodes    []NodeReport `json:"nodes" yaml:"nodes"`
+}
+
+// NodesReport is the amount of nodes of a given role in a cluster.
+type NodesReport struct {
+	Total   uint16 `json:"total" yaml:"total"`
+	Running uint16 `json:"running" yaml:"running"`
+}
+
+// NodeReport is the machine readable representation of a cluster node.
+type NodeReport struct {
+	ID     string       `json:"id" yaml:"id"`
+	Name   string       `json:"name" yaml:"name"`
+	Role   string       `json:"role" yaml:"role"`
+	Image  string       `json:"image" yaml:"image"`
+	State  string       `json:"state" yaml:"state"`
+	Status string       `json:"status" yaml:"status"`
+	IPs    []string     `json:"ips" yaml:"ips"`
+	Ports  []PortReport `json:"ports" yaml:"ports"`
+
+	// Swarm is only reported when the swarm state has been inspected, and the node is a member of the swarm.
+	Swarm *SwarmNodeStatus `json:"swarm,omitempty" yaml:"swarm,omitempty"`
+}
+
+// PortReport is a port published by a cluster node on the docker host.
+type PortReport struct {
+	HostIP   string `json:"hostIP,omitempty" yaml:"hostIP,omitempty"`
+	HostPort uint16 `json:"hostPort" yaml:"hostPort"`
+	NodePort uint16 `json:"nodePort" yaml:"nodePort"`
+	Protocol string `json:"protocol" yaml:"protocol"`
+}
+
+// Report returns the machine readable representation of the cluster.
+func (c *ClusterStatus) Report() ClusterReport {
+	report := ClusterReport{
+		Name:     c.Name,
+		State:    c.State(),
+		Managers: NodesReport{Total: c.Managers, Running: c.ManagersRunning},
+		Workers:  NodesReport{Total: c.Workers, Running: c.WorkersRunning},
+		Nodes:    make([]NodeReport, 0, len(c.Nodes)),
+	}
+
+	for _, node := range c.Nodes {
+		r := nodeReport(c.Name, node)
+
+		if swarmStatus, ok := c.Swarm[node.ID]; ok {
+			r.Swarm = &swarmStatus
+		}
+
+		report.Nodes = append(report.Nodes, r)
+	}
+
+	return report
+}
+
+func nodeReport(clusterName string, node types.Container) NodeReport {
+	report := NodeReport{
+		ID:     node.ID,
+		Name:   internal.ContainerNodeName(clusterName, node),
+		Role:   node.Labels[internal.NodeRoleLabel],
+		Image:  node.Image,
+		State:  node.State,
+		Status: node.Status,
+		IPs:    []string{},
+		Ports:  []PortReport{},
+	}
+
+	if node.NetworkSettings != nil {
+		for _, net := range node.NetworkSettings.Networks {
+			report.IPs = append(report.IPs, net.IPAddress)
+		}
+
+		sort.Strings(report.IPs)
+	}
+
+	for _, port := range node.Ports {
+		// Ports exposed but not published are not reachable from the host.
+		if port.PublicPort == 0 {
+			continue
+		}
+
+		report.Ports = append(report.Ports, PortReport{
+			HostIP:   port.IP,
+			HostPort: port.PublicPort,
+			NodePort: port.PrivatePort,
+			Protocol: port.Type,
+		})
+	}
+
+	return report
+}
@@ -0,0 +1,135 @@
+package sind
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/jlevesy/sind/pkg/sind/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterStatusState(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		status        ClusterStatus
+		expectedState string
+	}{
+		{
+			desc:          "with no nodes running",
+			status:        ClusterStatus{Managers: 1, Workers: 1},
+			expectedState: ClusterStateStopped,
+		},
+		{
+			desc:          "with all nodes running",
+			status:        ClusterStatus{Managers: 1, ManagersRunning: 1, Workers: 1, WorkersRunning: 1},
+			expectedState: ClusterStateRunning,
+		},
+		{
+			desc: "with a running node which is not a member of the swarm",
+			status: ClusterStatus{
+				Managers:        1,
+				ManagersRunning: 1,
+				Workers:         1,
+				WorkersRunning:  1,
+				Nodes:           []types.Container{{ID: "aaa"}, {ID: "bbb"}},
+				Swarm:           map[string]SwarmNodeStatus{"aaa": {State: "ready"}},
+			},
+			expectedState: ClusterStateUnstable,
+		},
+		{
+			desc: "with all nodes ready in the swarm",
+			status: ClusterStatus{
+				Managers:        1,
+				ManagersRunning: 1,
+				Workers:         1,
+				WorkersRunning:  1,
+				Nodes:           []types.Container{{ID: "aaa"}, {ID: "bbb"}},
+				Swarm:           map[string]SwarmNodeStatus{"aaa": {State: "ready"}, "bbb": {State: "ready"}},
+			},
+			expectedState: ClusterStateRunning,
+		},
+		{
+			desc:          "with some nodes running",
+			status:        ClusterStatus{Managers: 1, ManagersRunning: 1, Workers: 1},
+			expectedState: ClusterStateUnstable,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			assert.Equal(t, test.expectedState, test.status.State())
+		})
+	}
+}
+
+func TestClusterStatusReport(t *testing.T) {
+	status := ClusterStatus{
+		Name:            "foo",
+		Managers:        1,
+		ManagersRunning: 1,
+		Workers:         1,
+		Nodes: []types.Container{
+			{
+				ID:     "aaa",
+				Names:  []string{"/sind-foo-manager-0"},
+				Image:  "docker:dind",
+				State:  "running",
+				Status: "Up 2 minutes",
+				Labels: map[string]string{internal.NodeRoleLabel: internal.NodeRolePrimary},
+				Ports: []types.Port{
+					{PrivatePort: 2375, PublicPort: 32768, IP: "0.0.0.0", Type: "tcp"},
+					{PrivatePort: 2376, Type: "tcp"},
+				},
+				NetworkSettings: &types.SummaryNetworkSettings{
+					Networks: map[string]*network.EndpointSettings{
+						"foo": {IPAddress: "10.0.1.2"},
+					},
+				},
+			},
+			{
+				ID:     "bbb",
+				Names:  []string{"/sind-foo-worker-0"},
+				Image:  "docker:dind",
+				State:  "exited",
+				Status: "Exited (0) 1 minute ago",
+				Labels: map[string]string{internal.NodeRoleLabel: internal.NodeRoleWorker},
+			},
+		},
+	}
+
+	assert.Equal(
+		t,
+		ClusterReport{
+			Name:     "foo",
+			State:    ClusterStateUnstable,
+			Managers: NodesReport{Total: 1, Running: 1},
+			Workers:  NodesReport{Total: 1},
+			Nodes: []NodeReport{
+				{
+					ID:     "aaa",
+					Name:   "manager-0",
+					Role:   internal.NodeRolePrimary,
+					Image:  "docker:dind",
+					State:  "running",
+					Status: "Up 2 minutes",
+					IPs:    []string{"10.0.1.2"},
+					Ports: []PortReport{
+						{HostIP: "0.0.0.0", HostPort: 32768, NodePort: 2375, Protocol: "tcp"},
+					},
+				},
+				{
+					ID:     "bbb",
+					Name:   "worker-0",
+					Role:   internal.NodeRoleWorker,
+					Image:  "docker:dind",
+					State:  "exited",
+					Status: "Exited (0) 1 minute ago",
+					IPs:    []string{},
+					Ports:  []PortReport{},
+				},
+			},
+		},
+		status.Report(),
+	)
+}
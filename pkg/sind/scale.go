@@ -0,0 +1,84 @@
+package sind
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	docker "github.com/docker/docker/client"
+	"github.com/golang/sync/errgroup"
+	"github.com/jlevesy/sind/pkg/sind/internal"
+)
+
+// ScaleCluster adds or removes nodes of a running cluster until it counts the given amount of managers and workers.
+// New nodes join the swarm using the current join tokens, removed nodes are drained, leave the swarm, then are deleted.
+func ScaleCluster(ctx context.Context, hostClient *docker.Client, clusterName string, managers, workers uint16) error {
+	if managers < 1 {
+		return errors.New("invalid manager count, must be >= 1")
+	}
+
+	nodes, err := listClusterNodes(ctx, hostClient, clusterName)
+	if err != nil {
+		return err
+	}
+
+	swarmClient, err := newSwarmClient(ctx, hostClient, clusterName)
+	if err != nil {
+		return err
+	}
+
+	// The primary node is always kept, so it is not accounted in the managers to add or remove.
+	currentManagers := uint16(len(nodes.managers)) + 1
+	currentWorkers := uint16(len(nodes.workers))
+
+	var toAdd []newNode
+
+	for i := currentManagers; i < managers; i++ {
+		index := nodes.nextIndex(clusterName, internal.NodeRoleManager) + i - currentManagers
+		toAdd = append(toAdd, newNode{name: internal.NodeName(internal.NodeRoleManager, index), role: internal.NodeRoleManager})
+	}
+
+	for i := currentWorkers; i < workers; i++ {
+		index := nodes.nextIndex(clusterName, internal.NodeRoleWorker) + i - currentWorkers
+		toAdd = append(toAdd, newNode{name: internal.NodeName(internal.NodeRoleWorker, index), role: internal.NodeRoleWorker})
+	}
+
+	if err = addNodes(ctx, hostClient, swarmClient, clusterName, *nodes.primary, toAdd); err != nil {
+		return fmt.Errorf("unable to add nodes: %v", err)
+	}
+
+	// Managers are removed one by one, starting from the highest index, to preserve the raft quorum.
+	for i := len(nodes.managers) - 1; i >= 0 && uint16(i)+1 >= managers; i-- {
+		if err = removeNode(ctx, hostClient, swarmClient, clusterName, nodes.managers[i]); err != nil {
+			return fmt.Errorf("unable to remove manager: %v", err)
+		}
+	}
+
+	if workers < currentWorkers {
+		if err = removeNodes(ctx, hostClient, swarmClient, clusterName, nodes.workers[workers:]); err != nil {
+			return fmt.Errorf("unable to remove workers: %v", err)
+		}
+	}
+
+	if managers == currentManagers {
+		return nil
+	}
+
+	return refreshProxies(ctx, hostClient, clusterName)
+}
+
+// removeNodes removes all given nodes concurrently.
+func removeNodes(ctx context.Context, hostClient, swarmClient *docker.Client, clusterName string, containers []types.Container) error {
+	errg, groupCtx := errgroup.WithContext(ctx)
+
+	for _, container := range containers {
+		c := container
+
+		errg.Go(func() error {
+			return removeNode(groupCtx, hostClient, swarmClient, clusterName, c)
+		})
+	}
+
+	return errg.Wait()
+}
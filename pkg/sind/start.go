@@ -4,16 +4,136 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
 	docker "github.com/docker/docker/client"
+	"github.com/golang/sync/errgroup"
 	"github.com/jlevesy/sind/pkg/sind/internal"
 )
 
+// StartOptions are the options of StartClusterWithOptions.
+type StartOptions struct {
+	// Wait waits until the daemon of every node answers, and until the swarm reports all nodes ready with a leader elected.
+	Wait bool
+	// Activate restores the availability the nodes had before being drained by StopOptions.Drain, once the swarm is healthy.
+	// The other nodes are left untouched. It implies Wait.
+	Activate bool
+}
+
 // StartCluster starts all nodes of a cluster.
+// If the cluster is autolocked, the managers are unlocked once their daemons answer.
 func StartCluster(ctx context.Context, hostClient *docker.Client, clusterName string) error {
-	containers, err := internal.ListContainers(ctx, hostClient, clusterName)
+	return StartClusterWithOptions(ctx, hostClient, clusterName, StartOptions{})
+}
+
+// StartClusterWithOptions starts all nodes of a cluster, then waits for the cluster to be healthy if requested by the options.
+// If the cluster is autolocked, the managers are unlocked once their daemons answer.
+func StartClusterWithOptions(ctx context.Context, hostClient *docker.Client, clusterName string, opts StartOptions) error {
+	containers, err := internal.ListClusterContainers(ctx, hostClient, clusterName)
 	if err != nil {
 		return fmt.Errorf("unable to get container list %v", err)
 	}
 
-	return internal.StartContainers(ctx, hostClient, containers)
+	if err = internal.StartContainers(ctx, hostClient, containers); err != nil {
+		return err
+	}
+
+	nodes, err := internal.ListContainers(ctx, hostClient, clusterName)
+	if err != nil {
+		return fmt.Errorf("unable to list nodes: %v", err)
+	}
+
+	key, err := ClusterUnlockKey(ctx, hostClient, clusterName)
+	if err != nil {
+		return err
+	}
+
+	if key != "" {
+		if err = unlockManagers(ctx, hostClient, nodes, key); err != nil {
+			return fmt.Errorf("unable to unlock the managers: %w", err)
+		}
+	}
+
+	if !opts.Wait && !opts.Activate {
+		return nil
+	}
+
+	swarmClient, err := newSwarmClient(ctx, hostClient, clusterName)
+	if err != nil {
+		return err
+	}
+
+	if err = waitClusterHealthy(ctx, hostClient, swarmClient, clusterName, nodes); err != nil {
+		return err
+	}
+
+	if !opts.Activate {
+		return nil
+	}
+
+	return restoreAvailability(ctx, swarmClient)
+}
+
+// restoreAvailability gives back to the nodes of a swarm drained when stopping the cluster their recorded availability.
+func restoreAvailability(ctx context.Context, swarmClient *docker.Client) error {
+	nodes, err := swarmClient.NodeList(ctx, types.NodeListOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to list swarm nodes: %v", err)
+	}
+
+	for _, node := range nodes {
+		availability, ok := node.Spec.Labels[internal.AvailabilityLabel]
+		if !ok {
+			continue
+		}
+
+		err = internal.UpdateSwarmNode(ctx, swarmClient, node.ID, func(spec *swarm.NodeSpec) {
+			delete(spec.Labels, internal.AvailabilityLabel)
+			spec.Availability = swarm.NodeAvailability(availability)
+		})
+		if err != nil {
+			return fmt.Errorf("unable to restore node %q availability: %v", node.Description.Hostname, err)
+		}
+	}
+
+	return nil
+}
+
+// waitClusterHealthy waits until the daemon of each given node answers, then until the swarm is healthy.
+func waitClusterHealthy(ctx context.Context, hostClient, swarmClient *docker.Client, clusterName string, nodes []types.Container) error {
+	errg, groupCtx := errgroup.WithContext(ctx)
+	expected := make(map[string]string, len(nodes))
+
+	for _, node := range nodes {
+		role := node.Labels[internal.NodeRoleLabel]
+		expected[internal.ContainerName(clusterName, internal.ContainerNodeName(clusterName, node))] = role
+
+		if role == internal.NodeRolePrimary {
+			errg.Go(func() error {
+				if err := internal.WaitDaemonReady(groupCtx, swarmClient); err != nil {
+					return fmt.Errorf("daemon of the primary node is not ready: %v", err)
+				}
+
+				return nil
+			})
+
+			continue
+		}
+
+		cID := node.ID
+
+		errg.Go(func() error {
+			if err := internal.WaitNodeDaemonReady(groupCtx, hostClient, cID); err != nil {
+				return fmt.Errorf("daemon of node %q is not ready: %v", cID, err)
+			}
+
+			return nil
+		})
+	}
+
+	if err := errg.Wait(); err != nil {
+		return err
+	}
+
+	return internal.WaitSwarmHealthy(ctx, swarmClient, expected)
 }
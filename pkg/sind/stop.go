@@ -3,17 +3,119 @@ package sind
 import (
 	"context"
 	"fmt"
+	"time"
 
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
 	docker "github.com/docker/docker/client"
 	"github.com/jlevesy/sind/pkg/sind/internal"
 )
 
+// StopOptions are the options of StopClusterWithOptions.
+type StopOptions struct {
+	// Timeout is the time given to each container to stop before being killed, nil keeps the docker default.
+	Timeout *time.Duration
+	// Ordered stops the load balancer, port proxies and workers first, then the managers, and the primary node last.
+	Ordered bool
+	// Drain drains the active nodes and waits for their tasks to stop before stopping the containers.
+	// Drained nodes stay drained once the cluster is started again, unless started with StartOptions.Activate.
+	// Paused and already drained nodes are left untouched.
+	Drain bool
+}
+
 // StopCluster stops all nodes of a cluster.
 func StopCluster(ctx context.Context, hostClient *docker.Client, clusterName string) error {
-	containers, err := internal.ListContainers(ctx, hostClient, clusterName)
+	return StopClusterWithOptions(ctx, hostClient, clusterName, StopOptions{})
+}
+
+// StopClusterWithOptions stops all nodes of a cluster, as configured by the options.
+// The stored unlock key of an autolocked cluster is refreshed first, so that the managers can be unlocked on start.
+func StopClusterWithOptions(ctx context.Context, hostClient *docker.Client, clusterName string, opts StopOptions) error {
+	containers, err := internal.ListClusterContainers(ctx, hostClient, clusterName)
 	if err != nil {
 		return fmt.Errorf("unable to get container list %v", err)
 	}
 
-	return internal.StopContainers(ctx, hostClient, containers)
+	if _, err = ClusterUnlockKey(ctx, hostClient, clusterName); err != nil {
+		return err
+	}
+
+	if opts.Drain {
+		if err = drainCluster(ctx, hostClient, clusterName); err != nil {
+			return err
+		}
+	}
+
+	if !opts.Ordered {
+		return internal.StopContainers(ctx, hostClient, containers, opts.Timeout)
+	}
+
+	for _, group := range stopOrder(containers) {
+		if err = internal.StopContainers(ctx, hostClient, group, opts.Timeout); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stopOrder groups the containers of a cluster in the order they have to be stopped:
+// components and workers, then managers, then the primary node.
+func stopOrder(containers []types.Container) [][]types.Container {
+	groups := make([][]types.Container, 3)
+
+	for _, container := range containers {
+		switch container.Labels[internal.NodeRoleLabel] {
+		case internal.NodeRolePrimary:
+			groups[2] = append(groups[2], container)
+		case internal.NodeRoleManager:
+			groups[1] = append(groups[1], container)
+		default:
+			groups[0] = append(groups[0], container)
+		}
+	}
+
+	return groups
+}
+
+// drainCluster drains the active nodes of the swarm of a cluster, then waits for their tasks to stop.
+// The availability of each drained node is recorded in its labels, to be restored on start.
+func drainCluster(ctx context.Context, hostClient *docker.Client, clusterName string) error {
+	swarmClient, err := newSwarmClient(ctx, hostClient, clusterName)
+	if err != nil {
+		return err
+	}
+
+	nodes, err := swarmClient.NodeList(ctx, types.NodeListOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to list swarm nodes: %v", err)
+	}
+
+	nodeIDs := make([]string, 0, len(nodes))
+
+	for _, node := range nodes {
+		if node.Spec.Availability != swarm.NodeAvailabilityActive {
+			continue
+		}
+
+		err = internal.UpdateSwarmNode(ctx, swarmClient, node.ID, func(spec *swarm.NodeSpec) {
+			if spec.Labels == nil {
+				spec.Labels = make(map[string]string)
+			}
+
+			spec.Labels[internal.AvailabilityLabel] = string(spec.Availability)
+			spec.Availability = swarm.NodeAvailabilityDrain
+		})
+		if err != nil {
+			return fmt.Errorf("unable to drain node %q: %v", node.Description.Hostname, err)
+		}
+
+		nodeIDs = append(nodeIDs, node.ID)
+	}
+
+	if err = internal.WaitTasksStopped(ctx, swarmClient, nodeIDs); err != nil {
+		return fmt.Errorf("unable to wait for the tasks to stop: %v", err)
+	}
+
+	return nil
 }
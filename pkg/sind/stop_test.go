@@ -0,0 +1,29 @@
+package sind
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/jlevesy/sind/pkg/sind/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStopOrder(t *testing.T) {
+	containers := []types.Container{
+		{ID: "primary", Labels: map[string]string{internal.NodeRoleLabel: internal.NodeRolePrimary}},
+		{ID: "manager", Labels: map[string]string{internal.NodeRoleLabel: internal.NodeRoleManager}},
+		{ID: "worker", Labels: map[string]string{internal.NodeRoleLabel: internal.NodeRoleWorker}},
+		{ID: "lb", Labels: map[string]string{internal.ClusterComponentLabel: internal.ComponentLoadBalancer}},
+	}
+
+	groups := stopOrder(containers)
+
+	ids := make([][]string, len(groups))
+	for i, group := range groups {
+		for _, container := range group {
+			ids[i] = append(ids[i], container.ID)
+		}
+	}
+
+	assert.Equal(t, [][]string{{"worker", "lb"}, {"manager"}, {"primary"}}, ids)
+}
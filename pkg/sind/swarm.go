@@ -0,0 +1,105 @@
+package sind
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/docker/docker/api/types/swarm"
+)
+
+// SwarmOptions are the settings used to initialize the swarm of a cluster.
+// Zero values keep the swarm defaults.
+type SwarmOptions struct {
+	// DefaultAddrPool are the subnets, in CIDR notation, the overlay networks subnets are allocated from.
+	DefaultAddrPool []string
+	// SubnetSize is the prefix length of the overlay networks subnets allocated from DefaultAddrPool.
+	SubnetSize uint32
+	// DataPathPort is the port used for the overlay networks data traffic.
+	DataPathPort uint32
+
+	// AutoLock requires the managers to be unlocked with the unlock key after a restart.
+	// The key is stored with the cluster, StartCluster unlocks the managers and ClusterUnlockKey returns it.
+	// The stored key is refreshed by ClusterUnlockKey and before the cluster stops, to follow key rotations.
+	AutoLock bool
+
+	// TaskHistoryLimit is the amount of tasks kept in history for each service, nil keeps the default.
+	TaskHistoryLimit *int64
+	// DispatcherHeartbeat is the period of the heartbeats of the nodes to the managers.
+	DispatcherHeartbeat time.Duration
+	// NodeCertExpiry is the validity duration of the node certificates.
+	NodeCertExpiry time.Duration
+	// SnapshotInterval is the amount of log entries between raft snapshots.
+	SnapshotInterval uint64
+	// ExternalCAs are the certificate authorities issuing the node certificates.
+	ExternalCAs []ExternalCA
+}
+
+// ExternalCA is a certificate authority issuing the node certificates of a swarm.
+type ExternalCA struct {
+	// Protocol is the protocol used to reach the CA, only cfssl is supported by swarm.
+	Protocol string
+	URL      string
+	Options  map[string]string
+	// CACert is the PEM encoded root CA certificate used by the external CA.
+	CACert string
+}
+
+func (s *SwarmOptions) validate() error {
+	for _, pool := range s.DefaultAddrPool {
+		if _, _, err := net.ParseCIDR(pool); err != nil {
+			return &configError{field: "swarm.defaultAddrPool", msg: fmt.Sprintf("invalid default address pool: %v", err)}
+		}
+	}
+
+	if s.SubnetSize != 0 && len(s.DefaultAddrPool) == 0 {
+		return &configError{field: "swarm.subnetSize", msg: "subnet size requires a default address pool"}
+	}
+
+	if s.DataPathPort != 0 && (s.DataPathPort < 1024 || s.DataPathPort > 49151) {
+		return &configError{field: "swarm.dataPathPort", msg: fmt.Sprintf("invalid data path port %d, must be within 1024-49151", s.DataPathPort)}
+	}
+
+	if s.TaskHistoryLimit != nil && *s.TaskHistoryLimit < 0 {
+		return &configError{field: "swarm.taskHistoryLimit", msg: "task history limit must be >= 0"}
+	}
+
+	for _, ca := range s.ExternalCAs {
+		if ca.Protocol != string(swarm.ExternalCAProtocolCFSSL) {
+			return &configError{field: "swarm.externalCAs", msg: fmt.Sprintf("unsupported external CA protocol %q", ca.Protocol)}
+		}
+
+		if ca.URL == "" {
+			return &configError{field: "swarm.externalCAs", msg: "external CA URL is required"}
+		}
+	}
+
+	return nil
+}
+
+// initRequest returns the request initializing a swarm with the options, listening on given address.
+func (s *SwarmOptions) initRequest(listenAddr string) swarm.InitRequest {
+	req := swarm.InitRequest{
+		ListenAddr:       listenAddr,
+		DataPathPort:     s.DataPathPort,
+		AutoLockManagers: s.AutoLock,
+		DefaultAddrPool:  s.DefaultAddrPool,
+		SubnetSize:       s.SubnetSize,
+	}
+
+	req.Spec.Orchestration.TaskHistoryRetentionLimit = s.TaskHistoryLimit
+	req.Spec.Dispatcher.HeartbeatPeriod = s.DispatcherHeartbeat
+	req.Spec.CAConfig.NodeCertExpiry = s.NodeCertExpiry
+	req.Spec.Raft.SnapshotInterval = s.SnapshotInterval
+
+	for _, ca := range s.ExternalCAs {
+		req.Spec.CAConfig.ExternalCAs = append(req.Spec.CAConfig.ExternalCAs, &swarm.ExternalCA{
+			Protocol: swarm.ExternalCAProtocol(ca.Protocol),
+			URL:      ca.URL,
+			Options:  ca.Options,
+			CACert:   ca.CACert,
+		})
+	}
+
+	return req
+}
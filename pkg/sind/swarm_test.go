@@ -0,0 +1,105 @@
+package sind
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwarmOptionsValidate(t *testing.T) {
+	negative := int64(-1)
+
+	testCases := []struct {
+		desc          string
+		opts          SwarmOptions
+		expectedError string
+	}{
+		{
+			desc: "with defaults",
+		},
+		{
+			desc: "with valid options",
+			opts: SwarmOptions{
+				DefaultAddrPool: []string{"10.20.0.0/16"},
+				SubnetSize:      26,
+				DataPathPort:    7789,
+				ExternalCAs:     []ExternalCA{{Protocol: "cfssl", URL: "https://ca.example.com"}},
+			},
+		},
+		{
+			desc:          "with an invalid default address pool",
+			opts:          SwarmOptions{DefaultAddrPool: []string{"10.20.0.0"}},
+			expectedError: "invalid default address pool: invalid CIDR address: 10.20.0.0",
+		},
+		{
+			desc:          "with a subnet size and no default address pool",
+			opts:          SwarmOptions{SubnetSize: 26},
+			expectedError: "subnet size requires a default address pool",
+		},
+		{
+			desc:          "with a data path port out of range",
+			opts:          SwarmOptions{DataPathPort: 80},
+			expectedError: "invalid data path port 80, must be within 1024-49151",
+		},
+		{
+			desc:          "with a negative task history limit",
+			opts:          SwarmOptions{TaskHistoryLimit: &negative},
+			expectedError: "task history limit must be >= 0",
+		},
+		{
+			desc:          "with an external CA without URL",
+			opts:          SwarmOptions{ExternalCAs: []ExternalCA{{Protocol: "cfssl"}}},
+			expectedError: "external CA URL is required",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			err := test.opts.validate()
+			if test.expectedError != "" {
+				require.Error(t, err)
+				assert.Equal(t, test.expectedError, err.Error())
+				return
+			}
+
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestSwarmOptionsInitRequest(t *testing.T) {
+	limit := int64(2)
+
+	opts := SwarmOptions{
+		DefaultAddrPool:     []string{"10.20.0.0/16"},
+		SubnetSize:          26,
+		DataPathPort:        7789,
+		AutoLock:            true,
+		TaskHistoryLimit:    &limit,
+		DispatcherHeartbeat: 10 * time.Second,
+		NodeCertExpiry:      time.Hour,
+		SnapshotInterval:    5000,
+		ExternalCAs:         []ExternalCA{{Protocol: "cfssl", URL: "https://ca.example.com", CACert: "cert"}},
+	}
+
+	expected := swarm.InitRequest{
+		ListenAddr:       "0.0.0.0:2377",
+		DataPathPort:     7789,
+		AutoLockManagers: true,
+		DefaultAddrPool:  []string{"10.20.0.0/16"},
+		SubnetSize:       26,
+	}
+	expected.Spec.Orchestration.TaskHistoryRetentionLimit = &limit
+	expected.Spec.Dispatcher.HeartbeatPeriod = 10 * time.Second
+	expected.Spec.CAConfig.NodeCertExpiry = time.Hour
+	expected.Spec.CAConfig.ExternalCAs = []*swarm.ExternalCA{
+		{Protocol: swarm.ExternalCAProtocolCFSSL, URL: "https://ca.example.com", CACert: "cert"},
+	}
+	expected.Spec.Raft.SnapshotInterval = 5000
+
+	assert.Equal(t, expected, opts.initRequest("0.0.0.0:2377"))
+	assert.Equal(t, swarm.InitRequest{ListenAddr: "0.0.0.0:2377"}, (&SwarmOptions{}).initRequest("0.0.0.0:2377"))
+}
@@ -0,0 +1,111 @@
+package sind
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	docker "github.com/docker/docker/client"
+	"github.com/golang/sync/errgroup"
+	"github.com/jlevesy/sind/pkg/sind/internal"
+)
+
+// unlockKeyPath is the path of the file holding the swarm unlock key of an autolocked cluster, in the primary node container.
+const unlockKeyPath = "/etc/sind-unlock-key"
+
+// ClusterUnlockKey returns the key unlocking the managers of an autolocked cluster.
+// It returns an empty key if the cluster is not autolocked. The cluster does not need to be running.
+// If the swarm answers, the stored key is refreshed with its current key, which changes when it is rotated.
+func ClusterUnlockKey(ctx context.Context, hostClient *docker.Client, clusterName string) (string, error) {
+	primaryNode, err := internal.PrimaryContainer(ctx, hostClient, clusterName)
+	if err != nil {
+		return "", fmt.Errorf("unable to get the primary node informations: %v", err)
+	}
+
+	content, err := internal.ReadContainerFile(ctx, hostClient, primaryNode.ID, unlockKeyPath)
+	if err != nil {
+		return "", fmt.Errorf("unable to read the unlock key: %v", err)
+	}
+
+	key := strings.TrimSpace(string(content))
+
+	current, ok := currentUnlockKey(ctx, hostClient, clusterName)
+	if !ok || current == key {
+		return key, nil
+	}
+
+	if err = internal.WriteContainerFile(ctx, hostClient, primaryNode.ID, unlockKeyPath, []byte(current)); err != nil {
+		return "", fmt.Errorf("unable to store the unlock key: %v", err)
+	}
+
+	return current, nil
+}
+
+// currentUnlockKey returns the current unlock key of the swarm of a cluster, and false if the swarm does not answer,
+// which is the case when the cluster is stopped or its managers are locked.
+func currentUnlockKey(ctx context.Context, hostClient *docker.Client, clusterName string) (string, bool) {
+	swarmClient, err := newSwarmClient(ctx, hostClient, clusterName)
+	if err != nil {
+		return "", false
+	}
+	defer swarmClient.Close()
+
+	resp, err := swarmClient.SwarmGetUnlockKey(ctx)
+	if err != nil {
+		return "", false
+	}
+
+	return resp.UnlockKey, true
+}
+
+// storeUnlockKey stores the current unlock key of the swarm in the primary node container, to unlock the managers after a restart.
+func storeUnlockKey(ctx context.Context, hostClient, swarmClient *docker.Client, primaryID string) error {
+	resp, err := swarmClient.SwarmGetUnlockKey(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to get the unlock key: %v", err)
+	}
+
+	if err = internal.WriteContainerFile(ctx, hostClient, primaryID, unlockKeyPath, []byte(resp.UnlockKey)); err != nil {
+		return fmt.Errorf("unable to store the unlock key: %v", err)
+	}
+
+	return nil
+}
+
+// unlockManagers waits for the daemon of each given node to answer, then unlocks the managers which are locked.
+func unlockManagers(ctx context.Context, hostClient *docker.Client, nodes []types.Container, key string) error {
+	errg, groupCtx := errgroup.WithContext(ctx)
+
+	for _, node := range nodes {
+		if node.Labels[internal.NodeRoleLabel] == internal.NodeRoleWorker {
+			continue
+		}
+
+		cID := node.ID
+
+		errg.Go(func() error {
+			if err := internal.WaitNodeDaemonReady(groupCtx, hostClient, cID); err != nil {
+				return fmt.Errorf("daemon of node %q is not ready: %v", cID, err)
+			}
+
+			state, err := internal.LocalSwarmState(groupCtx, hostClient, cID)
+			if err != nil {
+				return fmt.Errorf("unable to get the swarm state of node %q: %w", cID, err)
+			}
+
+			if state != swarm.LocalNodeStateLocked {
+				return nil
+			}
+
+			if err = internal.UnlockSwarm(groupCtx, hostClient, cID, key); err != nil {
+				return fmt.Errorf("unable to unlock node %q: %w", cID, err)
+			}
+
+			return nil
+		})
+	}
+
+	return errg.Wait()
+}
@@ -0,0 +1,182 @@
+package sind
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/swarm"
+	docker "github.com/docker/docker/client"
+	"github.com/jlevesy/sind/pkg/sind/internal"
+)
+
+// UpgradeCluster replaces the nodes of a running cluster one by one by nodes running given image.
+// Each node is drained, leaves the swarm, and is recreated with the same name, addresses and role before joining the swarm again.
+// The swarm labels and availability of the nodes are kept, and the next node is upgraded once the swarm converged.
+// Workers are upgraded first, then managers. The primary node, which is the entry point of the cluster, is upgraded last:
+// it is drained then recreated in place, keeping its swarm state.
+func UpgradeCluster(ctx context.Context, hostClient *docker.Client, clusterName, imageName string) error {
+	imageExists, err := internal.ImageExists(ctx, hostClient, imageName)
+	if err != nil {
+		return fmt.Errorf("unable to check node image existence: %v", err)
+	}
+
+	if !imageExists {
+		if err = internal.PullImage(ctx, hostClient, imageName); err != nil {
+			return fmt.Errorf("unable to pull the %s image: %v", imageName, err)
+		}
+	}
+
+	nodes, err := listClusterNodes(ctx, hostClient, clusterName)
+	if err != nil {
+		return err
+	}
+
+	swarmClient, err := newSwarmClient(ctx, hostClient, clusterName)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range append(nodes.workers, nodes.managers...) {
+		if err = upgradeNode(ctx, hostClient, swarmClient, clusterName, node, imageName); err != nil {
+			return err
+		}
+	}
+
+	return upgradePrimary(ctx, hostClient, swarmClient, clusterName, *nodes.primary, imageName)
+}
+
+// upgradeNode replaces a manager or worker node by a node running given image, and waits for it to join the swarm.
+func upgradeNode(ctx context.Context, hostClient, swarmClient *docker.Client, clusterName string, node types.Container, imageName string) error {
+	role := node.Labels[internal.NodeRoleLabel]
+	hostname := internal.ContainerName(clusterName, internal.ContainerNodeName(clusterName, node))
+
+	previous, err := leaveSwarm(ctx, hostClient, swarmClient, hostname, node.ID)
+	if err != nil {
+		return err
+	}
+
+	cID, err := internal.RecreateContainer(ctx, hostClient, node.ID, withImage(imageName))
+	if err != nil {
+		return fmt.Errorf("unable to recreate node %q: %v", hostname, err)
+	}
+
+	var ids internal.NodeIDs
+
+	if role == internal.NodeRoleManager {
+		ids.Managers = []string{cID}
+	} else {
+		ids.Workers = []string{cID}
+	}
+
+	if err = joinSwarm(ctx, hostClient, swarmClient, clusterName, ids); err != nil {
+		return fmt.Errorf("unable to join node %q to the swarm: %v", hostname, err)
+	}
+
+	if err = internal.WaitSwarmConverged(ctx, swarmClient, map[string]string{hostname: role}); err != nil {
+		return err
+	}
+
+	if previous == nil {
+		return nil
+	}
+
+	return restoreSwarmNode(ctx, swarmClient, hostname, *previous)
+}
+
+// upgradePrimary recreates the primary node with given image, keeping its swarm state, and waits for it to be healthy.
+func upgradePrimary(ctx context.Context, hostClient, swarmClient *docker.Client, clusterName string, primary types.Container, imageName string) error {
+	hostname := internal.ContainerName(clusterName, internal.ContainerNodeName(clusterName, primary))
+
+	key, err := ClusterUnlockKey(ctx, hostClient, clusterName)
+	if err != nil {
+		return err
+	}
+
+	previous, err := internal.SwarmNode(ctx, swarmClient, hostname)
+	if err != nil {
+		return err
+	}
+
+	if previous != nil {
+		err = internal.UpdateSwarmNode(ctx, swarmClient, previous.ID, func(spec *swarm.NodeSpec) {
+			spec.Availability = swarm.NodeAvailabilityDrain
+		})
+		if err != nil {
+			return fmt.Errorf("unable to drain node %q: %v", hostname, err)
+		}
+
+		if err = internal.WaitTasksStopped(ctx, swarmClient, []string{previous.ID}); err != nil {
+			return fmt.Errorf("unable to wait for the tasks of node %q to stop: %v", hostname, err)
+		}
+	}
+
+	cID, err := internal.RecreateContainer(ctx, hostClient, primary.ID, withImage(imageName))
+	if err != nil {
+		return fmt.Errorf("unable to recreate the primary node: %v", err)
+	}
+
+	// The unlock key is stored in the container, it has to be stored again in the new one.
+	if key != "" {
+		if err = internal.WriteContainerFile(ctx, hostClient, cID, unlockKeyPath, []byte(key)); err != nil {
+			return fmt.Errorf("unable to store the unlock key: %v", err)
+		}
+
+		newPrimary := types.Container{ID: cID, Labels: primary.Labels}
+		if err = unlockManagers(ctx, hostClient, []types.Container{newPrimary}, key); err != nil {
+			return fmt.Errorf("unable to unlock the primary node: %w", err)
+		}
+	}
+
+	// The port of the daemon of the new primary node is not the same.
+	if swarmClient, err = newSwarmClient(ctx, hostClient, clusterName); err != nil {
+		return err
+	}
+
+	if err = internal.WaitDaemonReady(ctx, swarmClient); err != nil {
+		return fmt.Errorf("unable to contact the primary node daemon: %v", err)
+	}
+
+	if err = internal.WaitSwarmHealthy(ctx, swarmClient, map[string]string{hostname: internal.NodeRolePrimary}); err != nil {
+		return err
+	}
+
+	if previous == nil {
+		return nil
+	}
+
+	return restoreSwarmNode(ctx, swarmClient, hostname, *previous)
+}
+
+// withImage returns a container configuration update setting its image.
+func withImage(imageName string) func(*container.Config, *container.HostConfig) {
+	return func(cfg *container.Config, _ *container.HostConfig) {
+		cfg.Image = imageName
+	}
+}
+
+// withRole returns a container configuration update setting its node role label, and publishing its daemon accordingly.
+func withRole(role string) func(*container.Config, *container.HostConfig) {
+	return func(cfg *container.Config, hostCfg *container.HostConfig) {
+		internal.ApplyNodeRole(role, cfg, hostCfg)
+	}
+}
+
+// restoreSwarmNode gives back to the swarm node of given hostname the labels and availability of a previous node.
+func restoreSwarmNode(ctx context.Context, swarmClient *docker.Client, hostname string, previous swarm.Node) error {
+	node, err := internal.WaitSwarmNode(ctx, swarmClient, hostname)
+	if err != nil {
+		return fmt.Errorf("node %q did not join the swarm: %v", hostname, err)
+	}
+
+	err = internal.UpdateSwarmNode(ctx, swarmClient, node.ID, func(spec *swarm.NodeSpec) {
+		spec.Labels = previous.Spec.Labels
+		spec.Availability = previous.Spec.Availability
+	})
+	if err != nil {
+		return fmt.Errorf("unable to restore node %q: %v", hostname, err)
+	}
+
+	return nil
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
 	docker "github.com/docker/docker/client"
 	"github.com/jlevesy/sind/pkg/sind"
 	"github.com/stretchr/testify/assert"
@@ -52,6 +54,24 @@ func TestSindCanCreateACluster(t *testing.T) {
 
 	assert.EqualValues(t, params.Workers, clusterInfos.Workers)
 	assert.EqualValues(t, params.Workers, clusterInfos.WorkersRunning)
+
+	clusterInfos, err = sind.InspectClusterWithSwarm(ctx, hostClient, params.ClusterName)
+	require.NoError(t, err)
+
+	assert.Equal(t, sind.ClusterStateRunning, clusterInfos.State())
+	require.Len(t, clusterInfos.Swarm, int(params.Managers+params.Workers))
+
+	var leaders int
+
+	for _, node := range clusterInfos.Swarm {
+		assert.Equal(t, "ready", node.State)
+
+		if node.Leader {
+			leaders++
+		}
+	}
+
+	assert.Equal(t, 1, leaders)
 }
 
 func TestSindCanCreateMultipleClusters(t *testing.T) {
@@ -75,3 +95,104 @@ func TestSindCanCreateMultipleClusters(t *testing.T) {
 		}()
 	}
 }
+
+func TestSindRemovesClusterResourcesOnCreationFailure(t *testing.T) {
+	ctx := context.Background()
+
+	hostClient, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	params := sind.ClusterConfiguration{
+		ClusterName: "test_create_rollback",
+		NetworkName: "test_create_rollback",
+
+		Managers: 1,
+		Workers:  1,
+
+		// Ports are parsed after the cluster network is created.
+		PortBindings: []string{"notaport"},
+	}
+	require.Error(t, sind.CreateCluster(ctx, hostClient, params))
+
+	clusterInfos, err := sind.InspectCluster(ctx, hostClient, params.ClusterName)
+	require.NoError(t, err)
+	assert.Nil(t, clusterInfos)
+
+	networks, err := hostClient.NetworkList(ctx, types.NetworkListOptions{Filters: filters.NewArgs(filters.Arg("name", params.NetworkName))})
+	require.NoError(t, err)
+	assert.Empty(t, networks)
+}
+
+func TestSindCanCreateAClusterWithALoadBalancer(t *testing.T) {
+	ctx := context.Background()
+
+	hostClient, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	params := sind.ClusterConfiguration{
+		ClusterName: "test_create_lb",
+		NetworkName: "test_create_lb",
+
+		Managers: 2,
+		Workers:  1,
+
+		PortBindings: []string{"18080:8080"},
+		LoadBalancer: true,
+	}
+	require.NoError(t, sind.CreateCluster(ctx, hostClient, params))
+
+	lbFilter := filters.NewArgs(filters.Arg("name", "sind-test_create_lb-lb"))
+
+	lbs, err := hostClient.ContainerList(ctx, types.ContainerListOptions{Filters: lbFilter})
+	require.NoError(t, err)
+	require.Len(t, lbs, 1)
+	assert.EqualValues(t, 18080, lbs[0].Ports[0].PublicPort)
+
+	// The load balancer is not a node of the cluster.
+	clusterInfos, err := sind.InspectCluster(ctx, hostClient, params.ClusterName)
+	require.NoError(t, err)
+
+	assert.EqualValues(t, params.Managers, clusterInfos.Managers)
+	assert.EqualValues(t, params.Workers, clusterInfos.Workers)
+
+	require.NoError(t, sind.DeleteCluster(ctx, hostClient, params.ClusterName))
+
+	lbs, err = hostClient.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: lbFilter})
+	require.NoError(t, err)
+	assert.Empty(t, lbs)
+}
+
+func TestSindCanCreateADualStackCluster(t *testing.T) {
+	ctx := context.Background()
+
+	hostClient, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	params := sind.ClusterConfiguration{
+		ClusterName: "test_create_ipv6",
+		NetworkName: "test_create_ipv6",
+		IPv6Subnet:  "fd00:5e1d::/64",
+
+		Managers: 2,
+		Workers:  1,
+	}
+	require.NoError(t, sind.CreateCluster(ctx, hostClient, params))
+
+	defer func() {
+		require.NoError(t, sind.DeleteCluster(ctx, hostClient, params.ClusterName))
+	}()
+
+	swarmHost, err := sind.ClusterHost(ctx, hostClient, params.ClusterName)
+	require.NoError(t, err)
+
+	swarmClient, err := docker.NewClientWithOpts(docker.WithHost(swarmHost), docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	nodes, err := swarmClient.NodeList(ctx, types.NodeListOptions{})
+	require.NoError(t, err)
+	require.Len(t, nodes, 3)
+
+	for _, node := range nodes {
+		assert.Contains(t, node.Status.Addr, "fd00:5e1d:")
+	}
+}
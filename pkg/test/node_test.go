@@ -0,0 +1,262 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types/swarm"
+	docker "github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+	"github.com/jlevesy/sind/pkg/sind"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSindCanAddAndRemoveANode(t *testing.T) {
+	ctx := context.Background()
+
+	hostClient, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	params := sind.ClusterConfiguration{
+		ClusterName: "test_node",
+		NetworkName: "test_node",
+
+		Managers: 1,
+		Workers:  1,
+	}
+	require.NoError(t, sind.CreateCluster(ctx, hostClient, params))
+
+	defer func() {
+		require.NoError(t, sind.DeleteCluster(ctx, hostClient, params.ClusterName))
+	}()
+
+	nodeName, err := sind.AddNode(
+		ctx,
+		hostClient,
+		params.ClusterName,
+		sind.NodeRoleWorker,
+		sind.NodeConfiguration{
+			Labels:       map[string]string{"zone": "a"},
+			EngineLabels: map[string]string{"disk": "ssd"},
+		},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "worker-1", nodeName)
+
+	swarmHost, err := sind.ClusterHost(ctx, hostClient, params.ClusterName)
+	require.NoError(t, err)
+
+	swarmClient, err := docker.NewClientWithOpts(docker.WithHost(swarmHost), docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	node, _, err := swarmClient.NodeInspectWithRaw(ctx, "sind-test_node-worker-1")
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{"zone": "a"}, node.Spec.Labels)
+	assert.Equal(t, map[string]string{"disk": "ssd"}, node.Description.Engine.Labels)
+
+	require.NoError(t, sind.RemoveNode(ctx, hostClient, params.ClusterName, nodeName))
+
+	clusterInfos, err := sind.InspectCluster(ctx, hostClient, params.ClusterName)
+	require.NoError(t, err)
+
+	assert.EqualValues(t, 1, clusterInfos.Workers)
+
+	info, err := swarmClient.Info(ctx)
+	require.NoError(t, err)
+
+	assert.EqualValues(t, 2, info.Swarm.Nodes)
+
+	assert.Error(t, sind.RemoveNode(ctx, hostClient, params.ClusterName, "manager-0"))
+}
+
+func TestSindAddsNodesWithoutTheOverridesOfThePrimaryNode(t *testing.T) {
+	ctx := context.Background()
+
+	hostClient, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	params := sind.ClusterConfiguration{
+		ClusterName: "test_node_args",
+		NetworkName: "test_node_args",
+
+		Managers:   1,
+		DaemonArgs: []string{"--label=cluster=test"},
+
+		Nodes: map[string]sind.NodeConfiguration{
+			"manager-0": {EngineLabels: map[string]string{"zone": "a"}},
+		},
+	}
+	require.NoError(t, sind.CreateCluster(ctx, hostClient, params))
+
+	defer func() {
+		require.NoError(t, sind.DeleteCluster(ctx, hostClient, params.ClusterName))
+	}()
+
+	nodeName, err := sind.AddNode(ctx, hostClient, params.ClusterName, sind.NodeRoleWorker, sind.NodeConfiguration{})
+	require.NoError(t, err)
+
+	swarmHost, err := sind.ClusterHost(ctx, hostClient, params.ClusterName)
+	require.NoError(t, err)
+
+	swarmClient, err := docker.NewClientWithOpts(docker.WithHost(swarmHost), docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	primary, _, err := swarmClient.NodeInspectWithRaw(ctx, "sind-test_node_args-manager-0")
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{"cluster": "test", "zone": "a"}, primary.Description.Engine.Labels)
+
+	node, _, err := swarmClient.NodeInspectWithRaw(ctx, "sind-test_node_args-"+nodeName)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{"cluster": "test"}, node.Description.Engine.Labels)
+}
+
+func TestSindCanPromoteDemoteAndDrainANode(t *testing.T) {
+	ctx := context.Background()
+
+	hostClient, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	params := sind.ClusterConfiguration{
+		ClusterName: "test_node_role",
+		NetworkName: "test_node_role",
+
+		Managers: 1,
+		Workers:  2,
+	}
+	require.NoError(t, sind.CreateCluster(ctx, hostClient, params))
+
+	defer func() {
+		require.NoError(t, sind.DeleteCluster(ctx, hostClient, params.ClusterName))
+	}()
+
+	require.NoError(t, sind.PromoteNode(ctx, hostClient, params.ClusterName, "worker-0"))
+
+	clusterInfos, err := sind.InspectCluster(ctx, hostClient, params.ClusterName)
+	require.NoError(t, err)
+
+	assert.EqualValues(t, 2, clusterInfos.Managers)
+	assert.EqualValues(t, 1, clusterInfos.Workers)
+
+	swarmHost, err := sind.ClusterHost(ctx, hostClient, params.ClusterName)
+	require.NoError(t, err)
+
+	swarmClient, err := docker.NewClientWithOpts(docker.WithHost(swarmHost), docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	info, err := swarmClient.Info(ctx)
+	require.NoError(t, err)
+
+	assert.EqualValues(t, 2, info.Swarm.Managers)
+
+	require.NoError(t, sind.DemoteNode(ctx, hostClient, params.ClusterName, "worker-0"))
+
+	clusterInfos, err = sind.InspectCluster(ctx, hostClient, params.ClusterName)
+	require.NoError(t, err)
+
+	assert.EqualValues(t, 1, clusterInfos.Managers)
+	assert.EqualValues(t, 2, clusterInfos.Workers)
+
+	require.NoError(t, sind.SetNodeAvailability(ctx, hostClient, params.ClusterName, "worker-1", swarm.NodeAvailabilityDrain))
+
+	node, _, err := swarmClient.NodeInspectWithRaw(ctx, "sind-test_node_role-worker-1")
+	require.NoError(t, err)
+
+	assert.Equal(t, swarm.NodeAvailabilityDrain, node.Spec.Availability)
+
+	assert.Error(t, sind.DemoteNode(ctx, hostClient, params.ClusterName, "manager-0"))
+}
+
+func TestSindPublishesTheDaemonOfPromotedNodesOnly(t *testing.T) {
+	ctx := context.Background()
+
+	hostClient, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	params := sind.ClusterConfiguration{
+		ClusterName: "test_node_publish",
+		NetworkName: "test_node_publish",
+
+		Managers: 1,
+		Workers:  1,
+	}
+	require.NoError(t, sind.CreateCluster(ctx, hostClient, params))
+
+	defer func() {
+		require.NoError(t, sind.DeleteCluster(ctx, hostClient, params.ClusterName))
+	}()
+
+	daemonBindings := func() []nat.PortBinding {
+		info, err := hostClient.ContainerInspect(ctx, "sind-test_node_publish-worker-0")
+		require.NoError(t, err)
+
+		return info.NetworkSettings.Ports["2375/tcp"]
+	}
+
+	require.NoError(t, sind.PromoteNode(ctx, hostClient, params.ClusterName, "worker-0"))
+	assert.NotEmpty(t, daemonBindings())
+
+	require.NoError(t, sind.DemoteNode(ctx, hostClient, params.ClusterName, "worker-0"))
+	assert.Empty(t, daemonBindings())
+}
+
+func TestSindFallsBackToAManagerAndElectsANewPrimary(t *testing.T) {
+	ctx := context.Background()
+
+	hostClient, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	params := sind.ClusterConfiguration{
+		ClusterName: "test_node_elect",
+		NetworkName: "test_node_elect",
+
+		Managers: 3,
+		Workers:  1,
+	}
+	require.NoError(t, sind.CreateCluster(ctx, hostClient, params))
+
+	defer func() {
+		require.NoError(t, sind.DeleteCluster(ctx, hostClient, params.ClusterName))
+	}()
+
+	primaryHost, err := sind.ClusterHost(ctx, hostClient, params.ClusterName)
+	require.NoError(t, err)
+
+	require.NoError(t, hostClient.ContainerStop(ctx, "sind-test_node_elect-manager-0", nil))
+
+	swarmHost, err := sind.ClusterHost(ctx, hostClient, params.ClusterName)
+	require.NoError(t, err)
+	assert.NotEqual(t, primaryHost, swarmHost)
+
+	swarmClient, err := docker.NewClientWithOpts(docker.WithHost(swarmHost), docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	info, err := swarmClient.Info(ctx)
+	require.NoError(t, err)
+	assert.True(t, info.Swarm.ControlAvailable)
+
+	nodeName, err := sind.ElectPrimary(ctx, hostClient, params.ClusterName, "")
+	require.NoError(t, err)
+	assert.Equal(t, "manager-1", nodeName)
+
+	clusterInfos, err := sind.InspectCluster(ctx, hostClient, params.ClusterName)
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, clusterInfos.Managers)
+	assert.EqualValues(t, 2, clusterInfos.ManagersRunning)
+
+	for _, node := range clusterInfos.Nodes {
+		if node.Names[0] == "/sind-test_node_elect-manager-1" {
+			assert.Equal(t, "primary", node.Labels["com.sind.cluster.role"])
+		}
+
+		if node.Names[0] == "/sind-test_node_elect-manager-0" {
+			assert.Equal(t, "manager", node.Labels["com.sind.cluster.role"])
+		}
+	}
+
+	_, err = sind.AddNode(ctx, hostClient, params.ClusterName, sind.NodeRoleWorker, sind.NodeConfiguration{})
+	require.NoError(t, err)
+}
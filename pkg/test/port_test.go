@@ -0,0 +1,96 @@
+package test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	docker "github.com/docker/docker/client"
+	"github.com/jlevesy/sind/pkg/sind"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSindCanPublishPortsOnARunningCluster(t *testing.T) {
+	ctx := context.Background()
+
+	hostClient, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	params := sind.ClusterConfiguration{
+		ClusterName: "test_port",
+		NetworkName: "test_port",
+
+		Managers: 1,
+		Workers:  1,
+	}
+	require.NoError(t, sind.CreateCluster(ctx, hostClient, params))
+
+	defer func() {
+		require.NoError(t, sind.DeleteCluster(ctx, hostClient, params.ClusterName))
+	}()
+
+	require.NoError(t, sind.AddPort(ctx, hostClient, params.ClusterName, "19090:9090"))
+	require.NoError(t, sind.AddPort(ctx, hostClient, params.ClusterName, "19091:9091/udp"))
+	assert.Error(t, sind.AddPort(ctx, hostClient, params.ClusterName, "19090:9092"))
+
+	ports, err := sind.ListPorts(ctx, hostClient, params.ClusterName)
+	require.NoError(t, err)
+	require.Len(t, ports, 2)
+	assert.Equal(t, "19090:9090", ports[0].Binding)
+	assert.Equal(t, "running", ports[0].State)
+	assert.Equal(t, "19091:9091/udp", ports[1].Binding)
+
+	require.NoError(t, sind.RemovePort(ctx, hostClient, params.ClusterName, "19090:9090"))
+
+	ports, err = sind.ListPorts(ctx, hostClient, params.ClusterName)
+	require.NoError(t, err)
+	require.Len(t, ports, 1)
+	assert.Equal(t, "19091:9091/udp", ports[0].Binding)
+}
+
+func TestSindUpdatesThePortProxiesWhenPromotingANode(t *testing.T) {
+	ctx := context.Background()
+
+	hostClient, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	params := sind.ClusterConfiguration{
+		ClusterName: "test_port_promote",
+		NetworkName: "test_port_promote",
+
+		Managers: 1,
+		Workers:  1,
+	}
+	require.NoError(t, sind.CreateCluster(ctx, hostClient, params))
+
+	defer func() {
+		require.NoError(t, sind.DeleteCluster(ctx, hostClient, params.ClusterName))
+	}()
+
+	require.NoError(t, sind.AddPort(ctx, hostClient, params.ClusterName, "18082:8080"))
+
+	upstreams := func() int {
+		proxy, err := hostClient.ContainerInspect(ctx, "sind-test_port_promote-port-18082-tcp")
+		require.NoError(t, err)
+
+		for _, env := range proxy.Config.Env {
+			if strings.HasPrefix(env, "NGINX_CONFIG=") {
+				return strings.Count(env, "server 10.")
+			}
+		}
+
+		return 0
+	}
+
+	assert.Equal(t, 1, upstreams())
+
+	require.NoError(t, sind.PromoteNode(ctx, hostClient, params.ClusterName, "worker-0"))
+	assert.Equal(t, 2, upstreams())
+
+	ports, err := sind.ListPorts(ctx, hostClient, params.ClusterName)
+	require.NoError(t, err)
+	require.Len(t, ports, 1)
+	assert.Equal(t, "18082:8080", ports[0].Binding)
+	assert.Equal(t, "running", ports[0].State)
+}
@@ -62,3 +62,104 @@ func TestSindCanPushAnImageToClusterFromRefs(t *testing.T) {
 
 	assert.Equal(t, img.RepoTags[0], tag)
 }
+
+func TestSindSkipsNodesHavingTheImage(t *testing.T) {
+	ctx := context.Background()
+	tag := "alpine:latest"
+
+	hostClient, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	params := sind.ClusterConfiguration{
+		ClusterName: "test_push_skip",
+		NetworkName: "test_push_skip",
+
+		Managers: 1,
+		Workers:  2,
+	}
+
+	require.NoError(t, sind.CreateCluster(ctx, hostClient, params))
+
+	defer func() {
+		require.NoError(t, sind.DeleteCluster(ctx, hostClient, params.ClusterName))
+	}()
+
+	out, err := hostClient.ImagePull(ctx, tag, types.ImagePullOptions{})
+	require.NoError(t, err)
+
+	defer out.Close()
+
+	_, err = io.Copy(ioutil.Discard, out)
+	require.NoError(t, err)
+
+	result, err := sind.PushImageRefsWithOptions(ctx, hostClient, params.ClusterName, sind.PushOptions{Jobs: 1}, []string{tag})
+	require.NoError(t, err)
+	assert.Empty(t, result.Skipped)
+
+	// The nodes have the image now, pushing it again is a no-op.
+	result, err = sind.PushImageRefsWithOptions(ctx, hostClient, params.ClusterName, sind.PushOptions{Jobs: 1}, []string{tag})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"manager-0", "worker-0", "worker-1"}, result.Skipped)
+}
+
+func TestSindCanPushAnImageToASubsetOfNodes(t *testing.T) {
+	ctx := context.Background()
+	tag := "alpine:latest"
+
+	hostClient, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	params := sind.ClusterConfiguration{
+		ClusterName: "test_push_subset",
+		NetworkName: "test_push_subset",
+
+		Managers: 1,
+		Workers:  2,
+	}
+
+	require.NoError(t, sind.CreateCluster(ctx, hostClient, params))
+
+	defer func() {
+		require.NoError(t, sind.DeleteCluster(ctx, hostClient, params.ClusterName))
+	}()
+
+	out, err := hostClient.ImagePull(ctx, tag, types.ImagePullOptions{})
+	require.NoError(t, err)
+
+	defer out.Close()
+
+	_, err = io.Copy(ioutil.Discard, out)
+	require.NoError(t, err)
+
+	_, err = sind.PushImageRefsWithOptions(ctx, hostClient, params.ClusterName, sind.PushOptions{Roles: []string{sind.NodeRoleWorker}}, []string{tag})
+	require.NoError(t, err)
+
+	swarmHost, err := sind.ClusterHost(ctx, hostClient, params.ClusterName)
+	require.NoError(t, err)
+
+	swarmClient, err := docker.NewClientWithOpts(docker.WithHost(swarmHost), docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	// The manager has been left out.
+	imgs, err := swarmClient.ImageList(
+		ctx,
+		types.ImageListOptions{Filters: filters.NewArgs(filters.Arg("reference", tag))},
+	)
+	require.NoError(t, err)
+	assert.Empty(t, imgs)
+
+	// Only the selected node misses the image.
+	result, err := sind.PushImageRefsWithOptions(ctx, hostClient, params.ClusterName, sind.PushOptions{Nodes: []string{"sind-test_push_subset-manager-0", "worker-1"}}, []string{tag})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"worker-1"}, result.Skipped)
+
+	imgs, err = swarmClient.ImageList(
+		ctx,
+		types.ImageListOptions{Filters: filters.NewArgs(filters.Arg("reference", tag))},
+	)
+	require.NoError(t, err)
+	require.Len(t, imgs, 1)
+
+	_, err = sind.PushImageRefsWithOptions(ctx, hostClient, params.ClusterName, sind.PushOptions{Nodes: []string{"worker-3"}}, []string{tag})
+	require.Error(t, err)
+}
@@ -0,0 +1,97 @@
+package test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	docker "github.com/docker/docker/client"
+	"github.com/jlevesy/sind/pkg/sind"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSindCanScaleACluster(t *testing.T) {
+	ctx := context.Background()
+
+	hostClient, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	params := sind.ClusterConfiguration{
+		ClusterName: "test_scale",
+		NetworkName: "test_scale",
+
+		Managers: 1,
+		Workers:  1,
+	}
+	require.NoError(t, sind.CreateCluster(ctx, hostClient, params))
+
+	defer func() {
+		require.NoError(t, sind.DeleteCluster(ctx, hostClient, params.ClusterName))
+	}()
+
+	swarmHost, err := sind.ClusterHost(ctx, hostClient, params.ClusterName)
+	require.NoError(t, err)
+
+	swarmClient, err := docker.NewClientWithOpts(docker.WithHost(swarmHost), docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	for _, shape := range []struct{ managers, workers uint16 }{{3, 3}, {1, 2}} {
+		require.NoError(t, sind.ScaleCluster(ctx, hostClient, params.ClusterName, shape.managers, shape.workers))
+
+		clusterInfos, err := sind.InspectCluster(ctx, hostClient, params.ClusterName)
+		require.NoError(t, err)
+
+		assert.EqualValues(t, shape.managers, clusterInfos.Managers)
+		assert.EqualValues(t, shape.workers, clusterInfos.Workers)
+
+		info, err := swarmClient.Info(ctx)
+		require.NoError(t, err)
+
+		assert.EqualValues(t, shape.managers, info.Swarm.Managers)
+		assert.EqualValues(t, shape.workers, info.Swarm.Nodes-info.Swarm.Managers)
+	}
+}
+
+func TestSindUpdatesTheLoadBalancerWhenScalingManagers(t *testing.T) {
+	ctx := context.Background()
+
+	hostClient, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	params := sind.ClusterConfiguration{
+		ClusterName: "test_scale_lb",
+		NetworkName: "test_scale_lb",
+
+		Managers: 1,
+
+		PortBindings: []string{"18081:8080"},
+		LoadBalancer: true,
+	}
+	require.NoError(t, sind.CreateCluster(ctx, hostClient, params))
+
+	defer func() {
+		require.NoError(t, sind.DeleteCluster(ctx, hostClient, params.ClusterName))
+	}()
+
+	upstreams := func() int {
+		lb, err := hostClient.ContainerInspect(ctx, "sind-test_scale_lb-lb")
+		require.NoError(t, err)
+
+		for _, env := range lb.Config.Env {
+			if strings.HasPrefix(env, "NGINX_CONFIG=") {
+				return strings.Count(env, "server 10.")
+			}
+		}
+
+		return 0
+	}
+
+	assert.Equal(t, 1, upstreams())
+
+	require.NoError(t, sind.ScaleCluster(ctx, hostClient, params.ClusterName, 3, 0))
+	assert.Equal(t, 3, upstreams())
+
+	require.NoError(t, sind.ScaleCluster(ctx, hostClient, params.ClusterName, 2, 0))
+	assert.Equal(t, 2, upstreams())
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
 	docker "github.com/docker/docker/client"
 	"github.com/jlevesy/sind/pkg/sind"
 	"github.com/stretchr/testify/assert"
@@ -64,3 +65,166 @@ func TestSindCanStopAndStartACluster(t *testing.T) {
 	assert.EqualValues(t, params.Managers, info.Swarm.Managers)
 	assert.EqualValues(t, params.Workers, info.Swarm.Nodes-info.Swarm.Managers)
 }
+
+func TestSindClusterIsHealthyAsSoonAsStartClusterReturns(t *testing.T) {
+	ctx := context.Background()
+
+	hostClient, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	params := sind.ClusterConfiguration{
+		ClusterName: "test_start_wait",
+		NetworkName: "test_start_wait",
+
+		Managers: 3,
+		Workers:  2,
+	}
+	require.NoError(t, sind.CreateCluster(ctx, hostClient, params))
+
+	defer func() {
+		require.NoError(t, sind.DeleteCluster(ctx, hostClient, params.ClusterName))
+	}()
+
+	require.NoError(t, sind.StopCluster(ctx, hostClient, params.ClusterName))
+	require.NoError(t, sind.StartClusterWithOptions(ctx, hostClient, params.ClusterName, sind.StartOptions{Wait: true}))
+
+	swarmHost, err := sind.ClusterHost(ctx, hostClient, params.ClusterName)
+	require.NoError(t, err)
+
+	swarmClient, err := docker.NewClientWithOpts(docker.WithHost(swarmHost), docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	// No retry here, the swarm has to be reachable and have all its nodes ready when StartCluster returns.
+	info, err := swarmClient.Info(ctx)
+	require.NoError(t, err)
+
+	require.True(t, info.Swarm.ControlAvailable)
+
+	assert.EqualValues(t, params.Managers, info.Swarm.Managers)
+	assert.EqualValues(t, params.Workers, info.Swarm.Nodes-info.Swarm.Managers)
+
+	nodes, err := swarmClient.NodeList(ctx, types.NodeListOptions{})
+	require.NoError(t, err)
+
+	for _, node := range nodes {
+		assert.Equal(t, swarm.NodeStateReady, node.Status.State)
+	}
+}
+
+func TestSindUnlocksAnAutolockedClusterOnStart(t *testing.T) {
+	ctx := context.Background()
+
+	hostClient, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	params := sind.ClusterConfiguration{
+		ClusterName: "test_autolock",
+		NetworkName: "test_autolock",
+
+		Managers: 3,
+		Workers:  1,
+
+		Swarm: sind.SwarmOptions{AutoLock: true},
+	}
+	require.NoError(t, sind.CreateCluster(ctx, hostClient, params))
+
+	defer func() {
+		require.NoError(t, sind.DeleteCluster(ctx, hostClient, params.ClusterName))
+	}()
+
+	key, err := sind.ClusterUnlockKey(ctx, hostClient, params.ClusterName)
+	require.NoError(t, err)
+	assert.NotEmpty(t, key)
+
+	require.NoError(t, sind.StopCluster(ctx, hostClient, params.ClusterName))
+	require.NoError(t, sind.StartClusterWithOptions(ctx, hostClient, params.ClusterName, sind.StartOptions{Wait: true}))
+
+	swarmHost, err := sind.ClusterHost(ctx, hostClient, params.ClusterName)
+	require.NoError(t, err)
+
+	swarmClient, err := docker.NewClientWithOpts(docker.WithHost(swarmHost), docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	var info types.Info
+
+	require.NoError(t, retry(30, time.Second, func() error { info, err = swarmClient.Info(ctx); return err }))
+
+	assert.Equal(t, swarm.LocalNodeStateActive, info.Swarm.LocalNodeState)
+}
+
+func TestSindCanDrainAndStopAClusterInOrder(t *testing.T) {
+	ctx := context.Background()
+
+	hostClient, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	params := sind.ClusterConfiguration{
+		ClusterName: "test_graceful_stop",
+		NetworkName: "test_graceful_stop",
+
+		Managers: 3,
+		Workers:  2,
+	}
+	require.NoError(t, sind.CreateCluster(ctx, hostClient, params))
+
+	defer func() {
+		require.NoError(t, sind.DeleteCluster(ctx, hostClient, params.ClusterName))
+	}()
+
+	stopTimeout := 5 * time.Second
+
+	require.NoError(t, sind.StopClusterWithOptions(ctx, hostClient, params.ClusterName, sind.StopOptions{Timeout: &stopTimeout, Ordered: true, Drain: true}))
+	require.NoError(t, sind.StartClusterWithOptions(ctx, hostClient, params.ClusterName, sind.StartOptions{Activate: true}))
+
+	clusterInfos, err := sind.InspectClusterWithSwarm(ctx, hostClient, params.ClusterName)
+	require.NoError(t, err)
+
+	for _, node := range clusterInfos.Swarm {
+		assert.Equal(t, string(swarm.NodeAvailabilityActive), node.Availability)
+	}
+}
+
+func TestSindRestoresTheAvailabilityOfDrainedNodesOnly(t *testing.T) {
+	ctx := context.Background()
+
+	hostClient, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	params := sind.ClusterConfiguration{
+		ClusterName: "test_drain_restore",
+		NetworkName: "test_drain_restore",
+
+		Managers: 1,
+		Workers:  2,
+	}
+	require.NoError(t, sind.CreateCluster(ctx, hostClient, params))
+
+	defer func() {
+		require.NoError(t, sind.DeleteCluster(ctx, hostClient, params.ClusterName))
+	}()
+
+	require.NoError(t, sind.SetNodeAvailability(ctx, hostClient, params.ClusterName, "worker-0", swarm.NodeAvailabilityPause))
+
+	require.NoError(t, sind.StopClusterWithOptions(ctx, hostClient, params.ClusterName, sind.StopOptions{Drain: true}))
+	require.NoError(t, sind.StartClusterWithOptions(ctx, hostClient, params.ClusterName, sind.StartOptions{Activate: true}))
+
+	swarmHost, err := sind.ClusterHost(ctx, hostClient, params.ClusterName)
+	require.NoError(t, err)
+
+	swarmClient, err := docker.NewClientWithOpts(docker.WithHost(swarmHost), docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	nodes, err := swarmClient.NodeList(ctx, types.NodeListOptions{})
+	require.NoError(t, err)
+	require.Len(t, nodes, 3)
+
+	for _, node := range nodes {
+		expected := swarm.NodeAvailabilityActive
+		if node.Description.Hostname == "sind-test_drain_restore-worker-0" {
+			expected = swarm.NodeAvailabilityPause
+		}
+
+		assert.Equal(t, expected, node.Spec.Availability, node.Description.Hostname)
+		assert.Empty(t, node.Spec.Labels, node.Description.Hostname)
+	}
+}
@@ -0,0 +1,64 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	docker "github.com/docker/docker/client"
+	"github.com/jlevesy/sind/pkg/sind"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSindCanUpgradeAClusterNodeImage(t *testing.T) {
+	ctx := context.Background()
+
+	hostClient, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	params := sind.ClusterConfiguration{
+		ClusterName: "test_upgrade",
+		NetworkName: "test_upgrade",
+
+		Managers: 2,
+		Workers:  1,
+
+		ImageName: "docker:19.03-dind",
+		Nodes: map[string]sind.NodeConfiguration{
+			"worker-0": {Labels: map[string]string{"zone": "a"}},
+		},
+	}
+	require.NoError(t, sind.CreateCluster(ctx, hostClient, params))
+
+	defer func() {
+		require.NoError(t, sind.DeleteCluster(ctx, hostClient, params.ClusterName))
+	}()
+
+	before, err := sind.InspectCluster(ctx, hostClient, params.ClusterName)
+	require.NoError(t, err)
+
+	require.NoError(t, sind.UpgradeCluster(ctx, hostClient, params.ClusterName, sind.DefaultNodeImageName))
+
+	after, err := sind.InspectClusterWithSwarm(ctx, hostClient, params.ClusterName)
+	require.NoError(t, err)
+
+	assert.Equal(t, sind.ClusterStateRunning, after.State())
+	assert.Equal(t, before.Managers, after.Managers)
+	assert.Equal(t, before.Workers, after.Workers)
+
+	for _, node := range after.Nodes {
+		assert.Equal(t, sind.DefaultNodeImageName, node.Image)
+		assert.Contains(t, node.Status, "Up")
+	}
+
+	swarmHost, err := sind.ClusterHost(ctx, hostClient, params.ClusterName)
+	require.NoError(t, err)
+
+	swarmClient, err := docker.NewClientWithOpts(docker.WithHost(swarmHost), docker.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+
+	node, _, err := swarmClient.NodeInspectWithRaw(ctx, "sind-test_upgrade-worker-0")
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{"zone": "a"}, node.Spec.Labels)
+}